@@ -18,8 +18,21 @@ func (af Airframe)String() string {
 		af.Icao24, af.Registration,	af.CallsignPrefix, af.EquipmentType)
 }
 
+// OverlayAirframe fills in any airframe fields that the flight doesn't have. The flight remembers
+// which fields were filled this way (see AirframeFieldsFromOverlay), as they didn't come from
+// the stored flight.
 func (f *Flight)OverlayAirframe(af Airframe) {
-	if f.Airframe.Registration == ""   { f.Airframe.Registration = af.Registration }
-	if f.Airframe.EquipmentType == ""  { f.Airframe.EquipmentType = af.EquipmentType }
-	if f.Airframe.CallsignPrefix == "" { f.Airframe.CallsignPrefix = af.CallsignPrefix }
+	overlay := func(field string, dst *string, src string) {
+		if *dst == "" && src != "" {
+			*dst = src
+			f.airframeOverlaid = append(f.airframeOverlaid, field)
+		}
+	}
+	overlay("Registration",   &f.Airframe.Registration,   af.Registration)
+	overlay("EquipmentType",  &f.Airframe.EquipmentType,  af.EquipmentType)
+	overlay("CallsignPrefix", &f.Airframe.CallsignPrefix, af.CallsignPrefix)
 }
+
+// AirframeFieldsFromOverlay lists the airframe fields that were filled in by OverlayAirframe;
+// any other non-empty airframe fields came from the stored flight.
+func (f *Flight)AirframeFieldsFromOverlay() []string { return f.airframeOverlaid }
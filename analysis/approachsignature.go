@@ -15,6 +15,7 @@ import (
 func init() {
 	report.HandleReport("approachsignature", ApproachSignature,
 		"Signature for SFO approaches, only when equip has prefix {str}")
+	report.TrackSpec("approachsignature", fdb.NewTrackSpec("ADSB", "MLAT", "FOIA"))
 }
 
 func ApproachSignature(r *report.Report, f *fdb.Flight, tis []fdb.TrackIntersection) (report.FlightReportOutcome, error){
@@ -43,7 +44,7 @@ func ApproachSignature(r *report.Report, f *fdb.Flight, tis []fdb.TrackIntersect
 		}
 	}
 
-	trackName,track := f.PreferredTrack([]string{"ADSB", "MLAT", "FOIA"})
+	trackName,track := r.TrackByRestrictedSpec(f)
 	if trackName == "" {
 		r.I["[D] Skipped, no ADSB, MLAT or FOIA track avail"]++
 		return report.RejectedByReport,nil
@@ -18,7 +18,7 @@ import (
 
 func init() {
 	report.HandleReport("sfoclassb", SFOClassBReporter, "SFO Class B excursions (use EDDYY tag)")
-	report.TrackSpec("sfoclassb", []string{"ADSB","FA", "FOIA"}) // That's all we'll accept
+	report.TrackSpec("sfoclassb", fdb.NewTrackSpec("ADSB","FA", "FOIA")) // That's all we'll accept
 }
 
 
@@ -34,7 +34,7 @@ func ClassBForTrack(r *report.Report, track fdb.Track) (*geo.TPClassBAnalysis,er
 		iAlt := tp.Altitude
 		inchesHg := 29.9213
 		
-		if tp.DataSource == "RG-FOIA" {
+		if tp.FOIADataset() == "RG-FOIA" {
 			// iAlt is already pressure corrected
 			track[i].AnalysisAnnotation += fmt.Sprintf("* FOIA data, no altitude correction performed\n")
 			
@@ -100,7 +100,7 @@ func SFOClassBReporter(r *report.Report, f *fdb.Flight, tis []fdb.TrackIntersect
 	}
 
 	// For Class B, we're very picky about data sources.
-	typePicked,track := f.PreferredTrack([]string{"ADSB", "FA", "FOIA"})
+	typePicked,track := r.TrackByRestrictedSpec(f)
 	if typePicked == "" {
 		r.I["[D] Skipped, no ADSB, FA or FOIA track avail"]++
 		return report.RejectedByReport,nil
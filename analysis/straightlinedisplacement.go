@@ -13,6 +13,7 @@ import (
 func init() {
 	report.HandleReport("straightlinedisplacement", StraightLineDisplacementReporter,
 		"Lateral displacement from the line {refpoint} to {refpoint2}")
+	report.TrackSpec("straightlinedisplacement", fdb.NewTrackSpec("ADSB", "MLAT", "FOIA"))
 }
 
 func StraightLineDisplacementReporter(r *report.Report, f *fdb.Flight, tis []fdb.TrackIntersection) (report.FlightReportOutcome, error){
@@ -32,7 +33,7 @@ func StraightLineDisplacementReporter(r *report.Report, f *fdb.Flight, tis []fdb
 		}
 	}
 
-	typePicked,track := f.PreferredTrack([]string{"ADSB", "MLAT", "FOIA"})
+	typePicked,track := r.TrackByRestrictedSpec(f)
 	if typePicked == "" {
 		r.I["[D] Skipped, no ADSB or FOIA track avail"]++
 		return report.RejectedByReport,nil
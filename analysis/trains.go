@@ -15,7 +15,7 @@ import (
 func init() {
 //	report.HandleReport("trains", TrainsReporter, "Flight Trains; within {duration}, when within {dist} of {refpoint}")
 //	report.SummarizeReport("trains", TrainsSummarizer)
-//	report.TrackSpec("trains", []string{"FA", "fr24"}) // *Not* ADSB; need data over ocean
+//	report.TrackSpec("trains", fdb.NewTrackSpec("FA", "fr24")) // *Not* ADSB; need data over ocean
 }
 
 // The few things we store about a flight
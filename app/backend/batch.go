@@ -5,6 +5,16 @@ package main
 
 // http://fdb.serfr1.org/batch/flights/dates?job=retag&date=yesterday&tags=:SFO
 
+// http://fdb.serfr1.org/batch/flights/dates?job=reencode&date=range&range_from=2017/01/01&range_to=2017/01/31
+
+// http://fdb.serfr1.org/batch/flights/dates?job=datafields&date=range&range_from=2014/01/01&range_to=2017/12/31
+
+// http://fdb.serfr1.org/batch/flights/dates?job=cells&date=range&range_from=2014/01/01&range_to=2017/12/31
+
+// http://fdb.serfr1.org/batch/flights/day?job=dedupe&day=2017/01/31&dryrun=1
+
+// http://fdb.serfr1.org/batch/flights/day?job=condense&day=2017/01/31
+
 import (
 	"fmt"
 	"net/http"
@@ -143,6 +153,15 @@ func batchFlightDayHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Requ
 	start,end := date.WindowForTime(day)
 	end = end.Add(-1 * time.Second)
 	
+	// Some jobs look at the whole day at once, rather than one flight at a time
+	if job == "dedupe" {
+		batchDedupeDay(db, w, r, start, end)
+		return
+	} else if job == "condense" {
+		batchCondenseDay(db, w, r, start, end)
+		return
+	}
+
 	q := fgae.QueryForTimeRange(tags,start,end)
 	keyers,err := db.LookupAllKeys(q)
 	if err != nil {
@@ -206,12 +225,19 @@ func batchFlightHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// You now have a job name, and a flight object. Get to it !	
+	// For jobs that change what's in the flight, keep the old versions around, in case the job
+	// mangles anything (jobs that only change how it's stored don't need them)
 	job := r.FormValue("job")
+	db.KeepRevisions = (job == "retag" || job == "breakup")
+
+	// You now have a job name, and a flight object. Get to it !	
 	str := ""
 	switch job {
 	case "retag":         str,err = jobRetagHandler(db,f)
 	case "breakup":       str,err = jobMaybeBreakupFlight(db,f)
+	case "reencode":      str,err = jobReencodeHandler(db,f)
+	case "datafields":    str,err = jobDataFieldsHandler(db,f)
+	case "cells":         str,err = jobCellsHandler(db,f)
 	}
 
 	if err != nil {
@@ -229,9 +255,64 @@ func batchFlightHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request
 
 // }}}
 
+// {{{ batchDedupeDay
+
+// /batch/flights/day?job=dedupe&day=2016/01/21
+//   &dryrun=1  (just report what would be merged)
+
+// Merges any duplicate flights in the day. The report goes to the logs as well as the response,
+// so grep the logs for 'dedupe' to see what a whole batch merged.
+func batchDedupeDay(db fgae.FlightDB, w http.ResponseWriter, r *http.Request, start,end time.Time) {
+	dryrun := r.FormValue("dryrun") != ""
+
+	// Keep the old versions of the merged flights around (including the ones that get deleted),
+	// so that a wrong merge can be rolled back
+	db.KeepRevisions = true
+
+	merges,err := db.MergeDuplicates(start, end, dryrun)
+	str := fmt.Sprintf("* start: %s\n* end  : %s\n* dryrun: %v\n* merges: %d\n\n",
+		start, end, dryrun, len(merges))
+	for _,dm := range merges {
+		str += dm.String()
+	}
+
+	if err != nil {
+		db.Errorf("dedupe %s: %v\n%s", start.Format("2006/01/02"), err, str)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.Infof("dedupe %s:\n%s", start.Format("2006/01/02"), str)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("OK, dedupe\n%s", str)))
+}
+
+// }}}
+// {{{ batchCondenseDay
+
+// /batch/flights/day?job=condense&day=2016/01/21
+
+// Rebuilds the condensed flight rollups for the day; only needed for flights that were persisted
+// before the rollups existed, as PersistFlight keeps them up to date.
+func batchCondenseDay(db fgae.FlightDB, w http.ResponseWriter, r *http.Request, start,end time.Time) {
+	n,err := db.RebuildCondensedRollups(start, end)
+	if err != nil {
+		db.Errorf("condense %s: %v", start.Format("2006/01/02"), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("OK, condense\n* start: %s\n* end  : %s\n* flights: %d\n",
+		start, end, n)))
+}
+
+// }}}
+
 // {{{ jobRetagHandler
 
-// Note; this never removes any tags. It only adds new ones.
+// Note; this mostly adds tags, but the phase, runway and go-around tags (and AL/GA) are reset
+// to match the rest of the analysis, so those can go away.
 func jobRetagHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
 	str := fmt.Sprintf("OK\nbatch, for [%s]\n", f)
 	
@@ -239,6 +320,13 @@ func jobRetagHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
 	str += fmt.Sprintf("* Pre Tags: %v\n", f.TagList())
 	str += fmt.Sprintf("* Pre IndexingTags: %v\n", f.IndexTagList())
 
+	// Older flights may have landed before we knew their schedule
+	if changed,err := db.MaybeAddScheduleAt(f, f.MidTime(), nil); err != nil {
+		str += fmt.Sprintf("* Schedule lookup failed: %v\n", err)
+	} else if changed {
+		str += fmt.Sprintf("* Added schedule: %s\n", f.FullString())
+	}
+
 	f.Analyse()
 
 	str += fmt.Sprintf("\n* Post WP: %v\n", f.WaypointList())
@@ -249,7 +337,7 @@ func jobRetagHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
 	
 	// Forces rewrite in all cases; change the guard to be more selective.
 	if true {
-		if err := db.PersistFlight(f); err != nil {
+		if err := db.PersistFlightWithReason(f, "retag"); err != nil {
 			str += fmt.Sprintf("* Failed, with: %v\n", err)	
 			db.Errorf("%s", str)
 			return str, err
@@ -260,6 +348,88 @@ func jobRetagHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
 	return str, nil
 }
 
+// }}}
+// {{{ jobReencodeHandler
+
+// Rewrites the flight with the current DefaultBlobEncoding, and reports how much space that
+// saved. Grep the logs for 'reencode' to tot up the savings across a batch.
+func jobReencodeHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
+	keyer,err := db.Backend.DecodeKey(f.GetDatastoreKey())
+	if err != nil { return "", err }
+
+	oldBlob,err := db.LookupBlob(keyer)
+	if err != nil { return "", err }
+	if oldBlob.BlobEncoding == fdb.DefaultBlobEncoding {
+		return fmt.Sprintf("* already has encoding %d, nothing to do\n", oldBlob.BlobEncoding), nil
+	}
+
+	newBlob,err := f.ToBlob()
+	if err != nil { return "", err }
+
+	oldSize,newSize := len(oldBlob.Blob), len(newBlob.Blob)
+	pct := 0.0
+	if oldSize > 0 { // Flights can be stored with an empty blob; don't divide by zero
+		pct = 100.0 * float64(oldSize-newSize) / float64(oldSize)
+	}
+	str := fmt.Sprintf("* reencode %d->%d: %d -> %d bytes (saved %d, %.1f%%)\n",
+		oldBlob.BlobEncoding, newBlob.BlobEncoding, oldSize, newSize, oldSize-newSize, pct)
+
+	if err := db.PersistFlightWithReason(f, "reencode"); err != nil {
+		str += fmt.Sprintf("* Failed, with: %v\n", err)	
+		return str, err
+	}
+	db.Infof("%s", str)
+
+	return str, nil
+}
+
+// }}}
+// {{{ jobDataFieldsHandler
+
+// Fills in DataSystem and DataProvider on trackpoints that predate those fields, so that
+// readers no longer need to fall back to interpreting DataSource. Flights that are already
+// up to date are left alone.
+func jobDataFieldsHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
+	if !f.BackfillDataFields() {
+		return "* data fields already present, nothing to do\n", nil
+	}
+
+	str := fmt.Sprintf("* backfilled data fields, tracks %v\n", f.ListTracks())
+	if err := db.PersistFlightWithReason(f, "datafields"); err != nil {
+		str += fmt.Sprintf("* Failed, with: %v\n", err)
+		return str, err
+	}
+	db.Infof("%s", str)
+
+	return str, nil
+}
+
+// }}}
+// {{{ jobCellsHandler
+
+// Rewrites flights that were persisted before footprint cells were indexed, so that queries
+// using FQuery.ByRestrictor can find them (which also needs a StartTime). Once a range of days
+// has been done, move the 'cells.backfilledfrom' config back to cover it.
+func jobCellsHandler(db fgae.FlightDB, f *fdb.Flight) (string, error) {
+	keyer,err := db.Backend.DecodeKey(f.GetDatastoreKey())
+	if err != nil { return "", err }
+
+	oldBlob,err := db.LookupBlob(keyer)
+	if err != nil { return "", err }
+	if len(oldBlob.Cells) > 0 && !oldBlob.StartTime.IsZero() {
+		return fmt.Sprintf("* already has %d cells, nothing to do\n", len(oldBlob.Cells)), nil
+	}
+
+	str := fmt.Sprintf("* indexing %d cells\n", len(f.IndexCellList()))
+	if err := db.PersistFlightWithReason(f, "cells"); err != nil {
+		str += fmt.Sprintf("* Failed, with: %v\n", err)
+		return str, err
+	}
+	db.Infof("%s", str)
+
+	return str, nil
+}
+
 // }}}
 // {{{ jobMaybeBreakupFlight
 
@@ -351,12 +521,12 @@ func jobMaybeBreakupFlight(db fgae.FlightDB, f *fdb.Flight) (string, error) {
 					str += fmt.Sprintf(" ++ [%-7.7s] %s\n", k, v)
 				}
 				
-				if err := db.PersistFlight(f); err != nil {
+				if err := db.PersistFlightWithReason(f, "breakup"); err != nil {
 					str += fmt.Sprintf("* Failed1, with: %v\n", err)	
 					db.Errorf("%s", str)
 					return str, err
 				}
-				if err := db.PersistFlight(maybeF); err != nil {
+				if err := db.PersistFlightWithReason(maybeF, "breakup"); err != nil {
 					str += fmt.Sprintf("* Failed2, with: %v\n", err)	
 					db.Errorf("%s", str)
 					return str, err
@@ -388,7 +558,7 @@ func jobMaybeBreakupFlight(db fgae.FlightDB, f *fdb.Flight) (string, error) {
 		f.Analyse()
 		newF.Analyse()
 		
-		if err := db.PersistFlight(f); err != nil {
+		if err := db.PersistFlightWithReason(f, "breakup"); err != nil {
 			str += fmt.Sprintf("* Failed3, with: %v\n", err)	
 			db.Errorf("%s", str)
 			return str, err
@@ -13,6 +13,7 @@ import(
 	hw "github.com/skypies/util/handlerware"
 
 	"github.com/skypies/flightdb/config"
+	"github.com/skypies/flightdb/fgae"
 	"github.com/skypies/flightdb/ui"
 )
 
@@ -24,14 +25,17 @@ func init() {
 	hw.RequireTls = false
 	hw.InitTemplates("app/web/templates") // relative to go module root, which is git repo root
 
+	// A Transactor, so that FlightDB.RunInTransaction really does run transactions. It holds
+	// open datastore clients, so make just the one, and share it between requests.
+	p,err := fgae.NewCloudTransactor(context.Background(), GoogleCloudProjectId)
+	if err != nil {
+		panic(fmt.Errorf("NewDB: could not get a cloudtransactor (projectId=%s): %v\n", GoogleCloudProjectId, err))
+	}
+
 	// This is the routine that creates new contexts, and injects a provider into them,
 	// as required by the FdbHandlers
 	hw.CtxMakerCallback = func(r *http.Request) context.Context {
 		ctx,_ := context.WithTimeout(r.Context(), 595 * time.Second)
-		p,err := ds.NewCloudDSProvider(ctx, GoogleCloudProjectId)
-		if err != nil {
-			panic(fmt.Errorf("NewDB: could not get a clouddsprovider (projectId=%s): %v\n", GoogleCloudProjectId, err))
-		}
 		return ds.SetProvider(ctx, p)
 	}
 
@@ -41,8 +45,31 @@ func init() {
   hw.NoSessionHandler = loginRedirectHandler // redirects to frontend app, which has all the login config
   hw.InitGroup(hw.AdminGroup, config.Get("users.admin"))
 
+	// Publish flight changes, if there's somewhere configured to send them (see fgae/changefeed.go)
+	if sink,err := fgae.NewChangeSink(config.Get("changes.sink")); err != nil {
+		panic(err)
+	} else {
+		ui.Changes = sink
+	}
+
+	// Look for old flights in the archives, if there are any configured (see fgae/archive.go)
+	if store,err := fgae.NewArchiveStore(context.Background(), config.Get("archive.store")); err != nil {
+		panic(err)
+	} else {
+		ui.Archive = store
+	}
+
+	// How far back flights have been given cells, so reports can filter on them (see fgae/fquery.go)
+	if str := config.Get("cells.backfilledfrom"); str != "" {
+		if t,err := time.Parse("2006-01-02", str); err != nil {
+			panic(fmt.Errorf("cells.backfilledfrom: %v", err))
+		} else {
+			fgae.KCellsBackfilledFrom = t
+		}
+	}
+
 	// ui/report - we host it here, to get batch server timeouts
-	http.HandleFunc("/report",                    ui.WithFdbSession(ui.ReportHandler))
+	http.HandleFunc("/report",                    ui.WithFdbSession(ui.WithAirframes(ui.ReportHandler)))
 
 	// backend/batch.go
 	http.HandleFunc("/batch/flights/dates",       ui.WithFdb(batchFlightDateRangeHandler))
@@ -63,13 +90,13 @@ func init() {
 	// will prevent any of these URLs coming to this app when it is deployed to appengine
 
 	// ui/api.go
-	http.HandleFunc("/fdb/vector",          ui.WithFdb(ui.VectorHandler))
-	http.HandleFunc("/api/flight/lookup",   ui.WithFdb(ui.FlightLookupHandler))
-	http.HandleFunc("/api/procedures",      ui.WithFdb(ui.ProcedureHandler))
+	http.HandleFunc("/fdb/vector",          ui.WithFdb(ui.WithAirframes(ui.VectorHandler)))
+	http.HandleFunc("/api/flight/lookup",   ui.WithFdb(ui.WithAirframes(ui.FlightLookupHandler)))
+	http.HandleFunc("/api/procedures",      ui.WithFdb(ui.WithAirframes(ui.ProcedureHandler)))
 
 	// ui/tracks.go
-	http.HandleFunc("/fdb/tracks",          ui.WithFdb(ui.TrackHandler))
-	http.HandleFunc("/fdb/trackset",        ui.WithFdb(ui.TracksetHandler))
+	http.HandleFunc("/fdb/tracks",          ui.WithFdb(ui.WithAirframes(ui.TrackHandler)))
+	http.HandleFunc("/fdb/trackset",        ui.WithFdb(ui.WithAirframes(ui.TracksetHandler)))
 
 	// ui/map.go
 	http.HandleFunc("/fdb/map",             hw.WithCtx(ui.MapHandler))
@@ -90,20 +117,20 @@ func init() {
 	http.HandleFunc(stem+"/gr/delete",      ui.WithFdbSession(ui.RGrDeleteHandler))
 
 	// ui/historical.go
-	http.HandleFunc("/fdb/historical",      ui.WithFdb(ui.HistoricalHandler))
+	http.HandleFunc("/fdb/historical",      ui.WithFdb(ui.WithAirframes(ui.HistoricalHandler)))
 
 	// ui/json.go
-	http.HandleFunc("/fdb/json",            ui.WithFdb(ui.JsonHandler))
+	http.HandleFunc("/fdb/json",            ui.WithFdb(ui.WithAirframes(ui.JsonHandler)))
 	http.HandleFunc("/fdb/snarf",           ui.WithFdb(ui.SnarfHandler))
 
 	// ui/lists.go
-	http.HandleFunc("/fdb/list",            ui.WithFdb(ui.ListHandler))
+	http.HandleFunc("/fdb/list",            ui.WithFdb(ui.WithAirframes(ui.ListHandler)))
 
 	// ui/sideview.go
-	http.HandleFunc("/fdb/sideview",        ui.WithFdb(ui.SideviewHandler))
+	http.HandleFunc("/fdb/sideview",        ui.WithFdb(ui.WithAirframes(ui.SideviewHandler)))
 
 	// ui/visualize.go
-	http.HandleFunc("/fdb/visualize",       ui.WithFdb(ui.VisualizeHandler))
+	http.HandleFunc("/fdb/visualize",       ui.WithFdb(ui.WithAirframes(ui.VisualizeHandler)))
 }
 
 func main() {
@@ -80,13 +80,29 @@ func updateScheduleCache(db fgae.FlightDB, resp []fdb.FlightSnapshot) error {
 	ctx := db.Ctx()
 
 	if len(resp) == 0 { return nil } // Don't overwrite in cases of error
+
+	// We need the previous version, to spot schedules that have started or ended
+	prev,err := ref.LoadScheduleCache(ctx, db.SingletonProvider)
+	if err != nil {
+		db.Warningf("updateScheduleCache/Load: %v", err)
+		blank := ref.BlankScheduleCache()
+		prev = &blank
+	}
 	
 	sc := ref.BlankScheduleCache()
 	for i,_ := range resp {
 		sc.Map[resp[i].IcaoId] = &resp[i]
 	}
 	sc.LastUpdated = time.Now()
+	sc.CarrySince(prev)
 	
+	// Record the history first; if the cache got saved without it, the next poll would diff
+	// against the new cache, and the history would never hear about these changes
+	if err := db.RecordScheduleHistory(prev, &sc); err != nil {
+		db.Errorf("updateScheduleCache/History: %v", err)
+		return err
+	}
+
 	if err := sc.SaveScheduleCache(ctx, db.SingletonProvider); err != nil {
 		db.Errorf("updateScheduleCache/Persist: %v", err)
 		return err
@@ -148,7 +164,7 @@ func fr24PollHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	fr,_ := fr24.NewFr24(db.HTTPClient())
 
 	// TODO: override the db.SingletonProvider with a combo-memcache one.
-	
+
 	db.Perff("fr24Poll_100", "making call")
 	flights,err := fr.LookupCurrentList(sfo.KLatlongSFO.Box(320,320))
 	if err != nil {
@@ -15,6 +15,7 @@ import(
 
 	_ "github.com/skypies/flightdb/analysis" // populate the reports registry
 	"github.com/skypies/flightdb/config"
+	"github.com/skypies/flightdb/fgae"
 	"github.com/skypies/flightdb/ui"
 )
 
@@ -26,13 +27,16 @@ func init() {
 	hw.RequireTls = false
 	hw.InitTemplates("app/web/templates") // location relative to go module root, which is git repo root
 
+	// A Transactor, so that FlightDB.RunInTransaction really does run transactions. It holds
+	// open datastore clients, so make just the one, and share it between requests.
+	p,err := fgae.NewCloudTransactor(context.Background(), GoogleCloudProjectId)
+	if err != nil {
+		panic(fmt.Errorf("NewDB: could not get a cloudtransactor (projectId=%s): %v\n", GoogleCloudProjectId, err))
+	}
+
 	// The FdbHandlers expect to find a DSProvider in the context
 	hw.CtxMakerCallback = func(r *http.Request) context.Context {
 		ctx,_ := context.WithTimeout(r.Context(), 55 * time.Second)
-		p,err := ds.NewCloudDSProvider(ctx, GoogleCloudProjectId)
-		if err != nil {
-			panic(fmt.Errorf("NewDB: could not get a clouddsprovider (projectId=%s): %v\n", GoogleCloudProjectId, err))
-		}
 		return ds.SetProvider(ctx, p)
 	}
 
@@ -42,6 +46,20 @@ func init() {
 	hw.InitSessionStore(config.Get("sessions.key"), config.Get("sessions.prevkey"))
   hw.InitGroup(hw.AdminGroup, config.Get("users.admin"))
 
+	// Publish flight changes, if there's somewhere configured to send them (see fgae/changefeed.go)
+	if sink,err := fgae.NewChangeSink(config.Get("changes.sink")); err != nil {
+		panic(err)
+	} else {
+		ui.Changes = sink
+	}
+
+	// Look for old flights in the archives, if there are any configured (see fgae/archive.go)
+	if store,err := fgae.NewArchiveStore(context.Background(), config.Get("archive.store")); err != nil {
+		panic(err)
+	} else {
+		ui.Archive = store
+	}
+
 	login.OnSuccessCallback = func(w http.ResponseWriter, r *http.Request, email string) error {
 		hw.CreateSession(r.Context(), w, r, hw.UserSession{Email:email})
 		return nil
@@ -66,13 +84,17 @@ func init() {
 	http.HandleFunc("/",                    hw.WithCtx(RealtimeAirspaceHandler))
 
 	// ui/api.go
-	http.HandleFunc("/fdb/vector",          ui.WithFdb(ui.VectorHandler))
-	http.HandleFunc("/api/flight/lookup",   ui.WithFdb(ui.FlightLookupHandler))
-	http.HandleFunc("/api/procedures",      ui.WithFdb(ui.ProcedureHandler))
+	http.HandleFunc("/fdb/vector",          ui.WithFdb(ui.WithAirframes(ui.VectorHandler)))
+	http.HandleFunc("/api/flight/lookup",   ui.WithFdb(ui.WithAirframes(ui.FlightLookupHandler)))
+	http.HandleFunc("/api/procedures",      ui.WithFdb(ui.WithAirframes(ui.ProcedureHandler)))
+
+	// ui/revisions.go
+	http.HandleFunc("/api/flight/revisions", ui.WithFdb(ui.FlightRevisionsHandler))
+	http.HandleFunc("/api/flight/rollback",  ui.WithFdbAdmin(ui.FlightRollbackHandler))
 
 	// ui/tracks.go
-	http.HandleFunc("/fdb/tracks",          ui.WithFdb(ui.TrackHandler))
-	http.HandleFunc("/fdb/trackset",        ui.WithFdb(ui.TracksetHandler))
+	http.HandleFunc("/fdb/tracks",          ui.WithFdb(ui.WithAirframes(ui.TrackHandler)))
+	http.HandleFunc("/fdb/trackset",        ui.WithFdb(ui.WithAirframes(ui.TracksetHandler)))
 
 	// ui/map.go
 	http.HandleFunc("/fdb/map",             hw.WithCtx(ui.MapHandler))
@@ -88,25 +110,29 @@ func init() {
 	http.HandleFunc(stem+"/grs/delete",     ui.WithFdbSession(ui.RGrsDeleteHandler))
 	http.HandleFunc(stem+"/grs/edit",       ui.WithFdbSession(ui.RGrsEditHandler))
 	http.HandleFunc(stem+"/grs/view",       ui.WithFdbSession(ui.RGrsViewHandler))
+	http.HandleFunc(stem+"/grs/export",     ui.WithFdbSession(ui.RGrsExportHandler))
+	http.HandleFunc(stem+"/grs/import",     ui.WithFdbSession(ui.RGrsImportHandler))
+	http.HandleFunc(stem+"/grs/versions",   ui.WithFdbSession(ui.RGrsVersionsHandler))
+	http.HandleFunc(stem+"/grs/restore",    ui.WithFdbSession(ui.RGrsRestoreHandler))
 	http.HandleFunc(stem+"/gr/new",         ui.WithFdbSession(ui.RGrNewHandler))
 	http.HandleFunc(stem+"/gr/edit",        ui.WithFdbSession(ui.RGrEditHandler))
 	http.HandleFunc(stem+"/gr/delete",      ui.WithFdbSession(ui.RGrDeleteHandler))
 
 	// ui/historical.go
-	http.HandleFunc("/fdb/historical",      ui.WithFdb(ui.HistoricalHandler))
+	http.HandleFunc("/fdb/historical",      ui.WithFdb(ui.WithAirframes(ui.HistoricalHandler)))
 
 	// ui/json.go
-	http.HandleFunc("/fdb/json",            ui.WithFdb(ui.JsonHandler))
+	http.HandleFunc("/fdb/json",            ui.WithFdb(ui.WithAirframes(ui.JsonHandler)))
 	http.HandleFunc("/fdb/snarf",           ui.WithFdb(ui.SnarfHandler))
 
 	// ui/lists.go
-	http.HandleFunc("/fdb/list",            ui.WithFdb(ui.ListHandler))
+	http.HandleFunc("/fdb/list",            ui.WithFdb(ui.WithAirframes(ui.ListHandler)))
 
 	// ui/sideview.go
-	http.HandleFunc("/fdb/sideview",        ui.WithFdb(ui.SideviewHandler))
+	http.HandleFunc("/fdb/sideview",        ui.WithFdb(ui.WithAirframes(ui.SideviewHandler)))
 
 	// ui/visualize.go
-	http.HandleFunc("/fdb/visualize",       ui.WithFdb(ui.VisualizeHandler))
+	http.HandleFunc("/fdb/visualize",       ui.WithFdb(ui.WithAirframes(ui.VisualizeHandler)))
 	
 	// fr24poller.go
 	http.HandleFunc("/api/fr24",            ui.WithFdbAdmin(fr24PollHandler))
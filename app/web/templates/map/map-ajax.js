@@ -56,6 +56,9 @@ function StreamVectors() {
         {{if .Report}}
         url += '&'+{{.Report.QuotedCGIArgs}};
         {{end}}
+        {{if .Simplification.IsSet}}
+        url += '&'+{{.Simplification.QuotedCGIArgs}};
+        {{end}}
         var detailsText = '<a target="_blank" href="/fdb/tracks?idspec='+idspec+'">['+i+'] '+
             idspec+'</a>';
         $.getJSON( url, generateUrlConsumingFunction(detailsText) );
@@ -1,10 +1,18 @@
 package flightdb
 
 import(
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
+	"time"
 )
 
+// The original archive format; a gob of all the blobs for a day. To get at one flight, you need
+// to read and decode the lot.
+
 func MarshalBlobSlice(blobs []IndexedFlightBlob, w io.Writer) error {
 	return gob.NewEncoder(w).Encode(blobs)
 }
@@ -18,3 +26,160 @@ func UnmarshalBlobSlice(r io.Reader) ([]IndexedFlightBlob, error) {
 
 	return blobs, nil
 }
+
+// The random-access archive format. The manifest up front says where each flight lives, and
+// carries enough of its index fields to decide if it's worth reading:
+//
+//   magic     [8]byte   "FDBARC02"
+//   size      uint64    (big-endian) size of the manifest
+//   manifest            gzipped gob of an ArchiveManifest
+//   entries             each a gzipped gob of an IndexedFlightBlob, back to back
+//
+// Entry offsets in the manifest are relative to the first entry.
+
+const kArchiveMagic = "FDBARC02"
+
+type ArchiveEntry struct {
+	Key              string  // The flight's datastore key, when it was archived
+	Icao24           string
+	Ident            string
+	Timeslots      []time.Time
+	Tags           []string
+	Offset           int64
+	Length           int64
+}
+
+type ArchiveManifest struct {
+	Entries        []ArchiveEntry
+	dataStart        int64   // Where the first entry starts, in the file
+}
+
+// {{{ MarshalArchive
+
+// MarshalArchive writes the blobs out in the random-access format; keys are the datastore keys
+// of the blobs (or empty strings, if unknown).
+func MarshalArchive(blobs []IndexedFlightBlob, keys []string, w io.Writer) error {
+	if len(keys) != len(blobs) {
+		return fmt.Errorf("MarshalArchive: %d blobs, but %d keys", len(blobs), len(keys))
+	}
+
+	// Compress each entry first, so we know where they'll all end up
+	m := ArchiveManifest{}
+	var data bytes.Buffer
+	for i,blob := range blobs {
+		b,err := gzipGob(blob)
+		if err != nil { return fmt.Errorf("MarshalArchive: %v", err) }
+		m.Entries = append(m.Entries, ArchiveEntry{
+			Key: keys[i],
+			Icao24: blob.Icao24,
+			Ident: blob.Ident,
+			Timeslots: blob.Timeslots,
+			Tags: blob.Tags,
+			Offset: int64(data.Len()),
+			Length: int64(len(b)),
+		})
+		data.Write(b)
+	}
+
+	mb,err := gzipGob(m)
+	if err != nil { return fmt.Errorf("MarshalArchive: %v", err) }
+
+	header := append([]byte(kArchiveMagic), make([]byte,8)...)
+	binary.BigEndian.PutUint64(header[len(kArchiveMagic):], uint64(len(mb)))
+
+	for _,b := range [][]byte{header, mb, data.Bytes()} {
+		if _,err := w.Write(b); err != nil { return fmt.Errorf("MarshalArchive: %v", err) }
+	}
+	return nil
+}
+
+// }}}
+// {{{ IsArchive
+
+// IsArchive says whether the data is in the random-access format (rather than the original).
+func IsArchive(r io.ReaderAt) bool {
+	magic := make([]byte, len(kArchiveMagic))
+	if _,err := r.ReadAt(magic, 0); err != nil { return false }
+	return string(magic) == kArchiveMagic
+}
+
+// }}}
+// {{{ ReadArchiveManifest
+
+// ReadArchiveManifest reads just the header and manifest.
+func ReadArchiveManifest(r io.ReaderAt) (*ArchiveManifest, error) {
+	header := make([]byte, len(kArchiveMagic)+8)
+	if _,err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("ReadArchiveManifest header: %v", err)
+	} else if string(header[:len(kArchiveMagic)]) != kArchiveMagic {
+		return nil, fmt.Errorf("ReadArchiveManifest: not an archive")
+	}
+	size := int64(binary.BigEndian.Uint64(header[len(kArchiveMagic):]))
+
+	m := ArchiveManifest{}
+	if err := gunzipGob(io.NewSectionReader(r, int64(len(header)), size), &m); err != nil {
+		return nil, fmt.Errorf("ReadArchiveManifest: %v", err)
+	}
+	m.dataStart = int64(len(header)) + size
+
+	return &m, nil
+}
+
+// }}}
+// {{{ m.ReadBlob, m.ReadAllBlobs
+
+// ReadBlob reads and decodes just the bytes for the i'th entry.
+func (m *ArchiveManifest)ReadBlob(r io.ReaderAt, i int) (*IndexedFlightBlob, error) {
+	if i < 0 || i >= len(m.Entries) {
+		return nil, fmt.Errorf("ReadBlob: entry %d out of range", i)
+	}
+	e := m.Entries[i]
+
+	blob := IndexedFlightBlob{}
+	if err := gunzipGob(io.NewSectionReader(r, m.dataStart+e.Offset, e.Length), &blob); err != nil {
+		return nil, fmt.Errorf("ReadBlob %d: %v", i, err)
+	}
+	return &blob, nil
+}
+
+func (m *ArchiveManifest)ReadAllBlobs(r io.ReaderAt) ([]IndexedFlightBlob, error) {
+	blobs := []IndexedFlightBlob{}
+	for i := range m.Entries {
+		blob,err := m.ReadBlob(r, i)
+		if err != nil { return nil, err }
+		blobs = append(blobs, *blob)
+	}
+	return blobs, nil
+}
+
+// }}}
+
+// {{{ gzipGob, gunzipGob
+
+func gzipGob(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gzw).Encode(v); err != nil {
+		return nil, err
+	} else if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipGob(r io.Reader, v interface{}) error {
+	gzr,err := gzip.NewReader(r)
+	if err != nil { return err }
+	if err := gob.NewDecoder(gzr).Decode(v); err != nil { return err }
+	return gzr.Close()
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
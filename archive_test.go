@@ -0,0 +1,54 @@
+package flightdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	blobs := []IndexedFlightBlob{}
+	keys := []string{}
+	for i,ident := range []string{"UAL1", "UAL2", "UAL3"} {
+		f := BlankFlight()
+		f.IcaoId,f.Callsign = "A12345",ident
+		f.SetTag("FOIA")
+		f.Tracks["ADSB"] = &Track{}
+		*f.Tracks["ADSB"] = loadTrack(t1a)
+		blob,err := f.ToBlob()
+		if err != nil { t.Fatal(err) }
+		blobs = append(blobs, *blob)
+		keys = append(keys, string(rune('a'+i)))
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalArchive(blobs, keys, &buf); err != nil { t.Fatal(err) }
+	r := bytes.NewReader(buf.Bytes())
+
+	if !IsArchive(r) { t.Fatalf("archive not recognized") }
+	m,err := ReadArchiveManifest(r)
+	if err != nil { t.Fatal(err) }
+	if len(m.Entries) != len(blobs) {
+		t.Fatalf("expected %d entries, saw %d", len(blobs), len(m.Entries))
+	}
+
+	// Read them out of order, to check the offsets
+	for _,i := range []int{2,0,1} {
+		e := m.Entries[i]
+		if e.Key != keys[i] || e.Ident != blobs[i].Ident || len(e.Tags) != 1 || len(e.Timeslots) == 0 {
+			t.Errorf("entry %d: bad index fields %+v", i, e)
+		}
+		blob,err := m.ReadBlob(r, i)
+		if err != nil { t.Fatal(err) }
+		if blob.Ident != blobs[i].Ident || !bytes.Equal(blob.Blob, blobs[i].Blob) {
+			t.Errorf("entry %d: blob did not round trip", i)
+		}
+	}
+	if _,err := m.ReadBlob(r, len(blobs)); err == nil {
+		t.Errorf("out of range entry was read")
+	}
+
+	// The original format isn't mistaken for the new one
+	var old bytes.Buffer
+	if err := MarshalBlobSlice(blobs, &old); err != nil { t.Fatal(err) }
+	if IsArchive(bytes.NewReader(old.Bytes())) { t.Errorf("old format recognized as archive") }
+}
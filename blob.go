@@ -16,9 +16,14 @@ type BlobEncoding int64
 const(
 	AsGob BlobEncoding = iota
 	AsGzippedGob
+	AsGzippedColumns // Tracks are stored as columns, see trackcolumns.go
 )
 
-var DefaultBlobEncoding = AsGzippedGob // Try and save some datastore GB-months.
+var DefaultBlobEncoding = AsGzippedColumns // Try and save some datastore GB-months.
+
+// Datastore entities can't be bigger than 1MiB, so bigger blobs get split into chunks of this
+// size, which are stored as separate entities.
+const KMaxBlobChunkSize = 900000
 
 // An indexed flight blob is the thing we persist into datastore (or other blobstores)
 type IndexedFlightBlob struct {
@@ -28,13 +33,62 @@ type IndexedFlightBlob struct {
 	Icao24             string
 	Ident              string  // Right now, this is the ADS-B callsign (SKW2848, or N1J421)
 	LastUpdate         time.Time  // Used to identify most-recent instance of Icao24 for ADS-B
+	StartTime          time.Time  // Earliest trackpoint; for time-ordered paging, see db.LookupPage
 	Timeslots        []time.Time
 	Tags             []string
+	Cells            []string  // Geohashes of the footprint, see geocells.go
+	NumChunks          int     // If non-zero, the Blob is stored in this many FlightBlobChunks
 
 	// DO NOT POPULATE
 	Waypoints        []string //`datastore:",noindex"`
 }
 
+// A FlightBlobChunk holds one piece of an oversize blob.
+type FlightBlobChunk struct {
+	Blob             []byte      `datastore:",noindex"`
+}
+
+// {{{ blob.SplitIntoChunks
+
+// SplitIntoChunks moves the blob's contents into chunks (if it is too big to be stored as a
+// single entity), and records how many there were. It returns nil if no split was needed.
+func (blob *IndexedFlightBlob)SplitIntoChunks() []FlightBlobChunk {
+	if len(blob.Blob) <= KMaxBlobChunkSize { return nil }
+
+	chunks := []FlightBlobChunk{}
+	for b := blob.Blob; len(b) > 0; {
+		n := KMaxBlobChunkSize
+		if n > len(b) { n = len(b) }
+		chunks = append(chunks, FlightBlobChunk{Blob: b[:n]})
+		b = b[n:]
+	}
+
+	blob.Blob = nil
+	blob.NumChunks = len(chunks)
+	return chunks
+}
+
+// }}}
+// {{{ blob.JoinChunks
+
+// JoinChunks is the inverse of SplitIntoChunks.
+func (blob *IndexedFlightBlob)JoinChunks(chunks []FlightBlobChunk) error {
+	if len(chunks) != blob.NumChunks {
+		return fmt.Errorf("JoinChunks: expected %d chunks, got %d", blob.NumChunks, len(chunks))
+	}
+
+	buf := []byte{}
+	for _,chunk := range chunks {
+		buf = append(buf, chunk.Blob...)
+	}
+
+	blob.Blob = buf
+	blob.NumChunks = 0
+	return nil
+}
+
+// }}}
+
 // Real tags, and things we want to search on
 func (f *Flight)IndexTagList() []string {
 	tags := f.TagList()
@@ -45,9 +99,19 @@ func (f *Flight)IndexTagList() []string {
 	return tags
 }
 
+// columnarFlight is what gets gobbed for AsGzippedColumns; the flight without its tracks, and
+// the tracks in columnar form.
+type columnarFlight struct {
+	Flight    Flight
+	Tracks    map[string][]byte
+}
+
 func (f *Flight)ToBlob() (*IndexedFlightBlob, error) {
-	encoding := DefaultBlobEncoding
+	return f.ToBlobWithEncoding(DefaultBlobEncoding)
+}
 
+func (f *Flight)ToBlobWithEncoding(encoding BlobEncoding) (*IndexedFlightBlob, error) {
+	var payload interface{} = f
 	var buf bytes.Buffer
 	var writer io.Writer
 	var closeFunc func() error = func()error{return nil}
@@ -55,7 +119,7 @@ func (f *Flight)ToBlob() (*IndexedFlightBlob, error) {
 	switch encoding {
 	case AsGob:
 		writer = &buf
-	case AsGzippedGob:
+	case AsGzippedGob, AsGzippedColumns:
 		gzipWriter := gzip.NewWriter(&buf)
 		closeFunc = gzipWriter.Close
 		writer = gzipWriter
@@ -63,7 +127,16 @@ func (f *Flight)ToBlob() (*IndexedFlightBlob, error) {
 		return nil, fmt.Errorf("Unrecognized blobencoding '%v'", encoding)
 	}
 
-	if err := gob.NewEncoder(writer).Encode(f); err != nil {
+	if encoding == AsGzippedColumns {
+		cf := columnarFlight{Flight: *f, Tracks: map[string][]byte{}}
+		cf.Flight.Tracks = nil
+		for k,t := range f.Tracks {
+			cf.Tracks[k] = t.ToColumns()
+		}
+		payload = cf
+	}
+
+	if err := gob.NewEncoder(writer).Encode(payload); err != nil {
 		return nil,err
 	}
 	if err := closeFunc(); err != nil {
@@ -77,12 +150,18 @@ func (f *Flight)ToBlob() (*IndexedFlightBlob, error) {
 		Ident: f.Callsign,
 		Timeslots: f.Timeslots(),
 		Tags: f.IndexTagList(),
+		Cells: f.IndexCellList(),
+		StartTime: f.StartTime(),
 		// Waypoints: f.WaypointList(),
 		LastUpdate: time.Now(),
 	}, nil
 }
 
 func (blob *IndexedFlightBlob)ToFlight(key string) (*Flight, error) {
+	if blob.NumChunks > 0 {
+		return nil, fmt.Errorf("ToFlight: blob is split into %d chunks, not joined", blob.NumChunks)
+	}
+
 	buf := bytes.NewBuffer(blob.Blob)
 	f := BlankFlight()
 
@@ -92,7 +171,7 @@ func (blob *IndexedFlightBlob)ToFlight(key string) (*Flight, error) {
 	encoding := blob.BlobEncoding
 	switch encoding {
 	case AsGob: reader = buf
-	case AsGzippedGob:
+	case AsGzippedGob, AsGzippedColumns:
 		if gzipReader,err := gzip.NewReader(buf); err != nil {
 			return &f, err
 		} else {
@@ -103,7 +182,20 @@ func (blob *IndexedFlightBlob)ToFlight(key string) (*Flight, error) {
 		return nil, fmt.Errorf("Unrecognized blobencoding '%v'", encoding)
 	}
 
-	if err := gob.NewDecoder(reader).Decode(&f); err != nil {
+	if encoding == AsGzippedColumns {
+		cf := columnarFlight{Flight: f}
+		if err := gob.NewDecoder(reader).Decode(&cf); err != nil {
+			return nil, err
+		}
+		f = cf.Flight
+		f.Tracks = map[string]*Track{}
+		for k,b := range cf.Tracks {
+			t,err := TrackFromColumns(b)
+			if err != nil { return nil, err }
+			f.Tracks[k] = &t
+		}
+
+	} else if err := gob.NewDecoder(reader).Decode(&f); err != nil {
 		return nil, err
 	}
 
@@ -1,6 +1,6 @@
 package main
 
-// You'll need something useful in $ENV{GOOGLE_APPLICATION_CREDENTIALS}
+// You'll need something useful in $ENV{GOOGLE_APPLICATION_CREDENTIALS}, unless using -localdb
 
 import(
 	"flag"
@@ -30,6 +30,10 @@ var(
 	fIcaoId string
 	fCallsign string
 	fArchiveFrom, fArchiveTo string
+	fRestore string
+	fTags string
+	fDryRun bool
+	fLocalDB string
 	archiveFoldername = "archived-flights"
 )
 
@@ -62,14 +66,30 @@ func init() {
 
 	flag.StringVar(&fArchiveFrom, "archivefrom", "", "2015.01.01")
 	flag.StringVar(&fArchiveTo, "archiveto", "", "2015.01.02")
+	flag.StringVar(&fRestore, "restore", "", "archive to restore; gs://bucket/file, or a local file")
+	flag.StringVar(&fTags, "tags", "", "restore only flights with all these tags (comma-separated)")
+	flag.BoolVar(&fDryRun, "dryrun", false, "restore: just report what would be restored")
+	flag.StringVar(&fLocalDB, "localdb", "", "use a local datastore in this dir, not the cloud")
 
-	for _,e := range []string{"GOOGLE_APPLICATION_CREDENTIALS"} {
-		if os.Getenv(e) == "" {
-			log.Fatal("You're gonna need $"+e)
+	flag.Parse()
+
+	if fLocalDB == "" {
+		for _,e := range []string{"GOOGLE_APPLICATION_CREDENTIALS"} {
+			if os.Getenv(e) == "" {
+				log.Fatal("You're gonna need $"+e)
+			}
 		}
 	}
+}
 
-	flag.Parse()
+// }}}
+// {{{ newProvider
+
+func newProvider() (ds.DatastoreProvider, error) {
+	if fLocalDB != "" {
+		return fgae.NewLocalDSProvider(fLocalDB)
+	}
+	return ds.NewCloudDSProvider(ctx,"serfr0-fdb")
 }
 
 // }}}
@@ -102,7 +122,7 @@ func queryFromArgs() *fgae.FQuery {
 func runQuery(fq *fgae.FQuery) {
 	fmt.Printf("Running query %s\n", fq)
 
-	p,err := ds.NewCloudDSProvider(ctx,"serfr0-fdb")
+	p,err := newProvider()
 	if err != nil { log.Fatal(err) }
 
 	db := fgae.New(ctx,p)
@@ -169,7 +189,7 @@ func runArchiver(sStr, eStr string) {
 	s = s.Add(-1 * time.Second)
 	e = e.Add(1 * time.Second)
 
-	p,err := ds.NewCloudDSProvider(ctx,"serfr0-fdb")
+	p,err := newProvider()
 	if err != nil { log.Fatal(err) }
 	db := fgae.New(ctx,p)
 
@@ -212,7 +232,7 @@ func archiveOneDay(db fgae.FlightDB, midnight, s, e time.Time) error {
 
 	overwrite := false
 	delete := true
-	filename := midnight.Format("2006-01-02-flights")
+	filename := fgae.ArchiveFilename(midnight)
 
 	blobs := []fdb.IndexedFlightBlob{}
 	keyers := []ds.Keyer{}
@@ -267,7 +287,9 @@ func archiveOneDay(db fgae.FlightDB, midnight, s, e time.Time) error {
 
 		log.Printf("%s: writing %d blobs to %s", midnight, len(blobs), filename)
 
-		if err := fdb.MarshalBlobSlice(blobs, gcsHandle.IOWriter()); err != nil {
+		keys := []string{}
+		for _,keyer := range keyers { keys = append(keys, keyer.Encode()) }
+		if err := fdb.MarshalArchive(blobs, keys, gcsHandle.IOWriter()); err != nil {
 			log.Fatal(err)
 		}
 
@@ -279,7 +301,7 @@ func archiveOneDay(db fgae.FlightDB, midnight, s, e time.Time) error {
 	}
 
 	log.Printf("%s: verifying GCS archive file ...", filename)
-	if err := verifyArchiveFlights(archiveFoldername, filename, blobs); err != nil {
+	if err := verifyArchiveFlights(archiveFoldername, filename, blobs, keyers); err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("%s: ... GCS archive file successfully verified !", filename)
@@ -298,47 +320,46 @@ func archiveOneDay(db fgae.FlightDB, midnight, s, e time.Time) error {
 // }}}
 // {{{ verifyArchivedFlights
 
-func verifyArchiveFlights(bucketname, filename string, origBlobs []fdb.IndexedFlightBlob) error {
-	
-	if exists,_ := gcs.Exists(ctx, bucketname, filename); !exists {
-		return fmt.Errorf("can not find existing file %s/%s", bucketname, filename)
-	}
-
-	filehandle, err := gcs.OpenR(ctx, bucketname, filename)
+func verifyArchiveFlights(bucketname, filename string, origBlobs []fdb.IndexedFlightBlob, origKeyers []ds.Keyer) error {
+	name := fmt.Sprintf("gs://%s/%s", bucketname, filename)
+	archivedBlobs, archivedKeys, err := readArchive(name)
 	if err != nil {
 		return err
 	}
-	defer filehandle.Close()
 
-	rdr, err := filehandle.ToReader(ctx, bucketname, filename)
-	if err != nil {
-		return err
+	for i:=0; i<len(origKeyers) && i<len(archivedKeys); i++ {
+		if archivedKeys[i] != origKeyers[i].Encode() {
+			return fmt.Errorf("%s: archived blob %d had key %q, not %q", name, i, archivedKeys[i],
+				origKeyers[i].Encode())
+		}
 	}
 
-	archivedBlobs, err := fdb.UnmarshalBlobSlice(rdr)
-	if err != nil {
-		return err
-	}
+	return compareBlobs(name, origBlobs, archivedBlobs)
+}
+
+// }}}
+// {{{ compareBlobs
 
-	if len(archivedBlobs) != len(origBlobs) {
-		return fmt.Errorf("%s/%s: count mismatch - orig=%d, archive=%d\n", bucketname, filename,
-			len(origBlobs), len(archivedBlobs))
+// compareBlobs checks that the copies have the same index fields and decode to the same flights
+// as the originals, ignoring the LastUpdate timestamps.
+func compareBlobs(what string, origBlobs, copyBlobs []fdb.IndexedFlightBlob) error {
+	if len(copyBlobs) != len(origBlobs) {
+		return fmt.Errorf("%s: count mismatch - orig=%d, copy=%d\n", what, len(origBlobs), len(copyBlobs))
 	}
 
 	for i:=0; i<len(origBlobs); i++ {
 		// This timestamp is tied to object creation, so nuke it to help with DeepEqual
-		archivedBlobs[i].LastUpdate = origBlobs[i].LastUpdate
+		copyBlobs[i].LastUpdate = origBlobs[i].LastUpdate
 
 		// Can't simply DeepEqual the serialized blobs; the encoded binary strings will differ
-		f1,_ := archivedBlobs[i].ToFlight("fakekey")
+		f1,_ := copyBlobs[i].ToFlight("fakekey")
 		f2,_ := origBlobs[i].ToFlight("fakekey")
 
 		// Are all the blob fields (aside from the encoded flight) equal ?
-		b1, b2 := origBlobs[i], archivedBlobs[i]
-		b1.Blob, b2.Blob = []byte{}, []byte{}
+		b1, b2 := normalizeBlob(origBlobs[i]), normalizeBlob(copyBlobs[i])
 		if ! reflect.DeepEqual(b1, b2) {
-			return fmt.Errorf("%s: archived blob %d had different index fields\n\n%#v\n\n%#v\n\n",
-				filename, i, b1, b2)
+			return fmt.Errorf("%s: copied blob %d had different index fields\n\n%#v\n\n%#v\n\n",
+				what, i, b1, b2)
 		}
 
 		// Nopw check the decoded flight objects
@@ -349,13 +370,30 @@ func verifyArchiveFlights(bucketname, filename string, origBlobs []fdb.IndexedFl
 			}
 
 			return fmt.Errorf("%s: decoded FlightBlob %d did not match:-\n\n%#v\n\n\n%#v\n\n",
-				filename, i, f1, f2)
+				what, i, f1, f2)
 		}
 	}
 
 	return nil
 }
 
+// normalizeBlob strips out the encoded flight, and smooths over the differences that a trip
+// through datastore can introduce (timezones, nil vs. empty slices).
+func normalizeBlob(b fdb.IndexedFlightBlob) fdb.IndexedFlightBlob {
+	b.Blob = []byte{}
+	b.LastUpdate = b.LastUpdate.UTC()
+	b.StartTime = b.StartTime.UTC()
+	slots := []time.Time{}
+	for _,t := range b.Timeslots {
+		slots = append(slots, t.UTC())
+	}
+	b.Timeslots = slots
+	if len(b.Tags) == 0      { b.Tags = nil }
+	if len(b.Cells) == 0     { b.Cells = nil }
+	if len(b.Waypoints) == 0 { b.Waypoints = nil }
+	return b
+}
+
 // }}}
 // {{{ multiPassDeleteAllKeys
 
@@ -385,6 +423,11 @@ func main() {
 		return
 	}
 
+	if fRestore != "" {
+		runRestore(fRestore)
+		return
+	}
+
 	if len(flag.Args()) == 0 {
 		runQuery(queryFromArgs())
 		return
@@ -0,0 +1,186 @@
+package main
+
+// Restoring flights from the archives written by runArchiver.
+//
+//  fdb -restore=gs://archived-flights/2017-04-01-flights.fdbarc -icao=A12345 -dryrun
+//  fdb -restore=./2017-04-01-flights -tags=FOIA -localdb=/tmp/fdb   (older archives work too)
+
+import(
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/skypies/util/gcp/ds"
+	"github.com/skypies/util/gcp/gcs"
+
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/flightdb/fgae"
+)
+
+// {{{ readArchive
+
+// readArchive loads up all the blobs in an archive file; the name is either gs://bucket/file, or
+// a path on the local filesystem. Archives in the random-access format also have the original
+// datastore keys for the blobs; for the older format, keys will be nil.
+func readArchive(name string) ([]fdb.IndexedFlightBlob, []string, error) {
+	var rdr io.Reader
+
+	if strings.HasPrefix(name, "gs://") {
+		bits := strings.SplitN(strings.TrimPrefix(name, "gs://"), "/", 2)
+		if len(bits) != 2 { return nil, nil, fmt.Errorf("bad GCS name %q, want gs://bucket/file", name) }
+		bucketname,filename := bits[0],bits[1]
+
+		if exists,_ := gcs.Exists(ctx, bucketname, filename); !exists {
+			return nil, nil, fmt.Errorf("can not find existing file %s/%s", bucketname, filename)
+		}
+		filehandle, err := gcs.OpenR(ctx, bucketname, filename)
+		if err != nil { return nil, nil, err }
+		defer filehandle.Close()
+
+		if rdr,err = filehandle.ToReader(ctx, bucketname, filename); err != nil {
+			return nil, nil, err
+		}
+
+	} else {
+		file,err := os.Open(name)
+		if err != nil { return nil, nil, err }
+		defer file.Close()
+		rdr = file
+	}
+
+	// We're reading the whole thing anyway, so slurp it into memory
+	b,err := io.ReadAll(rdr)
+	if err != nil { return nil, nil, err }
+	data := bytes.NewReader(b)
+
+	if !fdb.IsArchive(data) {
+		blobs,err := fdb.UnmarshalBlobSlice(data)
+		return blobs, nil, err
+	}
+
+	m,err := fdb.ReadArchiveManifest(data)
+	if err != nil { return nil, nil, err }
+	blobs,err := m.ReadAllBlobs(data)
+	if err != nil { return nil, nil, err }
+	keys := []string{}
+	for _,e := range m.Entries {
+		keys = append(keys, e.Key)
+	}
+
+	return blobs, keys, nil
+}
+
+// }}}
+// {{{ blobMatchesArgs
+
+// Based on the various command line flags; we only look at the blob's index fields, so we don't
+// need to decode anything we're not going to restore.
+func blobMatchesArgs(blob fdb.IndexedFlightBlob) bool {
+	if fIcaoId != ""   && blob.Icao24 != fIcaoId  { return false }
+	if fCallsign != "" && blob.Ident != fCallsign { return false }
+
+	if fTags != "" {
+		have := map[string]bool{}
+		for _,tag := range blob.Tags { have[tag] = true }
+		for _,tag := range strings.Split(fTags, ",") {
+			if !have[tag] { return false }
+		}
+	}
+
+	return true
+}
+
+// }}}
+
+// {{{ runRestore
+
+func runRestore(name string) {
+	p,err := newProvider()
+	if err != nil { log.Fatal(err) }
+	db := fgae.New(ctx,p)
+
+	blobs,keys,err := readArchive(name)
+	if err != nil { log.Fatal(err) }
+	log.Printf("%s: read %d blobs from archive", name, len(blobs))
+
+	restored := []fdb.IndexedFlightBlob{}
+	keyers := []ds.Keyer{}
+	nExisting := 0
+	for i,blob := range blobs {
+		if !blobMatchesArgs(blob) { continue }
+
+		// Older archives didn't record keys, so we have to work out what they would have been
+		var keyer ds.Keyer
+		if keys != nil && keys[i] != "" {
+			keyer,err = db.Backend.DecodeKey(keys[i])
+		} else {
+			keyer,err = db.KeyForBlob(&blob)
+		}
+		if err != nil {
+			log.Printf("%s: skipping blob (%s/%s): %v", name, blob.Icao24, blob.Ident, err)
+			continue
+		}
+
+		// Flag up anything we'll be overwriting
+		if _,err := db.LookupBlob(keyer); err == nil {
+			nExisting++
+			log.Printf("%s: key %s already exists in DB, will overwrite", name, keyer.Encode())
+		} else if err != ds.ErrNoSuchEntity {
+			log.Fatal(err)
+		}
+
+		if fDryRun || fVerbosity > 0 {
+			log.Printf("%s: restore %s/%s %v -> %s", name, blob.Icao24, blob.Ident, blob.Tags, keyer.Encode())
+		}
+
+		if !fDryRun {
+			if err := db.RestoreBlob(keyer, blob); err != nil { log.Fatal(err) }
+		}
+
+		restored = append(restored, blob)
+		keyers = append(keyers, keyer)
+	}
+
+	if fDryRun {
+		log.Printf("%s: dry run; would have restored %d flights (%d overwriting existing ones)",
+			name, len(restored), nExisting)
+		return
+	}
+	log.Printf("%s: restored %d flights (%d overwrote existing ones)", name, len(restored), nExisting)
+
+	log.Printf("%s: verifying restored flights ...", name)
+	if err := verifyRestoredFlights(db, keyers, restored); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s: ... restored flights successfully verified !", name)
+}
+
+// }}}
+// {{{ verifyRestoredFlights
+
+// The restore-side counterpart to verifyArchiveFlights; read back what we just wrote.
+func verifyRestoredFlights(db fgae.FlightDB, keyers []ds.Keyer, origBlobs []fdb.IndexedFlightBlob) error {
+	dbBlobs := []fdb.IndexedFlightBlob{}
+	for _,keyer := range keyers {
+		blob,err := db.LookupBlob(keyer)
+		if err != nil {
+			return fmt.Errorf("%s: %v", keyer.Encode(), err)
+		}
+		dbBlobs = append(dbBlobs, *blob)
+	}
+
+	return compareBlobs("restored", origBlobs, dbBlobs)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,124 @@
+package flightdb
+
+// Spotting flights that are really the same flight stored twice (e.g. from repeated FOIA loads,
+// or from two AddTrackFragment calls racing each other), and gluing them back together.
+
+import(
+	"fmt"
+	"time"
+)
+
+// {{{ f.IsDuplicateOf
+
+// IsDuplicateOf says whether the two flights look like the same flight: the same airframe (or,
+// if we don't know both airframes, the same callsign), with tracks that overlap in both time
+// and space.
+func (f1 *Flight)IsDuplicateOf(f2 *Flight) (bool, string) {
+	if f1.IcaoId != "" && f2.IcaoId != "" {
+		if f1.IcaoId != f2.IcaoId { return false, "different IcaoIds" }
+	} else if f1.Callsign == "" || f1.Callsign != f2.Callsign {
+		return false, "IcaoId unknown, and different callsigns"
+	}
+
+	for n1,t1 := range f1.Tracks {
+		for n2,t2 := range f2.Tracks {
+			if t1 == nil || t2 == nil || len(*t1) == 0 || len(*t2) == 0 { continue }
+
+			// Compare is cheap, and weeds out the tracks that can't possibly overlap
+			o := t1.Compare(t2)
+			if o.TimeDisposition.IsDisjoint() || o.SpaceDisposition.IsDisjoint() { continue }
+
+			if overlaps,conf,_ := t1.OverlapsWith(*t2); overlaps {
+				return true, fmt.Sprintf("%s/%s overlap (conf=%.2f)", n1, n2, conf)
+			}
+
+			// Tracks that never leave a few NM (e.g. a handful of points) don't yield any boxes, so
+			// OverlapsWith can't say; go with the overlapping bounding boxes.
+			if len(t1.AsContiguousBoxes()) == 0 || len(t2.AsContiguousBoxes()) == 0 {
+				return true, fmt.Sprintf("%s/%s overlap (tracks too small to box)", n1, n2)
+			}
+		}
+	}
+
+	return false, "no overlapping tracks"
+}
+
+// }}}
+// {{{ f.MergeFrom
+
+// MergeFrom glues f2 into f1. Tracks are merged (skipping any trackpoints f1 already has at the
+// same time), as are tags and waypoints; f1 also picks up any identity fields it was missing.
+func (f1 *Flight)MergeFrom(f2 *Flight) {
+	f1.MergeIdentityFrom(*f2)
+
+	for name,t2 := range f2.Tracks {
+		if t2 == nil { continue }
+		if f1.Tracks[name] == nil {
+			f1.Tracks[name] = &Track{}
+		}
+
+		have := map[time.Time]bool{}
+		for _,tp := range *f1.Tracks[name] { have[tp.TimestampUTC.UTC()] = true }
+
+		extra := Track{}
+		for _,tp := range *t2 {
+			if !have[tp.TimestampUTC.UTC()] { extra = append(extra, tp) }
+		}
+		f1.Tracks[name].Merge(&extra)
+	}
+
+	for tag,_ := range f2.Tags {
+		if !f1.HasTag(tag) { f1.SetTag(tag) }
+	}
+	for wp,t := range f2.Waypoints {
+		if !f1.HasWaypoint(wp) { f1.SetWaypoint(wp, t) }
+	}
+
+	f1.DebugLog += fmt.Sprintf("-- MergeFrom %s: %s\n", time.Now(), f2.IdentityString())
+}
+
+// }}}
+// {{{ GroupDuplicates
+
+// GroupDuplicates returns sets of flights that are duplicates of each other (flights with no
+// duplicates are left out). Duplication is transitive, so a flight that overlaps two others
+// will pull all three into one group.
+func GroupDuplicates(flights []*Flight) [][]*Flight {
+	group := make([]int, len(flights)) // index of the group's first flight
+	for i := range flights { group[i] = i }
+	find := func(i int) int {
+		for group[i] != i { i = group[i] }
+		return i
+	}
+
+	for i:=0; i<len(flights); i++ {
+		for j:=i+1; j<len(flights); j++ {
+			if find(i) == find(j) { continue }
+			if dupe,_ := flights[i].IsDuplicateOf(flights[j]); dupe {
+				gi,gj := find(i),find(j)
+				if gi < gj { group[gj] = gi } else { group[gi] = gj }
+			}
+		}
+	}
+
+	byGroup := map[int][]*Flight{}
+	for i,f := range flights {
+		byGroup[find(i)] = append(byGroup[find(i)], f)
+	}
+
+	ret := [][]*Flight{}
+	for i := range flights {
+		if g := byGroup[i]; len(g) > 1 { ret = append(ret, g) }
+	}
+	return ret
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
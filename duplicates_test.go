@@ -0,0 +1,52 @@
+package flightdb
+
+import (
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	newFlight := func(icao, callsign string, tracks ...[]byte) *Flight {
+		f := BlankFlight()
+		f.IcaoId,f.Callsign = icao,callsign
+		f.Tracks["ADSB"] = &Track{}
+		for _,b := range tracks {
+			*f.Tracks["ADSB"] = append(*f.Tracks["ADSB"], loadTrack(b)...)
+		}
+		return &f
+	}
+
+	whole := newFlight("A12345", "UAL1", t1a, t1b)
+	part  := newFlight("A12345", "",     t1b)
+	part.SetTag("FOIA")
+	other := newFlight("A99999", "UAL1", t1b)    // Same track, different airframe
+	first := newFlight("",       "UAL1", t1a)    // Callsign match, but no time overlap with part
+	
+	if dupe,str := whole.IsDuplicateOf(part); !dupe {
+		t.Errorf("whole/part not duplicates: %s", str)
+	}
+	if dupe,_ := whole.IsDuplicateOf(other); dupe {
+		t.Errorf("whole/other duplicates, despite different IcaoIds")
+	}
+	if dupe,_ := part.IsDuplicateOf(first); dupe {
+		t.Errorf("part/first duplicates, despite different callsigns")
+	}
+
+	groups := GroupDuplicates([]*Flight{other, whole, first, part})
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("expected one group of three, saw %v", groups)
+	}
+
+	n := len(*whole.Tracks["ADSB"])
+	whole.MergeFrom(part)
+	if len(*whole.Tracks["ADSB"]) != n {
+		t.Errorf("merge added duplicate trackpoints: %d -> %d", n, len(*whole.Tracks["ADSB"]))
+	}
+	if !whole.HasTag("FOIA") {
+		t.Errorf("merge did not copy tags")
+	}
+
+	part.MergeFrom(first)
+	if len(*part.Tracks["ADSB"]) != n || part.Callsign != "UAL1" {
+		t.Errorf("merge did not fill in track and callsign: %d points, %q", len(*part.Tracks["ADSB"]), part.Callsign)
+	}
+}
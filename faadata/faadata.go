@@ -20,7 +20,7 @@ func makeFlight(rows []Row, dataSource string, tStart time.Time, genesisStr stri
 	t := fdb.Track{}
 	for _,row := range rows {
 		tp := row.ToTrackpoint()
-		tp.DataSource = dataSource
+		tp.DataSource = dataSource // Names the FOIA dataset
 		t = append(t, tp)
 	}
 
@@ -119,6 +119,8 @@ func (r Row)ToTrackpoint() fdb.Trackpoint {
 	
 	tp := fdb.Trackpoint{
 		DataSource:    "DATASOURCE-UNDEFINED", // Should be overwritten by caller
+		DataSystem:    fdb.DSCorrectedRadar,
+		DataProvider:  fdb.DPFAAFOIA,
 		TimestampUTC:  t,
 		Latlong:       geo.Latlong{Lat:lat, Long:long},
 		Altitude:      alt * 100.0,
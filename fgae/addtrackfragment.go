@@ -4,7 +4,9 @@ import(
 	"fmt"
 	"time"
 
+	"github.com/skypies/adsb"
 	"github.com/skypies/geo/sfo"
+	"github.com/skypies/util/gcp/ds"
 
 	fdb "github.com/skypies/flightdb"
 	"github.com/skypies/flightdb/ref"
@@ -30,14 +32,136 @@ func currentAccumulationTrack(f *fdb.Flight) *fdb.Track {
 	}
 }
 
+// }}}
+// {{{ db.checkUnchanged
+
+// checkUnchanged is an optimistic-concurrency check, for a flight we're about to overwrite: if
+// the stored copy (old, which is nil if there isn't one) has been rewritten or deleted since we
+// loaded f from it, someone else got in first.
+func (db *FlightDB)checkUnchanged(f *fdb.Flight, old *fdb.IndexedFlightBlob) error {
+	if f.GetDatastoreKey() == "" { return nil } // A new flight
+
+	if old == nil {
+		db.Debugf("* checkUnchanged: %s was deleted (joined into another flight?)", f.IdentityString())
+		return ErrConcurrentTransaction
+	} else if !old.LastUpdate.Equal(f.LastUpdate()) {
+		db.Debugf("* checkUnchanged: %s was updated at %s, we loaded it at %s", f.IdentityString(),
+			old.LastUpdate, f.LastUpdate())
+		return ErrConcurrentTransaction
+	}
+
+	return nil
+}
+
+// }}}
+// {{{ db.joinBridgedFlights
+
+// joinBridgedFlights looks for other flights by the same airframe that f now runs into. If a
+// fragment arrives out of order (ahead of the ones before it), it starts a new flight; when the
+// missing fragments eventually show up, they get prepended to that new flight, which then butts
+// right up against the flight that should have been extended in the first place. Any such
+// flights (whose tracks overlap f's, or leave a gap small enough that either would have accepted
+// the other as an extension) are merged together, and all but one deleted. The flight to persist
+// is returned; if f hasn't been stored yet, it is merged into the stored flight instead, as
+// the new flight's key (made from its first timestamp) could otherwise land on a deleted one's.
+func (db *FlightDB)joinBridgedFlights(f *fdb.Flight, prefix string) (*fdb.Flight, error) {
+	accF := currentAccumulationTrack(f)
+	if accF == nil || len(*accF) == 0 { return f, nil }
+
+	s,e := f.Times()
+	q := db.NewQuery().
+		ByIcaoId(adsb.IcaoId(f.IcaoId)).
+		ByTimeRange(s.Add(-fdb.TimeslotDuration), e.Add(fdb.TimeslotDuration)).
+		Ancestor(db.Backend.NewNameKey(db.Ctx(), kFlightKind, string(f.IcaoId), nil))
+
+	keyers,err := db.LookupAllKeys(q)
+	if err != nil { return f, err }
+
+	for _,keyer := range keyers {
+		if keyer.Encode() == f.GetDatastoreKey() { continue }
+
+		// The key came from a query, outside the transaction's snapshot; someone may have just
+		// deleted it (by joining it into another flight)
+		blob,err := db.LookupBlob(keyer)
+		if err == ds.ErrNoSuchEntity {
+			return f, ErrConcurrentTransaction
+		} else if err != nil {
+			return f, err
+		}
+		other,err := blob.ToFlight(keyer.Encode())
+		if err != nil { return f, err }
+
+		accOther := currentAccumulationTrack(other)
+		if accOther == nil || len(*accOther) == 0 { continue }
+
+		// Insist both ways round; a prefix can have a much bigger gap than a suffix
+		if ok,_ := accOther.PlausibleContribution(accF); !ok {
+			continue
+		} else if ok,debug := accF.PlausibleContribution(accOther); !ok {
+			continue
+		} else {
+			db.Debugf("* %s joining %s ... debug:\n%s", prefix, other, debug)
+		}
+
+		if f.GetDatastoreKey() == "" {
+			other.DebugLog += f.DebugLog
+			other.DebugLog += "-- AddFrag "+prefix+": joined by new flight\n"
+			other.MergeFrom(f)
+			f = other
+
+		} else {
+			f.DebugLog += "-- AddFrag "+prefix+": joining "+keyer.Encode()+"\n"
+			f.MergeFrom(other)
+			if err := db.DeleteByKey(keyer); err != nil { return f, err }
+		}
+
+		accF = currentAccumulationTrack(f)
+	}
+
+	return f, nil
+}
+
 // }}}
 // {{{ AddTrackFragment
 
+// AddTrackFragment does its read-modify-write inside a transaction on the IcaoId's entity group
+// (see findOrGenerateFlightKey), retrying if another fragment for the same airframe was added at
+// the same time. The airframe and schedule caches (either may be nil) fill in any metadata the
+// flight is missing; if the schedule cache can't vouch for the fragment's time, the schedule
+// history is used instead (see schedulehistory.go). Fragments that arrive out of order can
+// start a new flight, which is joined back up once the gap is filled (see joinBridgedFlights).
 func (db *FlightDB)AddTrackFragment(frag *fdb.TrackFragment, airframes *ref.AirframeCache, schedules *ref.ScheduleCache, perf map[string]time.Time) error {
 	perf["01_start"] = time.Now()
 	db.Debugf("* adding frag %d\n", len(frag.Track))
 
-	f,err := db.LookupMostRecent(db.NewQuery().ByIcaoId(frag.IcaoId))
+	// Work out the schedule outside of the transaction, which can only run ancestor queries
+	var sched *ScheduleInterval
+	if len(frag.Track) > 0 {
+		var err error
+		if sched,err = db.lookupScheduleWithCache(string(frag.IcaoId), frag.Track[0].TimestampUTC, schedules); err != nil {
+			db.Warningf("AddTrackFragment: schedule lookup: %v", err) // Not worth failing over
+		}
+	}
+
+	if frag.IcaoId == "" {
+		return db.addTrackFragment(frag, airframes, sched, perf) // No entity group to lock
+	}
+
+	return db.RunInTransaction(func(txdb FlightDB) error {
+		// We might get rerun, and addTrackFragment edits the frag, so give it a fresh copy each time
+		fragCopy := *frag
+		fragCopy.Track = append(fdb.Track{}, frag.Track...)
+		return txdb.addTrackFragment(&fragCopy, airframes, sched, perf)
+	})
+}
+
+func (db *FlightDB)addTrackFragment(frag *fdb.TrackFragment, airframes *ref.AirframeCache, sched *ScheduleInterval, perf map[string]time.Time) error {
+	q := db.NewQuery().ByIcaoId(frag.IcaoId)
+	if frag.IcaoId != "" {
+		// Transactions need an ancestor query
+		q.Ancestor(db.Backend.NewNameKey(db.Ctx(), kFlightKind, string(frag.IcaoId), nil))
+	}
+	f,err := db.LookupMostRecent(q)
 	if err != nil { return err }
 	perf["02_mostrecent"] = time.Now()
 
@@ -118,6 +242,11 @@ func (db *FlightDB)AddTrackFragment(frag *fdb.TrackFragment, airframes *ref.Airf
 		}
 	}
 
+	// Likewise the schedule that the airframe was flying, for anything missing
+	if sched != nil && f.MergeIdentityFrom(sched.AsFlight()) {
+		f.DebugLog += "-- AddFrag "+prefix+": found schedule "+sched.String()+"\n"
+	}
+
 	// There could be a big gap between the previous track and this frag.
 	// If that's the case, grab the preceding trackpoint and prefix this frag with it; then
 	// the waypoint detection code (which builds lines between points) will look at the gap
@@ -136,8 +265,21 @@ func (db *FlightDB)AddTrackFragment(frag *fdb.TrackFragment, airframes *ref.Airf
 	}
 
 	perf["05_waypoints"] = time.Now()
-	err = db.PersistFlight(f)
-	perf["06_persist"] = time.Now()
+
+	// A frag that isn't strictly a suffix might have filled in a gap between two flights
+	if prevTP == nil && f.IcaoId != "" {
+		if f,err = db.joinBridgedFlights(f, prefix); err != nil {
+			return err
+		}
+	}
+
+	// The frag (and any schedule, or joined flight) can change the phases, runways and go-arounds,
+	// and those need the whole track; NewFlightFromTrackFragment only saw the first frag.
+	f.AnalyseTrack()
+	perf["06_analyse"] = time.Now()
+
+	err = db.persistFlight(f, "", true)
+	perf["07_persist"] = time.Now()
 
 	return err
 }
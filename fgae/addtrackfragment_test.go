@@ -1,22 +1,20 @@
 package fgae
 
-// FIXME: how to run these tests without aetest ?
+// These run against an in-memory LocalDSProvider.
 
-// cd flightdb/fgae && goapp test (note it takes about 4 minutes to run)
-
-/*
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"google.golang.org/ appengine/aetest"
+	"context"
 
 	fdb "github.com/skypies/flightdb"
 )
-*/
 
 /* Misordered Frags
 
@@ -72,14 +70,10 @@ should now generate a single flight !
 
  */
 
-/*
-
 func TestMisorderedFrags(t *testing.T) {
-	ctx, done, err := aetest.NewContext()
+	p,err := NewLocalDSProvider("")
 	if err != nil { t.Fatal(err) }
-	defer done()
-
-	db := NewDB(ctx)
+	db := New(context.Background(), p)
 
 	idspec,_ := fdb.NewIdSpec("A5BB1B@1483403847:1483407465")  // Has to match the frags
 	
@@ -94,15 +88,13 @@ func TestMisorderedFrags(t *testing.T) {
 	if err := json.NewDecoder(strings.NewReader(MisorderedFragsJSON)).Decode(&frags); err != nil {
 		t.Fatal(err)
 	}
-	fmt.Printf("(found %d frags)\n", len(frags))
 
 	nPts := 0
 	for _,frag := range frags {
-		time.Sleep(1000 * time.Millisecond) // the test datastore gets confused if we go any quicker
-		if err := db.AddTrackFragment(&frag); err != nil {
+		nPts += len(frag.Track)
+		if err := db.AddTrackFragment(&frag, nil, nil, map[string]time.Time{}); err != nil {
 			t.Fatal(err)
 		}
-		nPts += len(frag.Track)
 	}
 
 	results,err := db.LookupAll(db.NewQuery().ByIdSpec(idspec))
@@ -116,12 +108,108 @@ func TestMisorderedFrags(t *testing.T) {
 	} else {
 		f := results[0]
 		track := f.AnyTrack()
-		if len(track) != nPts+1 {
+		if len(track) != nPts {
 			t.Errorf("Expected the single flight to have %d Trackpoints, found %d\n", nPts, len(track))
 		}
 	}
+}
+
+// The phases (and runways, and go-arounds) need the whole track, so they should end up as if
+// the finished flight had been analysed from scratch, not just its first frag.
+func TestAddTrackFragmentReanalyses(t *testing.T) {
+	p,err := NewLocalDSProvider("")
+	if err != nil { t.Fatal(err) }
+	db := New(context.Background(), p)
+
+	frags := []fdb.TrackFragment{}
+	if err := json.NewDecoder(strings.NewReader(MisorderedFragsJSON)).Decode(&frags); err != nil {
+		t.Fatal(err)
+	}
+	for _,frag := range frags {
+		if err := db.AddTrackFragment(&frag, nil, nil, map[string]time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results,err := db.LookupAll(db.NewQuery().ByIcaoId("A5BB1B"))
+	if err != nil { t.Fatal(err) } else if len(results) != 1 {
+		t.Fatalf("Expected a single flight object, but found %d.", len(results))
+	}
+
+	f := results[0]
+	fresh := *f
+	fresh.Tags = map[string]int{}
+	for tag,_ := range f.Tags { fresh.SetTag(tag) }
+	fresh.AnalyseTrack()
+
+	if len(f.PhaseEvents) < 2 {
+		t.Errorf("Expected the flight to have some phase changes, found %v", f.PhaseEvents)
+	}
+	if !reflect.DeepEqual(f.PhaseEvents, fresh.PhaseEvents) {
+		t.Errorf("Stored phases are stale:-\n got: %v\nwant: %v\n", f.PhaseEvents, fresh.PhaseEvents)
+	}
+	if !reflect.DeepEqual(f.TagList(), fresh.TagList()) {
+		t.Errorf("Stored tags are stale:-\n got: %v\nwant: %v\n", f.TagList(), fresh.TagList())
+	}
+}
+
+// Adding the same frags from lots of goroutines at once should never lose a trackpoint; without
+// the transaction, concurrent read-modify-writes of the same flight would overwrite each other.
+func TestConcurrentAddTrackFragment(t *testing.T) {
+	p,err := NewLocalDSProvider("")
+	if err != nil { t.Fatal(err) }
+	db := New(context.Background(), p)
+
+	frags := []fdb.TrackFragment{}
+	if err := json.NewDecoder(strings.NewReader(MisorderedFragsJSON)).Decode(&frags); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plenty of contention, so give the retries a bit more room
+	defer func(n int) { KMaxTransactionAttempts = n }(KMaxTransactionAttempts)
+	KMaxTransactionAttempts = 20
+
+	nPts := 0
+	for _,frag := range frags {
+		nPts += len(frag.Track)
+	}
+
+	var wg sync.WaitGroup
+	fragsCh := make(chan fdb.TrackFragment)
+	errsCh := make(chan error, len(frags))
+	for i:=0; i<8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for frag := range fragsCh {
+				if err := db.AddTrackFragment(&frag, nil, nil, map[string]time.Time{}); err != nil {
+					errsCh <- err
+				}
+			}
+		}()
+	}
+	for _,frag := range frags {
+		fragsCh <- frag
+	}
+	close(fragsCh)
+	wg.Wait()
+	close(errsCh)
 
-	t.Errorf("SHOW ME THE STDOUT\n")
+	for err := range errsCh {
+		t.Errorf("AddTrackFragment: %v", err)
+	}
+
+	// The frags arrive in a random order, so we can't say how many flights there will be
+	results,err := db.LookupAll(db.NewQuery().ByIcaoId("A5BB1B"))
+	if err != nil { t.Fatal(err) }
+	nFound := 0
+	for _,f := range results {
+		nFound += len(f.AnyTrack())
+	}
+	if nFound != nPts {
+		t.Errorf("Expected %d flights to have %d Trackpoints in total, found %d\n", len(results),
+			nPts, nFound)
+	}
 }
 
 var (
@@ -17232,5 +17320,3 @@ var (
 ]
 `
 )
-
-*/
@@ -0,0 +1,67 @@
+package fgae
+
+// An in-process copy of the airframe cache singleton, so that overlaying airframe data onto
+// every lookup (see FlightDB.OverlayAirframes) doesn't mean reloading the singleton every time.
+// FlightDBs are short-lived (usually one per request), so the copy is shared by all of them.
+
+import(
+	"sync"
+	"time"
+
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/flightdb/ref"
+)
+
+// How long the in-process copy is used before the singleton is reloaded; zero means always reload.
+var KAirframeCacheRefresh = 10 * time.Minute
+
+var(
+	airframesMu        sync.Mutex
+	airframes         *ref.AirframeCache
+	airframesLoaded    time.Time
+)
+
+// {{{ db.cachedAirframes
+
+// The returned cache is shared, so must not be modified.
+func (db *FlightDB)cachedAirframes() (*ref.AirframeCache, error) {
+	airframesMu.Lock()
+	defer airframesMu.Unlock()
+
+	if airframes == nil || time.Since(airframesLoaded) >= KAirframeCacheRefresh {
+		ac,err := ref.LoadAirframeCache(db.Ctx(), db.SingletonProvider)
+		if err != nil { return nil, err }
+		airframes,airframesLoaded = ac,time.Now()
+	}
+
+	return airframes, nil
+}
+
+// }}}
+// {{{ db.airframesForOverlay, overlayAirframes
+
+// airframesForOverlay returns nil if the DB isn't overlaying airframes onto lookups.
+func (db *FlightDB)airframesForOverlay() (*ref.AirframeCache, error) {
+	if !db.OverlayAirframes { return nil, nil }
+	return db.cachedAirframes()
+}
+
+func overlayAirframes(ac *ref.AirframeCache, flights ...*fdb.Flight) {
+	if ac == nil { return }
+	for _,f := range flights {
+		if f == nil { continue }
+		if af := ac.Get(f.IcaoId); af != nil {
+			f.OverlayAirframe(*af)
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
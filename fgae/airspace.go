@@ -30,7 +30,7 @@ func snapshot2AircraftData(fs fdb.FlightSnapshot, id adsb.IcaoId) airspace.Aircr
 	af := fs.Flight.Airframe
 	af.Icao24 = string(id)
 
-	if fs.Trackpoint.DataSource == "MLAT" { msg.Type = "MLAT" }
+	if fs.Trackpoint.GetDataSystem() == fdb.DSMLAT { msg.Type = "MLAT" }
 	
 	return airspace.AircraftData{
  		Msg: &msg,
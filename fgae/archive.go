@@ -0,0 +1,281 @@
+package fgae
+
+// Looking up flights in the random-access archives (see flightdb/archive.go) written by
+// cmd/fdb, for days that have been deleted from datastore. If FlightDB.Archive is set, LookupAll
+// falls back to the archives for queries it can answer from the archive manifests (those
+// with a time range, plus maybe Icao24, Ident and Tags); flights found there have their original
+// datastore keys. Only days older than KDatastoreRetention are looked for in the archives, as
+// datastore still has the newer ones. Days archived before the random-access format came along
+// are still read, under their old filenames; they have to be decoded in full, and their flights'
+// keys worked out afresh (as cmd/fdb -restore does).
+
+import(
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/skypies/util/date"
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+)
+
+var ErrNoArchive = errors.New("no archive for that day")
+
+// Days are archived (and deleted from datastore) once they are older than this.
+var KDatastoreRetention = 365 * 24 * time.Hour
+
+type ArchiveReader interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// An ArchiveStore holds one archive file per day (days start at midnight, Pacific time).
+type ArchiveStore interface {
+	OpenDay(ctx context.Context, midnight time.Time) (ArchiveReader, error) // or ErrNoArchive
+}
+
+func ArchiveFilename(midnight time.Time) string {
+	return date.InPdt(midnight).Format("2006-01-02-flights.fdbarc")
+}
+
+// LegacyArchiveFilename is where the original format (see fdb.UnmarshalBlobSlice) was written.
+func LegacyArchiveFilename(midnight time.Time) string {
+	return date.InPdt(midnight).Format("2006-01-02-flights")
+}
+
+// The stores look for a day's archive under each of these, in order.
+func archiveFilenames(midnight time.Time) []string {
+	return []string{ArchiveFilename(midnight), LegacyArchiveFilename(midnight)}
+}
+
+// {{{ NewArchiveStore
+
+// NewArchiveStore parses a store spec, as found in config: "gs://bucket" for a GCS bucket,
+// "file:/some/dir" for a local directory. An empty spec means no archives (and a nil store).
+func NewArchiveStore(ctx context.Context, spec string) (ArchiveStore, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "gs://"):
+		return NewGCSArchiveStore(ctx, strings.TrimPrefix(spec, "gs://"))
+	case strings.HasPrefix(spec, "file:"):
+		return LocalArchiveStore{Dir: strings.TrimPrefix(spec, "file:")}, nil
+	}
+	return nil, fmt.Errorf("NewArchiveStore: don't know what to do with %q", spec)
+}
+
+// }}}
+
+// {{{ LocalArchiveStore
+
+type LocalArchiveStore struct {
+	Dir string
+}
+
+func (s LocalArchiveStore)OpenDay(ctx context.Context, midnight time.Time) (ArchiveReader, error) {
+	for _,name := range archiveFilenames(midnight) {
+		f,err := os.Open(filepath.Join(s.Dir, name))
+		if os.IsNotExist(err) { continue }
+		return f, err
+	}
+	return nil, ErrNoArchive
+}
+
+// }}}
+// {{{ GCSArchiveStore
+
+// GCSArchiveStore reads archives from a GCS bucket, using range reads so that only the bytes
+// we need get fetched.
+type GCSArchiveStore struct {
+	Bucket  string
+	client *storage.Client
+}
+
+func NewGCSArchiveStore(ctx context.Context, bucket string) (*GCSArchiveStore, error) {
+	client,err := storage.NewClient(ctx)
+	if err != nil { return nil, fmt.Errorf("NewGCSArchiveStore: %v", err) }
+	return &GCSArchiveStore{Bucket:bucket, client:client}, nil
+}
+
+func (s *GCSArchiveStore)OpenDay(ctx context.Context, midnight time.Time) (ArchiveReader, error) {
+	for _,name := range archiveFilenames(midnight) {
+		obj := s.client.Bucket(s.Bucket).Object(name)
+		if _,err := obj.Attrs(ctx); err == storage.ErrObjectNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return gcsReaderAt{ctx:ctx, obj:obj}, nil
+	}
+	return nil, ErrNoArchive
+}
+
+type gcsReaderAt struct {
+	ctx  context.Context
+	obj *storage.ObjectHandle
+}
+
+func (r gcsReaderAt)ReadAt(p []byte, off int64) (int, error) {
+	rdr,err := r.obj.NewRangeReader(r.ctx, off, int64(len(p)))
+	if err != nil { return 0, err }
+	defer rdr.Close()
+	n,err := io.ReadFull(rdr, p)
+	if err == io.ErrUnexpectedEOF { err = io.EOF } // Ran off the end of the object
+	return n, err
+}
+
+func (r gcsReaderAt)Close() error { return nil }
+
+// }}}
+
+// {{{ archiveQuery
+
+// archiveQuery is the subset of datastore queries that can be run against archive manifests.
+type archiveQuery struct {
+	s,e      time.Time
+	icao24   string
+	ident    string
+	tags   []string
+}
+
+// newArchiveQuery returns false if the query has anything we can't evaluate against a manifest,
+// or if it has no time range (which would mean searching every archive).
+func newArchiveQuery(q *ds.Query) (archiveQuery, bool) {
+	aq := archiveQuery{}
+	for _,filter := range q.Filters {
+		var ok bool
+		switch filter.Field {
+		case "Timeslots = ":  aq.s,ok = filter.Value.(time.Time); aq.e = aq.s
+		case "Timeslots >= ": aq.s,ok = filter.Value.(time.Time)
+		case "Timeslots <= ": aq.e,ok = filter.Value.(time.Time)
+		case "Icao24 = ":     aq.icao24,ok = filter.Value.(string)
+		case "Ident = ":      aq.ident,ok = filter.Value.(string)
+		case "Tags = ":
+			var tag string
+			tag,ok = filter.Value.(string)
+			aq.tags = append(aq.tags, tag)
+		}
+		if !ok { return aq, false }
+	}
+
+	if q.AncestorKeyer != nil || q.Kind != kFlightKind || aq.s.IsZero() || aq.e.IsZero() {
+		return aq, false
+	}
+	return aq, true
+}
+
+func (aq archiveQuery)matches(e fdb.ArchiveEntry) bool {
+	if aq.icao24 != "" && e.Icao24 != aq.icao24 { return false }
+	if aq.ident != ""  && e.Ident != aq.ident   { return false }
+
+	have := map[string]bool{}
+	for _,tag := range e.Tags { have[tag] = true }
+	for _,tag := range aq.tags {
+		if !have[tag] { return false }
+	}
+
+	for _,slot := range e.Timeslots {
+		if !slot.Before(aq.s) && !slot.After(aq.e) { return true }
+	}
+	return false
+}
+
+// The archive for a day only has the flights that started that day, so we need to look at the
+// day before the range too.
+func (aq archiveQuery)midnights() []time.Time {
+	m := date.AtLocalMidnight(date.InPdt(aq.s)).AddDate(0,0,-1)
+	ret := []time.Time{}
+	for !m.After(aq.e) {
+		ret = append(ret, m)
+		_,m = date.WindowForTime(m)
+	}
+	return ret
+}
+
+// }}}
+// {{{ db.lookupArchives
+
+// lookupArchives runs the query against the archives, skipping any flights whose keys are in
+// skip. It returns nothing if the query can't be run against archives.
+func (db *FlightDB)lookupArchives(q *ds.Query, skip map[string]bool) ([]*fdb.Flight, error) {
+	aq,ok := newArchiveQuery(q)
+	if !ok { return nil, nil }
+
+	retained := time.Now().Add(-KDatastoreRetention)
+	flights := []*fdb.Flight{}
+	for _,m := range aq.midnights() {
+		if _,dayEnd := date.WindowForTime(m); dayEnd.After(retained) { continue } // Still in datastore
+		these,err := db.lookupArchive(aq, m, skip)
+		if err != nil { return nil, fmt.Errorf("lookupArchives %s: %v", ArchiveFilename(m), err) }
+		flights = append(flights, these...)
+	}
+	return flights, nil
+}
+
+func (db *FlightDB)lookupArchive(aq archiveQuery, midnight time.Time, skip map[string]bool) ([]*fdb.Flight, error) {
+	r,err := db.Archive.OpenDay(db.Ctx(), midnight)
+	if err == ErrNoArchive {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if !fdb.IsArchive(r) {
+		return db.lookupLegacyArchive(aq, r, skip)
+	}
+
+	m,err := fdb.ReadArchiveManifest(r)
+	if err != nil { return nil, err }
+
+	flights := []*fdb.Flight{}
+	for i,e := range m.Entries {
+		if !aq.matches(e) || skip[e.Key] { continue }
+
+		blob,err := m.ReadBlob(r, i)
+		if err != nil { return nil, err }
+		f,err := blob.ToFlight(e.Key)
+		if err != nil { return nil, err }
+		flights = append(flights, f)
+	}
+	return flights, nil
+}
+
+// lookupLegacyArchive handles the original format, which has no manifest (so everything has to
+// be decoded to find anything), and no keys (so they're regenerated from the blobs).
+func (db *FlightDB)lookupLegacyArchive(aq archiveQuery, r io.ReaderAt, skip map[string]bool) ([]*fdb.Flight, error) {
+	blobs,err := fdb.UnmarshalBlobSlice(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil { return nil, err }
+
+	flights := []*fdb.Flight{}
+	for i,blob := range blobs {
+		e := fdb.ArchiveEntry{Icao24:blob.Icao24, Ident:blob.Ident, Timeslots:blob.Timeslots, Tags:blob.Tags}
+		if !aq.matches(e) { continue }
+
+		keyer,err := db.KeyForBlob(&blobs[i])
+		if err != nil { return nil, err }
+		if skip[keyer.Encode()] { continue }
+
+		f,err := blob.ToFlight(keyer.Encode())
+		if err != nil { return nil, err }
+		flights = append(flights, f)
+	}
+	return flights, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
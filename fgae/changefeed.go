@@ -0,0 +1,301 @@
+package fgae
+
+// The change feed: if FlightDB.Changes is set, every flight written by PersistFlight, or removed
+// by DeleteByKey / DeleteAllKeys, is published to it as a ChangeEvent, after the datastore
+// write has happened. Writes made inside RunInTransaction are only published once the
+// transaction commits.
+//
+// Publishing is best effort; failures are logged, but don't fail the write (which has already
+// happened). Consumers that can't afford gaps should reconcile against datastore now and then.
+
+import(
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+)
+
+type ChangeType string
+
+const(
+	ChangeCreated ChangeType = "created"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+)
+
+type ChangeEvent struct {
+	Type          ChangeType
+	Time          time.Time
+	Key           string
+	IdSpec        string     // Empty if the flight has no trackpoints
+	TagsAdded   []string     // Index tags (see IndexTagList); all of them, for created flights
+	TagsRemoved []string     // Index tags; all of them, for deleted flights
+	Reason        string     // As passed to PersistFlightWithReason
+}
+
+func (ev ChangeEvent)String() string {
+	return fmt.Sprintf("%s %s %s [%s] +%v -%v %q", ev.Time.Format(time.RFC3339), ev.Type, ev.Key,
+		ev.IdSpec, ev.TagsAdded, ev.TagsRemoved, ev.Reason)
+}
+
+type ChangeSink interface {
+	Publish(ctx context.Context, events []ChangeEvent) error
+}
+
+// {{{ NewChangeSink
+
+// NewChangeSink builds a sink from a config string: "file:/some/path" for a FileSink, or an
+// http(s) URL for a WebhookSink. An empty string gives a nil sink (i.e. no change feed).
+func NewChangeSink(spec string) (ChangeSink, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "file:"):
+		return &FileSink{Path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &WebhookSink{URL: spec}, nil
+	}
+	return nil, fmt.Errorf("NewChangeSink: don't know what to do with %q", spec)
+}
+
+// }}}
+
+// {{{ InProcessSink
+
+// InProcessSink hands events to subscribers in the same process. Publish never blocks; if a
+// subscriber's channel is full, the event is dropped for that subscriber (and counted).
+type InProcessSink struct {
+	mu            sync.Mutex
+	subscribers []chan ChangeEvent
+	Dropped       int
+}
+
+func (s *InProcessSink)Subscribe(bufferSize int) <-chan ChangeEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan ChangeEvent, bufferSize)
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+func (s *InProcessSink)Publish(ctx context.Context, events []ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _,ev := range events {
+		for _,ch := range s.subscribers {
+			select {
+			case ch <- ev:
+			default:
+				s.Dropped++
+			}
+		}
+	}
+	return nil
+}
+
+// }}}
+// {{{ FileSink
+
+// FileSink appends events to a local file, one JSON object per line.
+type FileSink struct {
+	Path  string
+	mu    sync.Mutex
+}
+
+func (s *FileSink)Publish(ctx context.Context, events []ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f,err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil { return fmt.Errorf("FileSink: %v", err) }
+
+	enc := json.NewEncoder(f)
+	for _,ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			f.Close()
+			return fmt.Errorf("FileSink: %v", err)
+		}
+	}
+	return f.Close()
+}
+
+// }}}
+// {{{ WebhookSink
+
+// How long a webhook POST can take, and how many batches can be waiting to be POSTed.
+var(
+	KWebhookTimeout  = 10 * time.Second
+	KWebhookQueueLen = 100
+)
+
+// WebhookSink POSTs each batch of events to a URL, as a JSON array. Publish doesn't wait for
+// the POST; batches go onto a bounded queue, which a background goroutine works through, so a
+// slow endpoint can't hold up flight writes. If the queue is full, the batch is dropped (and
+// counted, and Publish returns an error).
+type WebhookSink struct {
+	URL      string
+	Client  *http.Client // If nil, one with a timeout of KWebhookTimeout
+
+	mu       sync.Mutex
+	queue    chan []ChangeEvent
+	pending  sync.WaitGroup
+	Dropped  int   // Batches that didn't fit on the queue
+	Failed   int   // Batches that couldn't be POSTed
+	LastErr  error // ... and why the most recent one couldn't
+}
+
+func (s *WebhookSink)Publish(ctx context.Context, events []ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queue == nil {
+		s.queue = make(chan []ChangeEvent, KWebhookQueueLen)
+		go s.run()
+	}
+
+	s.pending.Add(1)
+	select {
+	case s.queue <- events:
+		return nil
+	default:
+		s.pending.Done()
+		s.Dropped++
+		return fmt.Errorf("WebhookSink: queue full, dropped %d events", len(events))
+	}
+}
+
+// Flush waits until everything published so far has been POSTed (or has failed).
+func (s *WebhookSink)Flush() { s.pending.Wait() }
+
+func (s *WebhookSink)run() {
+	for events := range s.queue {
+		if err := s.post(events); err != nil {
+			s.mu.Lock()
+			s.Failed++
+			s.LastErr = err
+			s.mu.Unlock()
+		}
+		s.pending.Done()
+	}
+}
+
+// The publisher's context is likely a request's, which may well be gone by now.
+func (s *WebhookSink)post(events []ChangeEvent) error {
+	body,err := json.Marshal(events)
+	if err != nil { return fmt.Errorf("WebhookSink: %v", err) }
+
+	req,err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil { return fmt.Errorf("WebhookSink: %v", err) }
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil { client = &http.Client{Timeout: KWebhookTimeout} }
+
+	resp,err := client.Do(req)
+	if err != nil { return fmt.Errorf("WebhookSink: %v", err) }
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("WebhookSink: %s returned %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// }}}
+
+// {{{ bufferSink
+
+// bufferSink holds on to events until they can be published for real (e.g. after a commit).
+type bufferSink struct {
+	events []ChangeEvent
+}
+
+func (s *bufferSink)Publish(ctx context.Context, events []ChangeEvent) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// }}}
+// {{{ db.publishChanges
+
+func (db *FlightDB)publishChanges(events ...ChangeEvent) {
+	if db.Changes == nil || len(events) == 0 { return }
+	if err := db.Changes.Publish(db.Ctx(), events); err != nil {
+		db.Errorf("publishChanges: %d events lost: %v", len(events), err)
+	}
+}
+
+// }}}
+// {{{ db.persistChangeEvent, db.deleteChangeEvents
+
+// persistChangeEvent needs the stored copy of the flight (or nil, if it's new), as it was before
+// being overwritten, to see the old tags; PersistFlight has already fetched it.
+func (db *FlightDB)persistChangeEvent(keyer ds.Keyer, f *fdb.Flight, old *fdb.IndexedFlightBlob, reason string) ChangeEvent {
+	ev := ChangeEvent{
+		Type: ChangeCreated,
+		Time: time.Now(),
+		Key: keyer.Encode(),
+		IdSpec: idSpecOrEmpty(f),
+		Reason: reason,
+	}
+
+	oldTags := []string{}
+	if old != nil {
+		ev.Type = ChangeUpdated
+		oldTags = old.Tags
+	}
+
+	ev.TagsAdded,ev.TagsRemoved = diffTags(oldTags, f.IndexTagList())
+	return ev
+}
+
+// deleteChangeEvents needs to run before the flights are deleted, to find out what they were.
+func (db *FlightDB)deleteChangeEvents(keyers []ds.Keyer) []ChangeEvent {
+	events := []ChangeEvent{}
+	for _,keyer := range keyers {
+		ev := ChangeEvent{Type: ChangeDeleted, Time: time.Now(), Key: keyer.Encode()}
+		if blob,err := db.LookupBlob(keyer); err != nil {
+			db.Warningf("deleteChangeEvents: %v", err) // Publish what we know
+		} else {
+			ev.TagsRemoved = blob.Tags
+			if f,err := blob.ToFlight(ev.Key); err == nil {
+				ev.IdSpec = idSpecOrEmpty(f)
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func idSpecOrEmpty(f *fdb.Flight) string {
+	if len(f.Timeslots()) == 0 { return "" }
+	return f.IdSpecString()
+}
+
+// Both inputs are treated as sets.
+func diffTags(old, new []string) (added, removed []string) {
+	oldSet,newSet := map[string]bool{},map[string]bool{}
+	for _,t := range old { oldSet[t] = true }
+	for _,t := range new { newSet[t] = true }
+	for _,t := range new { if !oldSet[t] { added = append(added, t) } }
+	for _,t := range old { if !newSet[t] { removed = append(removed, t) } }
+	return
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,128 @@
+package fgae
+
+// Flights whose blobs are too big for a single datastore entity get their blob split into
+// chunks (see fdb.IndexedFlightBlob.SplitIntoChunks). The parent entity keeps all the indexed
+// fields, so queries work as normal; the chunks are child entities of the parent, with IDs
+// 1..NumChunks, so they can be fetched by key without a query.
+
+import(
+	"context"
+	"fmt"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+)
+
+const kFlightChunkKind = "flightchunk"
+
+// {{{ chunkKeyers
+
+func chunkKeyers(ctx context.Context, p ds.DatastoreProvider, parent ds.Keyer, n int) []ds.Keyer {
+	keyers := []ds.Keyer{}
+	for i:=1; i<=n; i++ {
+		keyers = append(keyers, p.NewIDKey(ctx, kFlightChunkKind, int64(i), parent))
+	}
+	return keyers
+}
+
+// }}}
+
+// {{{ putBlob
+
+// putBlob writes the blob, and any chunks it needs to be split into. The chunks go first, so the
+// parent is never visible without them. If the flight might have been stored before, we also
+// clear out any chunks left over from a bigger, earlier version of it.
+func putBlob(ctx context.Context, p ds.DatastoreProvider, keyer ds.Keyer, blob *fdb.IndexedFlightBlob, maybeStale bool) error {
+	chunks := blob.SplitIntoChunks()
+	if len(chunks) > 0 {
+		if _,err := p.PutMulti(ctx, chunkKeyers(ctx,p,keyer,len(chunks)), chunks); err != nil {
+			return fmt.Errorf("putBlob chunks: %v", err)
+		}
+	}
+
+	if _,err := p.Put(ctx, keyer, blob); err != nil {
+		return err
+	}
+
+	if maybeStale {
+		keyers,err := lookupChunkKeyers(ctx, p, keyer)
+		if err != nil { return fmt.Errorf("putBlob stale chunks: %v", err) }
+		if len(keyers) > len(chunks) {
+			// Key order means the extras are at the end
+			if err := p.DeleteMulti(ctx, keyers[len(chunks):]); err != nil {
+				return fmt.Errorf("putBlob stale chunks: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// }}}
+// {{{ loadChunks
+
+// loadChunks fetches and joins the chunks for the blob, if it has any.
+func loadChunks(ctx context.Context, p ds.DatastoreProvider, keyer ds.Keyer, blob *fdb.IndexedFlightBlob) error {
+	if blob.NumChunks == 0 { return nil }
+
+	chunks := make([]fdb.FlightBlobChunk, blob.NumChunks)
+	if err := p.GetMulti(ctx, chunkKeyers(ctx,p,keyer,blob.NumChunks), chunks); err != nil {
+		return fmt.Errorf("loadChunks: %v", err)
+	}
+
+	return blob.JoinChunks(chunks)
+}
+
+// }}}
+// {{{ lookupChunkKeyers
+
+// Ancestor queries find all descendants, so we need to skip chunks that belong to the flight's
+// revisions (see revisions.go).
+func lookupChunkKeyers(ctx context.Context, p ds.DatastoreProvider, keyer ds.Keyer) ([]ds.Keyer, error) {
+	q := ds.NewQuery(kFlightChunkKind).Ancestor(keyer).KeysOnly()
+	all,err := p.GetAll(ctx, q, nil)
+	if err != nil { return nil, err }
+
+	keyers := []ds.Keyer{}
+	for _,k := range all {
+		if p.KeyParent(k).Encode() == keyer.Encode() {
+			keyers = append(keyers, k)
+		}
+	}
+	return keyers, nil
+}
+
+// }}}
+// {{{ deleteChunks
+
+// deleteChunks removes any chunks stored under the keyers. (The parents are deleted first, so
+// if anything goes wrong we're left with orphaned chunks, rather than with broken flights.)
+func deleteChunks(ctx context.Context, p ds.DatastoreProvider, keyers []ds.Keyer) error {
+	chunkKeyers := []ds.Keyer{}
+	for _,keyer := range keyers {
+		these,err := lookupChunkKeyers(ctx, p, keyer)
+		if err != nil { return fmt.Errorf("deleteChunks: %v", err) }
+		chunkKeyers = append(chunkKeyers, these...)
+	}
+
+	for len(chunkKeyers) > 0 {
+		n := 500 // max keys in one DeleteMulti call
+		if n > len(chunkKeyers) { n = len(chunkKeyers) }
+		if err := p.DeleteMulti(ctx, chunkKeyers[:n]); err != nil {
+			return fmt.Errorf("deleteChunks: %v", err)
+		}
+		chunkKeyers = chunkKeyers[n:]
+	}
+
+	return nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,146 @@
+package fgae
+
+// CloudTransactor is a ds.CloudDSProvider that can also run transactions. The CloudDSProvider
+// keeps its datastore client to itself, so we open a second one for the transactions; keys are
+// *datastore.Key in both, so they can be passed back and forth freely. Neither client is tied
+// to a request, so make one CloudTransactor per process, and share it.
+
+import(
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+	"github.com/skypies/util/gcp/ds"
+)
+
+type CloudTransactor struct {
+	*ds.CloudDSProvider
+	client  *datastore.Client
+}
+
+func NewCloudTransactor(ctx context.Context, project string) (*CloudTransactor, error) {
+	p,err := ds.NewCloudDSProvider(ctx, project)
+	if err != nil { return nil, err }
+
+	client,err := datastore.NewClient(ctx, project)
+	if err != nil { return nil, fmt.Errorf("NewCloudTransactor: %v", err) }
+
+	return &CloudTransactor{CloudDSProvider:p, client:client}, nil
+}
+
+// {{{ ct.RunInTransaction
+
+// We only make one attempt; FlightDB.RunInTransaction handles retries.
+func (ct *CloudTransactor)RunInTransaction(ctx context.Context, f func(tx ds.DatastoreProvider) error) error {
+	_,err := ct.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(&cloudTx{CloudDSProvider:ct.CloudDSProvider, client:ct.client, tx:tx})
+	}, datastore.MaxAttempts(1))
+
+	if err == datastore.ErrConcurrentTransaction {
+		return ErrConcurrentTransaction
+	}
+	return err
+}
+
+// }}}
+
+// {{{ cloudTx
+
+// cloudTx routes reads and writes through the transaction; everything else (key construction,
+// logging etc) goes to the embedded provider.
+type cloudTx struct {
+	*ds.CloudDSProvider
+	client  *datastore.Client
+	tx      *datastore.Transaction
+}
+
+func unpackCloudKeyers(in []ds.Keyer) []*datastore.Key {
+	out := []*datastore.Key{}
+	for _,keyer := range in {
+		out = append(out, keyer.(*datastore.Key))
+	}
+	return out
+}
+
+func cloudTxErr(err error) error {
+	if err == datastore.ErrNoSuchEntity { return ds.ErrNoSuchEntity }
+	if _,ok := err.(*datastore.ErrFieldMismatch); ok { return ds.ErrFieldMismatch }
+	return err
+}
+
+func (t *cloudTx)Get(ctx context.Context, keyer ds.Keyer, dst interface{}) error {
+	return cloudTxErr(t.tx.Get(keyer.(*datastore.Key), dst))
+}
+
+func (t *cloudTx)GetMulti(ctx context.Context, keyers []ds.Keyer, dst interface{}) error {
+	return cloudTxErr(t.tx.GetMulti(unpackCloudKeyers(keyers), dst))
+}
+
+func (t *cloudTx)GetAll(ctx context.Context, in *ds.Query, dst interface{}) ([]ds.Keyer, error) {
+	if in.AncestorKeyer == nil {
+		return nil, fmt.Errorf("GetAll{cloudtx}: only ancestor queries are allowed in transactions")
+	}
+
+	q := datastore.NewQuery(in.Kind).Ancestor(in.AncestorKeyer.(*datastore.Key)).Transaction(t.tx)
+	for _,filter := range in.Filters {
+		q = q.Filter(filter.Field, filter.Value)
+	}
+	if in.OrderStr != "" { q = q.Order(in.OrderStr) }
+	if in.KeysOnlyVal    { q = q.KeysOnly() }
+	if in.LimitVal != 0  { q = q.Limit(in.LimitVal) }
+
+	keys,err := t.client.GetAll(ctx, q, dst)
+	if err != nil {
+		return nil, fmt.Errorf("GetAll{cloudtx}: %v\nQuery: %s", cloudTxErr(err), in)
+	}
+
+	keyers := []ds.Keyer{}
+	for _,k := range keys {
+		keyers = append(keyers, k)
+	}
+	return keyers, nil
+}
+
+// Inside a transaction, keys for new entities aren't known until commit, so we insist on
+// complete keys (FlightDB always uses them).
+func (t *cloudTx)Put(ctx context.Context, keyer ds.Keyer, src interface{}) (ds.Keyer, error) {
+	k := keyer.(*datastore.Key)
+	if k.Incomplete() {
+		return nil, fmt.Errorf("Put{cloudtx}: incomplete keys not supported")
+	}
+	_,err := t.tx.Put(k, src)
+	return keyer, err
+}
+
+func (t *cloudTx)PutMulti(ctx context.Context, keyers []ds.Keyer, src interface{}) ([]ds.Keyer, error) {
+	keys := unpackCloudKeyers(keyers)
+	for _,k := range keys {
+		if k.Incomplete() {
+			return nil, fmt.Errorf("PutMulti{cloudtx}: incomplete keys not supported")
+		}
+	}
+	_,err := t.tx.PutMulti(keys, src)
+	return keyers, err
+}
+
+func (t *cloudTx)Delete(ctx context.Context, keyer ds.Keyer) error {
+	return cloudTxErr(t.tx.Delete(keyer.(*datastore.Key)))
+}
+
+func (t *cloudTx)DeleteMulti(ctx context.Context, keyers []ds.Keyer) error {
+	return cloudTxErr(t.tx.DeleteMulti(unpackCloudKeyers(keyers)))
+}
+
+func (t *cloudTx)RunInTransaction(ctx context.Context, f func(tx ds.DatastoreProvider) error) error {
+	return fmt.Errorf("RunInTransaction{cloudtx}: %w", ErrNestedTransaction)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -1,66 +1,310 @@
 package fgae
 
+// Condensed flights are kept in per-day rollups, so that range queries can be answered without
+// loading any flights. A day's rollup holds the CondensedFlight for every flight that touches
+// the day, keyed by the flight's datastore key; it is split across KCondensedRollupShards
+// entities, to keep them under datastore's size limit and to spread out the writes. Rollups are
+// updated (inside their own transaction) whenever a flight is persisted or deleted, so today's
+// flights are queryable straight away. Persists and deletes made inside RunInTransaction only
+// update the rollups once that transaction has committed; that keeps the contended shards out
+// of the flight's transaction, and rollups never see a write that got rolled back. Updates are
+// idempotent, so any that fail can be mended by rebuilding the day.
+//
+// Days with no rollup at all (they predate rollups) are answered by loading the flights, as
+// before. Days that were only partly rolled up can be filled in with RebuildCondensedRollups
+// (see the 'condense' batch job).
+
 import(
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
 	"time"
 
 	"context"
 
+	"github.com/skypies/util/date"
 	"github.com/skypies/util/gcp/ds"
-	sprovider "github.com/skypies/util/gcp/singleton"
-	"github.com/skypies/util/singleton"
 
 	fdb "github.com/skypies/flightdb"
 )
 
-// {{{ db.FetchCondensedFlights
+const kCondensedRollupKind = "condensedrollup"
 
-func (db FlightDB)FetchCondensedFlights(s,e time.Time, tags []string) ([]fdb.CondensedFlight,error,string) {
-	key := fmt.Sprintf("condensed-%d:%d-%v", s.Unix(), e.Unix(), tags)
-	str := fmt.Sprintf("FetchCondensedFlights\n* s: %s\n* e: %s\nt: %v\nk: %s\n\n", s, e, tags, key)
+// How many entities each day's rollup is split across; changing it orphans existing rollups,
+// so they would need rebuilding.
+var KCondensedRollupShards = 32
+
+// One shard of a day's rollup.
+type condensedRollup struct {
+	Day  string                              // PDT day, as "2006-01-02"
+	Blob []byte     `datastore:",noindex"`   // gzipped JSON; map of flight keys to fdb.CondensedFlight
+}
+
+// {{{ rollup.flights, rollup.setFlights
+
+func (r condensedRollup)flights() (map[string]fdb.CondensedFlight, error) {
+	cfs := map[string]fdb.CondensedFlight{}
+	if len(r.Blob) == 0 { return cfs, nil }
+
+	gzr,err := gzip.NewReader(bytes.NewReader(r.Blob))
+	if err != nil { return nil, err }
+	b,err := io.ReadAll(gzr)
+	if err != nil { return nil, err }
+
+	err = json.Unmarshal(b, &cfs)
+	return cfs, err
+}
+
+func (r *condensedRollup)setFlights(cfs map[string]fdb.CondensedFlight) error {
+	b,err := json.Marshal(cfs)
+	if err != nil { return err }
 
-	coolOff := time.Hour*2 // time.Hour*25 {  // I can't remember why it needed to be 25h ?
-	if time.Since(e) < coolOff {
-		return []fdb.CondensedFlight{}, fmt.Errorf("Can't condense; inside cooling period %v", coolOff), str
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if _,err := gzw.Write(b); err != nil { return err }
+	if err := gzw.Close(); err != nil { return err }
+
+	r.Blob = buf.Bytes()
+	return nil
+}
+
+// }}}
+// {{{ rollupDays
+
+// rollupDays lists the PDT days that the time range touches, as midnights.
+func rollupDays(s,e time.Time) []time.Time {
+	days := []time.Time{}
+	for d := date.AtLocalMidnight(date.InPdt(s)); !d.After(e); d = d.AddDate(0,0,1) {
+		days = append(days, d)
 	}
-	
-	cfs := []fdb.CondensedFlight{}
+	return days
+}
 
-	sp := sprovider.NewProvider(db.Backend)
-	sp.ErrIfNotFound = true // We care about this
+func rollupDayName(day time.Time) string { return day.Format("2006-01-02") }
 
-	// If we find something, return it; else carry on
-	if err := sp.ReadSingleton(db.Ctx(), key, nil, &cfs); err == nil {
-		str += fmt.Sprintf("found %d OK\n", len(cfs))
-		return cfs,nil,str
+// storedBlob fetches the flight as it was last persisted, for its index fields (any chunks are
+// left unloaded); it returns nil if there's no such flight.
+func storedBlob(ctx context.Context, p ds.DatastoreProvider, keyer ds.Keyer) (*fdb.IndexedFlightBlob, error) {
+	old := fdb.IndexedFlightBlob{}
+	if err := p.Get(ctx, keyer, &old); err == ds.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil && err != ds.ErrFieldMismatch {
+		return nil, fmt.Errorf("storedBlob: %v", err)
+	}
+	return &old, nil
+}
+
+// storedRollupDays lists the days that the flight touched when it was last persisted.
+func storedRollupDays(ctx context.Context, p ds.DatastoreProvider, keyer ds.Keyer) ([]string, error) {
+	old,err := storedBlob(ctx, p, keyer)
+	if err != nil { return nil, fmt.Errorf("storedRollupDays: %v", err) }
+	return blobRollupDays(old), nil
+}
 
-	} else if err != singleton.ErrNoSuchEntity {
-		return []fdb.CondensedFlight{}, fmt.Errorf("condense load: %v",err), str
+func blobRollupDays(old *fdb.IndexedFlightBlob) []string {
+	if old == nil || len(old.Timeslots) == 0 { return nil }
+
+	s,e := old.Timeslots[0], old.Timeslots[0]
+	for _,t := range old.Timeslots {
+		if t.Before(s) { s = t }
+		if t.After(e) { e = t }
 	}
 
-	// OK, do it the hard way
-	cfs,err,fetchstr := fetchCondensedFlightsIndividually(db.Ctx(), db.Backend, s,e,tags);
-	str += "--\n" + fetchstr + "--\n"
-	if err != nil {
-		return []fdb.CondensedFlight{}, err, str
+	days := []string{}
+	for _,day := range rollupDays(s,e) { days = append(days, rollupDayName(day)) }
+	return days
+}
+
+// }}}
+// {{{ db.updateRollups, db.applyRollups
+
+func rollupKeyer(ctx context.Context, p ds.DatastoreProvider, day, flightKey string) ds.Keyer {
+	h := fnv.New32a()
+	h.Write([]byte(flightKey))
+	shard := h.Sum32() % uint32(KCondensedRollupShards)
+	return p.NewNameKey(ctx, kCondensedRollupKind, fmt.Sprintf("%s/%02d", day, shard), nil)
+}
+
+// A rollupUpdate puts a flight into the rollups for the days it touches, and takes it out of any
+// of the oldDays that it no longer touches. With no flight, it's taken out of all the oldDays.
+type rollupUpdate struct {
+	flightKey  string
+	cf        *fdb.CondensedFlight
+	oldDays  []string
+}
+
+// rollupBuffer holds on to rollup updates until their transaction has committed.
+type rollupBuffer struct {
+	updates []rollupUpdate
+}
+
+// updateRollups applies the update straight away, unless db is inside a transaction; then it
+// waits for RunInTransaction to apply it, after the commit.
+func (db *FlightDB)updateRollups(keyer ds.Keyer, f *fdb.Flight, oldDays []string) error {
+	u := rollupUpdate{flightKey:keyer.Encode(), oldDays:oldDays}
+	if f != nil && len(f.AnyTrack()) > 0 {
+		u.cf = f.Condense()
 	}
-	str += fmt.Sprintf("raw lookup found %d matches\n", len(cfs))
 
-	if err := sp.WriteSingleton(db.Ctx(), key, nil, &cfs); err != nil {
-		return []fdb.CondensedFlight{}, fmt.Errorf("condense save: %v",err), str
+	if db.rollups != nil {
+		db.rollups.updates = append(db.rollups.updates, u)
+		return nil
 	}
+	return db.applyRollupUpdate(u)
+}
 
-	return cfs, nil, str
+// applyRollups applies the updates held back by a transaction that has now committed. The
+// flights are safely written by then, so failures are only logged; the 'condense' batch job can
+// put the rollups right.
+func (db *FlightDB)applyRollups(buf *rollupBuffer) {
+	for _,u := range buf.updates {
+		if err := db.applyRollupUpdate(u); err != nil {
+			db.Errorf("applyRollups: %s: %v", u.flightKey, err)
+		}
+	}
+}
+
+func (db *FlightDB)applyRollupUpdate(u rollupUpdate) error {
+	flightKey,cf := u.flightKey,u.cf
+
+	days := map[string]bool{} // Whether the flight should be in the day's rollup
+	for _,day := range u.oldDays { days[day] = false }
+	if cf != nil {
+		for _,day := range rollupDays(cf.Start, cf.End) { days[rollupDayName(day)] = true }
+	}
+	if len(days) == 0 { return nil }
+
+	err := db.RunInTransaction(func(txdb FlightDB) error {
+		for day,in := range days {
+			rk := rollupKeyer(txdb.Ctx(), txdb.Backend, day, flightKey)
+			rollup := condensedRollup{Day: day}
+			if err := txdb.Backend.Get(txdb.Ctx(), rk, &rollup); err != nil && err != ds.ErrNoSuchEntity {
+				return err
+			}
+			cfs,err := rollup.flights()
+			if err != nil { return err }
+
+			if in {
+				cfs[flightKey] = *cf
+			} else if _,exists := cfs[flightKey]; exists {
+				delete(cfs, flightKey)
+			} else {
+				continue
+			}
+
+			if err := rollup.setFlights(cfs); err != nil { return err }
+			if _,err := txdb.Backend.Put(txdb.Ctx(), rk, &rollup); err != nil { return err }
+		}
+		return nil
+	})
+	if err != nil { return fmt.Errorf("applyRollupUpdate: %v", err) }
+
+	return nil
 }
 
 // }}}
+// {{{ db.deleteRollups
+
+// deleteRollups takes the flights out of the rollups; it needs to run before the flights are
+// deleted, to find out which days they were in.
+func (db *FlightDB)deleteRollups(keyers []ds.Keyer) error {
+	for _,keyer := range keyers {
+		days,err := storedRollupDays(db.Ctx(), db.Backend, keyer)
+		if err != nil { return fmt.Errorf("deleteRollups: %v", err) }
+		if err := db.updateRollups(keyer, nil, days); err != nil {
+			return fmt.Errorf("deleteRollups: %v", err)
+		}
+	}
+	return nil
+}
+
+// }}}
+
+// {{{ db.FetchCondensedFlights
 
+// FetchCondensedFlights stitches together the rollups for the days that [s,e] touches, and
+// returns the condensed flights that overlap the range and have all the tags (which, as with
+// FQuery.ByTags, may include waypoint tags), ordered by start time.
+func (db FlightDB)FetchCondensedFlights(s,e time.Time, tags []string) ([]fdb.CondensedFlight,error,string) {
+	str := fmt.Sprintf("FetchCondensedFlights\n* s: %s\n* e: %s\nt: %v\n\n", s, e, tags)
+
+	all := map[string]fdb.CondensedFlight{} // Flights that span midnight are in two rollups
+
+	for _,day := range rollupDays(s,e) {
+		q := ds.NewQuery(kCondensedRollupKind).Filter("Day = ", rollupDayName(day))
+		rollups := []condensedRollup{}
+		if _,err := db.Backend.GetAll(db.Ctx(), q, &rollups); err != nil {
+			return []fdb.CondensedFlight{}, fmt.Errorf("FetchCondensedFlights: %v", err), str
+		}
+
+		if len(rollups) == 0 {
+			// No rollup for this day; do it the hard way
+			dayStart,dayEnd := date.WindowForTime(day)
+			if dayStart.Before(s) { dayStart = s }
+			if dayEnd.After(e) { dayEnd = e }
+			cfs,err,fetchstr := fetchCondensedFlightsIndividually(db.Ctx(), db.Backend, dayStart,dayEnd, tags)
+			str += "--\n" + fetchstr + "--\n"
+			if err != nil { return []fdb.CondensedFlight{}, err, str }
+			for k,cf := range cfs { all[k] = cf }
+			str += fmt.Sprintf("* %s: no rollup, raw lookup found %d\n", rollupDayName(day), len(cfs))
+			continue
+		}
+
+		n := 0
+		for _,rollup := range rollups {
+			cfs,err := rollup.flights()
+			if err != nil {
+				return []fdb.CondensedFlight{}, fmt.Errorf("FetchCondensedFlights: %v", err), str
+			}
+			for k,cf := range cfs { all[k] = cf }
+			n += len(cfs)
+		}
+		str += fmt.Sprintf("* %s: %d in rollup\n", rollupDayName(day), n)
+	}
+
+	cfs := []fdb.CondensedFlight{}
+	for _,cf := range all {
+		if cf.End.Before(s) || cf.Start.After(e) || !condensedHasTags(cf, tags) { continue }
+		cfs = append(cfs, cf)
+	}
+
+	sort.Slice(cfs, func(i,j int) bool { return cfs[i].Start.Before(cfs[j].Start) })
+	str += fmt.Sprintf("found %d OK\n", len(cfs))
+
+	return cfs, nil, str
+}
+
+func condensedHasTags(cf fdb.CondensedFlight, tags []string) bool {
+	for _,tag := range tags {
+		if strings.HasPrefix(tag, fdb.KWaypointTagPrefix) {
+			if _,exists := cf.Waypoints[strings.TrimPrefix(tag, fdb.KWaypointTagPrefix)]; !exists {
+				return false
+			}
+			continue
+		}
+
+		found := false
+		for _,t := range cf.Tags {
+			if t == tag { found = true; break }
+		}
+		if !found { return false }
+	}
+	return true
+}
+
+// }}}
 // {{{ fetchCondensedFlightsIndividually
 
-func fetchCondensedFlightsIndividually(ctx context.Context, p ds.DatastoreProvider, s,e time.Time, tags []string) ([]fdb.CondensedFlight,error,string) {
+// Returns the condensed flights, keyed by their flight keys.
+func fetchCondensedFlightsIndividually(ctx context.Context, p ds.DatastoreProvider, s,e time.Time, tags []string) (map[string]fdb.CondensedFlight,error,string) {
 	str := "# individual lookup\n"
 
-	ret := []fdb.CondensedFlight{}
+	ret := map[string]fdb.CondensedFlight{}
 
 	q := QueryForTimeRange(tags, s, e)
 	it := NewFlightIterator(ctx, p, q)
@@ -68,7 +312,7 @@ func fetchCondensedFlightsIndividually(ctx context.Context, p ds.DatastoreProvid
 	tStart := time.Now()
 	for it.Iterate(ctx) {
 		cf := it.Flight().Condense()
-		ret = append(ret, *cf)
+		ret[it.Flight().GetDatastoreKey()] = *cf
 		if i<50 {
 			str += fmt.Sprintf("# [%3d] %s\n", i, cf)
 		}
@@ -82,6 +326,29 @@ func fetchCondensedFlightsIndividually(ctx context.Context, p ds.DatastoreProvid
 	return ret,nil,str
 }
 
+// }}}
+// {{{ db.RebuildCondensedRollups
+
+// RebuildCondensedRollups puts all the flights in the time range into the rollups.
+func (db FlightDB)RebuildCondensedRollups(s,e time.Time) (int, error) {
+	n := 0
+	pi := db.NewParallelIterator(QueryForTimeRange(nil, s, e), 0)
+	defer pi.Cancel() // In case we bail early
+	for f := range pi.Flights() {
+		keyer,err := db.Backend.DecodeKey(f.GetDatastoreKey())
+		if err != nil { return n, fmt.Errorf("RebuildCondensedRollups: %v", err) }
+		if err := db.updateRollups(keyer, f, nil); err != nil {
+			return n, fmt.Errorf("RebuildCondensedRollups: %v", err)
+		}
+		n++
+	}
+	if pi.Err() != nil {
+		return n, fmt.Errorf("RebuildCondensedRollups: %v", pi.Err())
+	}
+
+	return n, nil
+}
+
 // }}}
 
 // {{{ -------------------------={ E N D }=----------------------------------
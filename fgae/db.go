@@ -5,25 +5,19 @@ import(
 	"context"
 	"github.com/skypies/util/gcp/ds"
 	fdb "github.com/skypies/flightdb"
-	"github.com/skypies/flightdb/ref"
 )
 
 // {{{ db.MergeCachedAirframes
 
-// MergeAirframeCache will load up the airframecache singleton, and will in-place update all
-// flights passed to it.
+// MergeCachedAirframes will in-place update all flights passed to it with data from the
+// airframecache singleton (via the in-process copy; see airframes.go).
 func (db *FlightDB)MergeCachedAirframes(flights []*fdb.Flight) error {
-	airframes,err := ref.LoadAirframeCache(db.ctx, db.SingletonProvider)
+	ac,err := db.cachedAirframes()
 	if err != nil {
 		return err
 	}
 
-	for _,f := range flights {
-		if f == nil { continue }
-		if af := airframes.Get(f.IcaoId); af != nil {
-			f.OverlayAirframe(*af)
-		}
-	}
+	overlayAirframes(ac, flights...)
 
 	return nil
 }
@@ -33,39 +27,143 @@ func (db *FlightDB)MergeCachedAirframes(flights []*fdb.Flight) error {
 // {{{ db.PersistFlight
 
 func (db *FlightDB)PersistFlight(f *fdb.Flight) error {
+	return db.PersistFlightWithReason(f, "")
+}
+
+// PersistFlightWithReason is PersistFlight, but if db.KeepRevisions is set, the reason is
+// recorded against the revision that this write supersedes.
+func (db *FlightDB)PersistFlightWithReason(f *fdb.Flight, reason string) error {
+	return db.persistFlight(f, reason, false)
+}
+
+// persistFlight can also refuse to overwrite a stored flight that has changed since f was loaded
+// from it (see checkUnchanged).
+func (db *FlightDB)persistFlight(f *fdb.Flight, reason string, ifUnchanged bool) error {
 	keyer,err := findOrGenerateFlightKey(db.Ctx(), db.Backend, f)
 	if err != nil { return fmt.Errorf("PersistFlight: %v", err) }
+
+	// The stored copy (nil if there isn't one), fetched just the once for the concurrency check,
+	// the change event and the rollups
+	var old *fdb.IndexedFlightBlob
+	if f.GetDatastoreKey() != "" || db.Changes != nil {
+		if old,err = storedBlob(db.Ctx(), db.Backend, keyer); err != nil {
+			return fmt.Errorf("PersistFlight: %v", err)
+		}
+	}
+
+	if ifUnchanged {
+		if err := db.checkUnchanged(f, old); err != nil {
+			return err // Don't wrap; callers look for ErrConcurrentTransaction
+		}
+	}
+
+	var change *ChangeEvent
+	if db.Changes != nil {
+		ev := db.persistChangeEvent(keyer, f, old, reason)
+		change = &ev
+	}
+
+	if db.KeepRevisions && f.GetDatastoreKey() != "" {
+		if err := db.saveRevision(keyer, reason); err != nil {
+			return fmt.Errorf("PersistFlight: %v", err)
+		}
+	}
 	
+	// The days it was in before, in case it's no longer in some of them
+	oldDays := []string{}
+	if f.GetDatastoreKey() != "" {
+		oldDays = blobRollupDays(old)
+	}
+
 	if blob,err := f.ToBlob(); err != nil {
 		return fmt.Errorf("PersistFlight: %v", err)
 	} else {
-		if len(blob.Blob) > 1000000 {
-			return fmt.Errorf("PersistFlight %q: blob too big (%d)", f.IdentityString(), len(blob.Blob))
-		}
-
-		_, err = db.Backend.Put(db.Ctx(), keyer, blob)
-		if err != nil {
+		// Flights that were loaded from datastore might have been chunked before
+		maybeStale := f.GetDatastoreKey() != ""
+		if err := putBlob(db.Ctx(), db.Backend, keyer, blob, maybeStale); err != nil {
 			return fmt.Errorf("PersistFlight %q: %v", f.IdentityString(), err)
 		}
 	}
 
+	if err := db.updateRollups(keyer, f, oldDays); err != nil {
+		return fmt.Errorf("PersistFlight: %v", err)
+	}
+
+	if change != nil {
+		db.publishChanges(*change)
+	}
+
 	return nil
 }
 
 // }}}
+// {{{ db.KeyForBlob, db.RestoreBlob
 
-// {{{ db.LookupKey
+// KeyForBlob works out the key that PersistFlight gave the flight in the blob when it was first
+// stored, for blobs that have lost track of it (e.g. those in archives).
+func (db *FlightDB)KeyForBlob(blob *fdb.IndexedFlightBlob) (ds.Keyer, error) {
+	f,err := blob.ToFlight("")
+	if err != nil { return nil, fmt.Errorf("KeyForBlob: %v", err) }
+	if len(f.AnyTrack()) == 0 { return nil, fmt.Errorf("KeyForBlob: flight has no trackpoints") }
 
-func (db *FlightDB)LookupKey(keyer ds.Keyer) (*fdb.Flight, error) {
+	return findOrGenerateFlightKey(db.Ctx(), db.Backend, f)
+}
+
+// RestoreBlob writes the blob back as it is, under the key (overwriting anything already there),
+// rather than regenerating it from the flight like PersistFlight does.
+func (db *FlightDB)RestoreBlob(keyer ds.Keyer, blob fdb.IndexedFlightBlob) error {
+	// Decode before putBlob, which leaves the blob split up into chunks
+	f,err := blob.ToFlight(keyer.Encode())
+	if err != nil { return fmt.Errorf("RestoreBlob: %v", err) }
+
+	oldDays,err := storedRollupDays(db.Ctx(), db.Backend, keyer)
+	if err != nil { return fmt.Errorf("RestoreBlob: %v", err) }
+
+	if err := putBlob(db.Ctx(), db.Backend, keyer, &blob, true); err != nil {
+		return fmt.Errorf("RestoreBlob: %v", err)
+	}
+
+	if err := db.updateRollups(keyer, f, oldDays); err != nil {
+		return fmt.Errorf("RestoreBlob: %v", err)
+	}
+	return nil
+}
+
+// }}}
+
+// {{{ db.LookupBlob
+
+// LookupBlob returns the raw blob (with any chunks joined up), for things that care about how
+// the flight is stored.
+func (db *FlightDB)LookupBlob(keyer ds.Keyer) (*fdb.IndexedFlightBlob, error) {
 	blob := fdb.IndexedFlightBlob{}
 
 	if err := db.Backend.Get(db.Ctx(), keyer, &blob); err != nil {
+		return nil, err
+	} else if err := loadChunks(db.Ctx(), db.Backend, keyer, &blob); err != nil {
+		return nil, err
+	}
+
+	return &blob, nil
+}
+
+// }}}
+// {{{ db.LookupKey
+
+func (db *FlightDB)LookupKey(keyer ds.Keyer) (*fdb.Flight, error) {
+	blob,err := db.LookupBlob(keyer)
+	if err != nil {
 		return nil, fmt.Errorf("GetByKey: %v", err)
 	}
 
 	f, err := blob.ToFlight(keyer.Encode())
-	if err != nil { err = fmt.Errorf("GetByKey: %v", err) }
-	return f,err
+	if err != nil { return nil, fmt.Errorf("GetByKey: %v", err) }
+
+	ac,err := db.airframesForOverlay()
+	if err != nil { return nil, fmt.Errorf("GetByKey: %v", err) }
+	overlayAirframes(ac, f)
+
+	return f,nil
 }
 
 // }}}
@@ -82,15 +180,34 @@ func (db *FlightDB)LookupAll(fq *FQuery) ([]*fdb.Flight, error) {
 
 	flights := []*fdb.Flight{}
 	for i,blob := range blobs {
-		if flight,err := blob.ToFlight(keyers[i].Encode()); err != nil {
+		if err := loadChunks(db.Ctx(), db.Backend, keyers[i], &blob); err != nil {
+			return nil, fmt.Errorf("GetAllByQuery: %v", err)
+		} else if flight,err := blob.ToFlight(keyers[i].Encode()); err != nil {
 			return nil, fmt.Errorf("GetAllByQuery: %v", err)
 		} else {
 			flights = append(flights, flight)
 		}
 	}
 
-	// TODO: do this for real !
-	// db.mergeAirframeCache(flights)
+	if db.Archive != nil && (fq.LimitVal == 0 || len(flights) < fq.LimitVal) {
+		skip := map[string]bool{}
+		for _,f := range flights { skip[f.GetDatastoreKey()] = true }
+
+		archived,err := db.lookupArchives((*ds.Query)(fq), skip)
+		if err != nil {
+			return nil, fmt.Errorf("GetAllByQuery: %v", err)
+		}
+		flights = append(flights, archived...)
+		if fq.LimitVal > 0 && len(flights) > fq.LimitVal {
+			flights = flights[:fq.LimitVal]
+		}
+	}
+
+	ac,err := db.airframesForOverlay()
+	if err != nil {
+		return nil, fmt.Errorf("GetAllByQuery: %v", err)
+	}
+	overlayAirframes(ac, flights...)
 	
 	return flights, nil
 }
@@ -104,9 +221,6 @@ func (db *FlightDB)LookupFirst(fq *FQuery) (*fdb.Flight, error) {
 	} else if len(flights) == 0 {
 		return nil,nil
 	} else {
-		// TODO: do this for real !
-		// db.mergeAirframeCache(flights[:1])
-
 		return flights[0],nil
 	}
 }
@@ -132,14 +246,37 @@ func (db *FlightDB)LookupAllKeys(fq *FQuery) ([]ds.Keyer, error) {
 // {{{ db.DeleteByKey
 
 func (db *FlightDB)DeleteByKey(keyer ds.Keyer) error {
-	return db.Backend.Delete(db.Ctx(), keyer)
+	return db.DeleteAllKeys([]ds.Keyer{keyer})
 }
 
 // }}}
 // {{{ db.DeleteAllKeys
 
 func (db *FlightDB)DeleteAllKeys(keyers []ds.Keyer) error {
-	return db.Backend.DeleteMulti(db.Ctx(), keyers)
+	return db.deleteAllKeys(keyers, false)
+}
+
+// deleteAllKeys can leave the flights' revisions behind, so that they can be rolled back to.
+func (db *FlightDB)deleteAllKeys(keyers []ds.Keyer, keepRevisions bool) error {
+	changes := []ChangeEvent{}
+	if db.Changes != nil {
+		changes = db.deleteChangeEvents(keyers)
+	}
+
+	if err := db.deleteRollups(keyers); err != nil {
+		return err
+	} else if err := db.Backend.DeleteMulti(db.Ctx(), keyers); err != nil {
+		return err
+	} else if err := deleteChunks(db.Ctx(), db.Backend, keyers); err != nil {
+		return err
+	} else if keepRevisions {
+		// Leave them be
+	} else if err := deleteRevisions(db, keyers); err != nil {
+		return err
+	}
+
+	db.publishChanges(changes...)
+	return nil
 }
 
 // }}}
@@ -1,85 +1,66 @@
-package fgae
+package fgae_test
 
-// FIXME: how to run these tests without aetest ?
+// These tests run against a LocalDSProvider, so need no cloud project.
+// (This is an external test package, as faadata imports fgae.)
 
-/*
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
-	"context"
-
-	"google.golang.org/ appengine"
-	"google.golang.org/ appengine/aetest" // Also used for testing Cloud API
-
+	"github.com/skypies/geo"
+	"github.com/skypies/util/date"
 	"github.com/skypies/util/gcp/ds"
 	fdb "github.com/skypies/flightdb"
 	"github.com/skypies/flightdb/faadata" // for quick ascii loading of trackpoints
+	"github.com/skypies/flightdb/fgae"
+	"github.com/skypies/flightdb/ref"
 )
 
-const appid = "mytestapp"
-
 // {{{ loadFlights
 
-func loadFlights(t *testing.T, data string) []*fdb.Flight {
+func loadFlights(t *testing.T, db fgae.FlightDB, data string) []*fdb.Flight {
 	flights := []*fdb.Flight{}
-	callback := func(ctx context.Context, f *fdb.Flight) (bool,string,error) {
+	callback := func(db fgae.FlightDB, f *fdb.Flight) (bool,string,error) {
 		flights = append(flights,f)
 		return true,"",nil
 	}
 
-	_,_,err := faadata.ReadFrom(context.TODO(), "testdata", strings.NewReader(data), callback)
+	_,_,err := faadata.ReadFrom(db, "testdata", "FOIA", strings.NewReader(data), callback)
 	if err != nil { t.Fatal(err) }
 
 	return flights
 }
 
-// }}}
-// {{{ newConsistentContext
-
-// A version of aetest.NewContext() that has a consistent datastore - so we can read our writes.
-func newConsistentContext() (context.Context, func(), error) {
-	inst, err := aetest.NewInstance(&aetest.Options{
-		StronglyConsistentDatastore: true,
-		AppID: appid,
-	})
-	if err != nil {
-		return nil, nil, err
-	}
-	req, err := inst.NewRequest("GET", "/", nil)
-	if err != nil {
-		inst.Close()
-		return nil, nil, err
-	}
-	ctx := appengine.NewContext(req)
-	return ctx, func() {
-		inst.Close()
-	}, nil
-}
-
 // }}}
 
 // {{{ testEverything
 
 func testEverything(t *testing.T, p ds.DatastoreProvider) {
-	ctx, done, err := newConsistentContext()
-	if err != nil { t.Fatal(err) }
-	defer done()
-
-	db := NewDB(ctx)
-	db.Backend = p
+	ctx := context.Background()
+	db := fgae.New(ctx, p)
 	
-	flights := loadFlights(t, fakeFlights)
+	flights := loadFlights(t, db, fakeFlights)
 	for _,f := range flights {
 		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
 	}
 	
-	run := func(expected int, q *FQuery) {
+	run := func(expected int, q *fgae.FQuery) {
 		if results,err := db.LookupAll(q); err != nil {
 			t.Fatal(err)
 		} else if len(results) != expected {
-			t.Errorf("expected %d results, saw %d; query: %s", expected, len(results), q)
+			t.Errorf("expected %d results, saw %d; query: %s", expected, len(results), (*ds.Query)(q))
 			for i,f := range results { fmt.Printf("result [%3d] %s\n", i, f) }
 		}
 	}
@@ -87,6 +68,22 @@ func testEverything(t *testing.T, p ds.DatastoreProvider) {
 	run(len(flights), db.NewQuery())
 	run(3,            db.NewQuery().Limit(3))
 	run(1,            db.NewQuery().ByCallsign(flights[0].Callsign))
+	run(len(flights), db.NewQuery().ByTags([]string{"FOIA"}))
+	run(0,            db.NewQuery().ByTags([]string{"FOIA", "NOSUCHTAG"}))
+
+	// III1234 (00:39) and CCC1234 (01:51) are the only flights in the first four timeslots
+	s,_ := time.Parse(time.RFC3339, "2017-04-01T00:00:00Z")
+	run(2,            db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)))
+	run(1,            db.NewQuery().ByTime(s.Add(40*time.Minute)))
+
+	// BBB1234 is the only flight anywhere near Bishop; GGG1234 the only one up by Red Bluff
+	bishop := geo.Latlong{Lat:36.98, Long:-118.83}
+	run(1,            db.NewQuery().ByBoundingBox(bishop.Box(4,4)))
+	run(len(flights), db.NewQuery().ByBoundingBox(bishop.Box(4000,4000))) // too big to filter
+	grs := fdb.GeoRestrictorSet{R: []geo.Restrictor{
+		geo.SquareBoxRestriction{NamedLatlong:geo.NamedLatlong{Latlong:geo.Latlong{Lat:40.07, Long:-123.06}}, SideKM:2},
+	}}
+	run(1,            db.NewQuery().ByRestrictor(grs))
 
 	// Now delete something
 	first,err := db.LookupFirst(db.NewQuery())
@@ -104,9 +101,10 @@ func testEverything(t *testing.T, p ds.DatastoreProvider) {
 	// Now test the iterator
 	n := 0
 	fi := db.NewIterator(db.NewQuery())
-		for fi.Iterate(ctx) {
-		f := fi.Flight()
-		fmt.Printf(" iterator result: %s\n", f)
+	for fi.Iterate(ctx) {
+		if f := fi.Flight(); f == nil {
+			t.Errorf("iterator returned nil flight")
+		}
 		n++
 	}
 	if fi.Err() != nil {
@@ -116,15 +114,941 @@ func testEverything(t *testing.T, p ds.DatastoreProvider) {
 	if n != nExpected {
 		t.Errorf("test expected to see %d, but saw %d\n", nExpected, n)
 	}
-
 }
 
 // }}}
 
 func TestEverything(t *testing.T) {
-	//testEverything(t, dsprovider.AppengineDSProvider{})
-	// Sadly, the aetest framework hangs on the first Put from the cloud client
-	// testEverything(t, dsprovider.CloudDSProvider{appid})
+	p,err := fgae.NewLocalDSProvider("")
+	if err != nil { t.Fatal(err) }
+	testEverything(t, p)
+}
+
+func TestEverythingOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	p,err := fgae.NewLocalDSProvider(dir)
+	if err != nil { t.Fatal(err) }
+	testEverything(t, p)
+
+	// A fresh provider on the same dir should see everything the first one left behind
+	p2,err := fgae.NewLocalDSProvider(dir)
+	if err != nil { t.Fatal(err) }
+	db := fgae.New(context.Background(), p2)
+	if results,err := db.LookupAll(db.NewQuery()); err != nil {
+		t.Fatal(err)
+	} else if len(results) != 8 {
+		t.Errorf("reloaded provider: expected 8 flights, saw %d", len(results))
+	}
+}
+
+func TestLookupMostRecent(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	// Two flights by the same airframe; the second one persisted should be the most recent
+	flights := loadFlights(t, db, fakeFlights)
+	f1,f2 := flights[0],flights[1]
+	f1.IcaoId,f2.IcaoId = "A12345","A12345"
+	for _,f := range []*fdb.Flight{f1,f2} {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+		time.Sleep(time.Millisecond) // ensure distinct LastUpdate values
+	}
+
+	if f,err := db.LookupMostRecent(db.NewQuery().ByIcaoId("A12345")); err != nil {
+		t.Fatal(err)
+	} else if f == nil || f.Callsign != f2.Callsign {
+		t.Errorf("LookupMostRecent: expected %s, got %v", f2.Callsign, f)
+	}
+}
+
+func TestChunkedFlights(t *testing.T) {
+	ctx := context.Background()
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(ctx, p)
+
+	countChunks := func() int {
+		keyers,err := p.GetAll(ctx, ds.NewQuery("flightchunk").KeysOnly(), nil)
+		if err != nil { t.Fatal(err) }
+		return len(keyers)
+	}
+
+	// Incompressible junk, so the blob ends up needing three chunks
+	junk := make([]byte, 2*fdb.KMaxBlobChunkSize)
+	rand.New(rand.NewSource(1)).Read(junk)
+	f := loadFlights(t, db, fakeFlights)[0]
+	f.DebugLog = base64.StdEncoding.EncodeToString(junk)
+	if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	if n := countChunks(); n != 3 {
+		t.Errorf("expected 3 chunks, saw %d", n)
+	}
+
+	check := func(name string, f2 *fdb.Flight) {
+		if f2 == nil {
+			t.Errorf("%s: no flight", name)
+		} else if f2.DebugLog != f.DebugLog {
+			t.Errorf("%s: flight contents differed after chunking", name)
+		}
+	}
+
+	results,err := db.LookupAll(db.NewQuery().ByCallsign(f.Callsign))
+	if err != nil { t.Fatal(err) }
+	if len(results) != 1 { t.Fatalf("LookupAll: expected 1 result, saw %d", len(results)) }
+	check("LookupAll", results[0])
+
+	keyer,err := p.DecodeKey(results[0].GetDatastoreKey())
+	if err != nil { t.Fatal(err) }
+	f2,err := db.LookupKey(keyer)
+	if err != nil { t.Fatal(err) }
+	check("LookupKey", f2)
+
+	it := db.NewIterator(db.NewQuery())
+	for it.Iterate(ctx) { check("Iterator", it.Flight()) }
+	if it.Err() != nil { t.Fatal(it.Err()) }
+
+	// Shrinking the flight should clear out the chunks
+	f2.DebugLog = ""
+	if err := db.PersistFlight(f2); err != nil { t.Fatal(err) }
+	if n := countChunks(); n != 0 {
+		t.Errorf("after shrinking, expected 0 chunks, saw %d", n)
+	}
+
+	// Restoring a big blob should chunk it up again
+	f.DebugLog = base64.StdEncoding.EncodeToString(junk)
+	blob,err := f.ToBlob()
+	if err != nil { t.Fatal(err) }
+	if err := db.RestoreBlob(keyer, *blob); err != nil { t.Fatal(err) }
+	if n := countChunks(); n != 3 {
+		t.Errorf("after restoring, expected 3 chunks, saw %d", n)
+	}
+	f3,err := db.LookupKey(keyer)
+	if err != nil { t.Fatal(err) }
+	check("RestoreBlob", f3)
+
+	// As should deleting it
+	f.SetDatastoreKey(keyer.Encode())
+	if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	if err := db.DeleteByKey(keyer); err != nil { t.Fatal(err) }
+	if n := countChunks(); n != 0 {
+		t.Errorf("after deleting, expected 0 chunks, saw %d", n)
+	}
+}
+
+func TestLookupPage(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	flights := loadFlights(t, db, fakeFlights)
+	// Some clones of the first flight, so that pages have to split a run of equal StartTimes
+	for i:=0; i<4; i++ {
+		f := loadFlights(t, db, fakeFlights)[0]
+		f.IcaoId,f.Callsign = fmt.Sprintf("A0000%d", i), fmt.Sprintf("ZZZ%d", i)
+		flights = append(flights, f)
+	}
+	for _,f := range flights {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	}
+
+	s,_ := time.Parse(time.RFC3339, "2017-04-01T00:00:00Z")
+	q := fgae.QueryForStartTimeRange([]string{"FOIA"}, s, s.Add(24*time.Hour))
+
+	for _,n := range []int{1, 2, 3, 100} {
+		seen := map[string]bool{}
+		prev := time.Time{}
+		cursor := ""
+		for nPages:=0; ; nPages++ {
+			if nPages > len(flights) { t.Fatalf("n=%d: too many pages", n) }
+			page,next,err := db.LookupPage(q, cursor, n)
+			if err != nil { t.Fatal(err) }
+			if len(page) > n { t.Errorf("n=%d: page had %d flights", n, len(page)) }
+			for _,f := range page {
+				if seen[f.GetDatastoreKey()] { t.Errorf("n=%d: saw %s twice", n, f) }
+				seen[f.GetDatastoreKey()] = true
+				if f.StartTime().Before(prev) { t.Errorf("n=%d: %s out of order", n, f) }
+				prev = f.StartTime()
+			}
+			if next == "" { break }
+			cursor = next
+		}
+		if len(seen) != len(flights) {
+			t.Errorf("n=%d: expected %d flights, saw %d", n, len(flights), len(seen))
+		}
+	}
+
+	if _,_,err := db.LookupPage(q, "garbage!", 2); err == nil {
+		t.Errorf("bad cursor was accepted")
+	}
+
+	defer func(n int) { fgae.KMaxPageSize = n }(fgae.KMaxPageSize)
+	fgae.KMaxPageSize = 2
+	if page,next,err := db.LookupPage(q, "", 100); err != nil {
+		t.Fatal(err)
+	} else if len(page) != 2 || next == "" {
+		t.Errorf("page size wasn't capped; saw %d flights (next=%q)", len(page), next)
+	}
+}
+
+func TestParallelIterator(t *testing.T) {
+	ctx,cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(ctx, p)
+
+	flights := loadFlights(t, db, fakeFlights)
+	for _,f := range flights {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	}
+
+	for _,nWorkers := range []int{0, 1, 3} {
+		seen := map[string]bool{}
+		pi := db.NewParallelIterator(db.NewQuery(), nWorkers)
+		for f := range pi.Flights() {
+			seen[f.GetDatastoreKey()] = true
+		}
+		if pi.Err() != nil {
+			t.Errorf("nWorkers=%d: %v", nWorkers, pi.Err())
+		} else if len(seen) != len(flights) {
+			t.Errorf("nWorkers=%d: expected %d flights, saw %d", nWorkers, len(flights), len(seen))
+		}
+	}
+
+	// Restricted to GGG1234, the only flight up by Red Bluff; plus any flight with too many cells
+	// to index, as it could be anywhere
+	grs := fdb.GeoRestrictorSet{R: []geo.Restrictor{
+		geo.SquareBoxRestriction{NamedLatlong:geo.NamedLatlong{Latlong:geo.Latlong{Lat:40.07, Long:-123.06}}, SideKM:2},
+	}}
+	s,_ := time.Parse(time.RFC3339, "2017-03-31T00:00:00Z")
+	restricted := func() []string {
+		callsigns := []string{}
+		pi := db.NewRestrictedParallelIterator(fgae.QueryForTimeRange([]string{"FOIA"}, s, s.Add(48*time.Hour)), grs, s, s.Add(48*time.Hour), 2)
+		for f := range pi.Flights() { callsigns = append(callsigns, f.Callsign) }
+		if pi.Err() != nil { t.Fatal(pi.Err()) }
+		return callsigns
+	}
+	if cs := restricted(); len(cs) != 1 || cs[0] != "GGG1234" {
+		t.Errorf("restricted: saw %v", cs)
+	}
+
+	defer func(n int) { fdb.KMaxIndexCells = n }(fdb.KMaxIndexCells)
+	fdb.KMaxIndexCells = 1
+	if err := db.PersistFlight(flights[0]); err != nil { t.Fatal(err) }
+	if cs := restricted(); len(cs) != 2 {
+		t.Errorf("restricted, with an overflowing flight: saw %v", cs)
+	}
+
+	// Bailing out early isn't an error
+	pi := db.NewParallelIterator(db.NewQuery(), 2)
+	<-pi.Flights()
+	pi.Cancel()
+	for range pi.Flights() {}
+	if pi.Err() != nil {
+		t.Errorf("after Cancel: unexpected err %v", pi.Err())
+	}
+
+	// ... but having the context cancelled from above is
+	pi = db.NewParallelIterator(db.NewQuery(), 2)
+	<-pi.Flights()
+	cancel()
+	for range pi.Flights() {}
+	if pi.Err() == nil {
+		t.Errorf("after context cancellation: expected an err")
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	ctx := context.Background()
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(ctx, p)
+
+	count := func(kind string) int {
+		keyers,err := p.GetAll(ctx, ds.NewQuery(kind).KeysOnly(), nil)
+		if err != nil { t.Fatal(err) }
+		return len(keyers)
+	}
+	reload := func(keyer ds.Keyer) *fdb.Flight {
+		f,err := db.LookupKey(keyer)
+		if err != nil { t.Fatal(err) }
+		return f
+	}
+
+	// A big flight, so the revision will need chunking
+	junk := make([]byte, 2*fdb.KMaxBlobChunkSize)
+	rand.New(rand.NewSource(1)).Read(junk)
+	f := loadFlights(t, db, fakeFlights)[0]
+	f.DebugLog = base64.StdEncoding.EncodeToString(junk)
+	if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	results,err := db.LookupAll(db.NewQuery().ByCallsign(f.Callsign))
+	if err != nil || len(results) != 1 { t.Fatalf("lookup: %v, %d", err, len(results)) }
+	keyer,_ := db.Backend.DecodeKey(results[0].GetDatastoreKey())
+
+	// Without KeepRevisions, nothing is kept
+	if err := db.PersistFlight(reload(keyer)); err != nil { t.Fatal(err) }
+	if n := count("flightrevision"); n != 0 {
+		t.Errorf("expected no revisions, saw %d", n)
+	}
+
+	db.KeepRevisions = true
+	f2 := reload(keyer)
+	f2.DebugLog = ""
+	f2.SetTag("EDITED")
+	if err := db.PersistFlightWithReason(f2, "shrink"); err != nil { t.Fatal(err) }
+
+	// The flight's own chunks should have gone, but the revision's should be there
+	revs,err := db.ListRevisions(keyer)
+	if err != nil { t.Fatal(err) }
+	if len(revs) != 1 || revs[0].Reason != "shrink" {
+		t.Fatalf("expected one revision for 'shrink', saw %v", revs)
+	}
+	if n := count("flightchunk"); n != 3 {
+		t.Errorf("expected 3 chunks (all the revision's), saw %d", n)
+	}
+
+	revKeyer,_ := db.Backend.DecodeKey(revs[0].Key)
+	old,err := db.LookupRevision(revKeyer)
+	if err != nil { t.Fatal(err) }
+	if old.DebugLog != f.DebugLog {
+		t.Errorf("revision contents differ from the original")
+	}
+
+	diff := fdb.DiffFlights(old, reload(keyer))
+	if len(diff.TagsAdded) != 1 || diff.TagsAdded[0] != "EDITED" {
+		t.Errorf("diff: expected EDITED to be added, saw\n%s", diff)
+	}
+
+	// Roll back; the version we replaced should become a revision too
+	db.KeepRevisions = false
+	if err := db.RollbackToRevision(revKeyer); err != nil { t.Fatal(err) }
+	if f3 := reload(keyer); f3.DebugLog != f.DebugLog || f3.HasTag("EDITED") {
+		t.Errorf("rollback didn't restore the original")
+	}
+	if revs,_ := db.ListRevisions(keyer); len(revs) != 2 {
+		t.Errorf("expected 2 revisions after rollback, saw %d", len(revs))
+	}
+
+	// Old revisions get trimmed
+	db.KeepRevisions = true
+	defer func(n int) { fgae.KMaxFlightRevisions = n }(fgae.KMaxFlightRevisions)
+	fgae.KMaxFlightRevisions = 3
+	for i:=0; i<3; i++ {
+		if err := db.PersistFlightWithReason(reload(keyer), fmt.Sprintf("again %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if revs,_ := db.ListRevisions(keyer); len(revs) != 3 || revs[2].Reason != "again 2" {
+		t.Errorf("expected 3 revisions, ending with 'again 2'; saw %v", revs)
+	}
+
+	// Deleting the flight takes out its revisions, and their chunks
+	if err := db.DeleteByKey(keyer); err != nil { t.Fatal(err) }
+	if n := count("flightrevision"); n != 0 {
+		t.Errorf("after deleting, expected 0 revisions, saw %d", n)
+	}
+	if n := count("flightchunk"); n != 0 {
+		t.Errorf("after deleting, expected 0 chunks, saw %d", n)
+	}
+}
+
+func TestArchiveFallback(t *testing.T) {
+	ctx := context.Background()
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(ctx, p)
+
+	flights := loadFlights(t, db, fakeFlights)
+	for _,f := range flights {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	}
+	keyers,err := db.LookupAllKeys(db.NewQuery())
+	if err != nil { t.Fatal(err) }
+
+	// Archive everything into per-day files, the way cmd/fdb does, then delete it all
+	days := map[string][]fdb.IndexedFlightBlob{}
+	dayKeys := map[string][]string{}
+	for _,keyer := range keyers {
+		blob,err := db.LookupBlob(keyer)
+		if err != nil { t.Fatal(err) }
+		f,_ := blob.ToFlight("")
+		name := fgae.ArchiveFilename(date.AtLocalMidnight(date.InPdt(f.StartTime())))
+		days[name] = append(days[name], *blob)
+		dayKeys[name] = append(dayKeys[name], keyer.Encode())
+	}
+	dir := t.TempDir()
+	for name,blobs := range days {
+		var buf bytes.Buffer
+		if err := fdb.MarshalArchive(blobs, dayKeys[name], &buf); err != nil { t.Fatal(err) }
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil { t.Fatal(err) }
+	}
+
+	s,_ := time.Parse(time.RFC3339, "2017-04-01T00:00:00Z")
+	run := func(expected int, q *fgae.FQuery) []*fdb.Flight {
+		results,err := db.LookupAll(q)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(results) != expected {
+			t.Errorf("expected %d results, saw %d; query: %s", expected, len(results), (*ds.Query)(q))
+		}
+		return results
+	}
+
+	// While the flights are still in datastore, the archives shouldn't add duplicates
+	db.Archive = fgae.LocalArchiveStore{Dir:dir}
+	run(2, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)))
+
+	if err := db.DeleteAllKeys(keyers); err != nil { t.Fatal(err) }
+
+	db.Archive = nil
+	run(0, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)))
+
+	db.Archive = fgae.LocalArchiveStore{Dir:dir}
+	results := run(2, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)))
+	for _,f := range results {
+		if _,err := db.Backend.DecodeKey(f.GetDatastoreKey()); err != nil {
+			t.Errorf("archived flight %s has bad key: %v", f, err)
+		}
+	}
+	run(1, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)).ByCallsign("III1234").Limit(5))
+	run(1, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)).Limit(1))
+	run(0, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)).ByTags([]string{"NOSUCHTAG"}))
+
+	// No time range, so the archives aren't consulted
+	run(0, db.NewQuery().ByCallsign(flights[0].Callsign))
+
+	// Nor are they for days that should still be in datastore
+	defer func(d time.Duration) { fgae.KDatastoreRetention = d }(fgae.KDatastoreRetention)
+	fgae.KDatastoreRetention = time.Since(s) + 48*time.Hour
+	run(0, db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)))
+}
+
+// Days archived in the original format (a plain gob of blobs, with no keys) should still be found.
+func TestLegacyArchiveFallback(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	flights := loadFlights(t, db, fakeFlights)
+	for _,f := range flights {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	}
+	keyers,err := db.LookupAllKeys(db.NewQuery())
+	if err != nil { t.Fatal(err) }
+
+	days := map[string][]fdb.IndexedFlightBlob{}
+	keys := map[string]bool{}
+	for _,keyer := range keyers {
+		blob,err := db.LookupBlob(keyer)
+		if err != nil { t.Fatal(err) }
+		f,_ := blob.ToFlight("")
+		name := fgae.LegacyArchiveFilename(date.AtLocalMidnight(date.InPdt(f.StartTime())))
+		days[name] = append(days[name], *blob)
+		keys[keyer.Encode()] = true
+	}
+	dir := t.TempDir()
+	for name,blobs := range days {
+		var buf bytes.Buffer
+		if err := fdb.MarshalBlobSlice(blobs, &buf); err != nil { t.Fatal(err) }
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil { t.Fatal(err) }
+	}
+	if err := db.DeleteAllKeys(keyers); err != nil { t.Fatal(err) }
+
+	db.Archive = fgae.LocalArchiveStore{Dir:dir}
+	s,_ := time.Parse(time.RFC3339, "2017-04-01T00:00:00Z")
+	results,err := db.LookupAll(db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)))
+	if err != nil { t.Fatal(err) } else if len(results) != 2 {
+		t.Errorf("expected 2 results, saw %d", len(results))
+	}
+	for _,f := range results {
+		if !keys[f.GetDatastoreKey()] {
+			t.Errorf("archived flight %s didn't get its original key back", f)
+		}
+	}
+
+	results,err = db.LookupAll(db.NewQuery().ByTimeRange(s, s.Add(90*time.Minute)).ByCallsign("III1234"))
+	if err != nil { t.Fatal(err) } else if len(results) != 1 {
+		t.Errorf("expected 1 result, saw %d", len(results))
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	flights := loadFlights(t, db, fakeFlights)
+	for _,f := range flights {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	}
+
+	// A second copy of the first flight, missing its first point (so it gets its own key)
+	dupe := loadFlights(t, db, fakeFlights)[0]
+	for _,track := range dupe.Tracks { *track = (*track)[1:] }
+	dupe.SetTag("DUPE")
+	if err := db.PersistFlight(dupe); err != nil { t.Fatal(err) }
+
+	count := func() int {
+		keyers,err := db.LookupAllKeys(db.NewQuery())
+		if err != nil { t.Fatal(err) }
+		return len(keyers)
+	}
+	if count() != len(flights)+1 { t.Fatalf("dupe was not persisted") }
+
+	s,_ := time.Parse(time.RFC3339, "2017-04-01T00:00:00Z")
+	merges,err := db.MergeDuplicates(s, s.Add(24*time.Hour), true)
+	if err != nil { t.Fatal(err) }
+	if len(merges) != 1 || len(merges[0].Losers) != 1 {
+		t.Fatalf("expected one merge of one flight, saw %v", merges)
+	}
+	if count() != len(flights)+1 { t.Errorf("dry run deleted flights") }
+
+	db.KeepRevisions = true
+	merges,err = db.MergeDuplicates(s, s.Add(24*time.Hour), false)
+	if err != nil { t.Fatal(err) }
+	if len(merges) != 1 { t.Fatalf("expected one merge, saw %d", len(merges)) }
+	if count() != len(flights) { t.Errorf("expected %d flights after merge, saw %d", len(flights), count()) }
+
+	// The deleted flight can be brought back from its revision
+	loserKeyer,_ := db.Backend.DecodeKey(merges[0].Losers[0])
+	revs,err := db.ListRevisions(loserKeyer)
+	if err != nil { t.Fatal(err) }
+	if len(revs) != 1 || revs[0].Reason != "dedupe into "+merges[0].Winner {
+		t.Fatalf("expected one revision for the deleted flight, saw %v", revs)
+	}
+	revKeyer,_ := db.Backend.DecodeKey(revs[0].Key)
+	if loser,err := db.LookupRevision(revKeyer); err != nil {
+		t.Fatal(err)
+	} else if !loser.HasTag("DUPE") {
+		t.Errorf("revision of deleted flight is wrong: %v", loser.TagList())
+	}
+	db.KeepRevisions = false
+
+	keyer,_ := db.Backend.DecodeKey(merges[0].Winner)
+	f,err := db.LookupKey(keyer)
+	if err != nil { t.Fatal(err) }
+	if !f.HasTag("DUPE") || len(f.AnyTrack()) != len(flights[0].AnyTrack()) {
+		t.Errorf("merged flight is wrong: %v, %d points", f.TagList(), len(f.AnyTrack()))
+	}
+
+	if merges,_ := db.MergeDuplicates(s, s.Add(24*time.Hour), false); len(merges) != 0 {
+		t.Errorf("found duplicates after merging: %v", merges)
+	}
+}
+
+func TestOverlayAirframes(t *testing.T) {
+	ctx := context.Background()
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(ctx, p)
+
+	defer func(d time.Duration) { fgae.KAirframeCacheRefresh = d }(fgae.KAirframeCacheRefresh)
+	fgae.KAirframeCacheRefresh = 0
+
+	setCache := func(reg string) {
+		ac := ref.BlankAirframeCache()
+		ac.Set(&fdb.Airframe{Icao24:"A12345", Registration:reg, EquipmentType:"B738"})
+		if err := ac.SaveAirframeCache(ctx, db.SingletonProvider); err != nil { t.Fatal(err) }
+	}
+	setCache("N12345")
+
+	f := loadFlights(t, db, fakeFlights)[0]
+	f.IcaoId,f.EquipmentType = "A12345","B739"
+	if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	q := func() *fgae.FQuery { return db.NewQuery().ByCallsign(f.Callsign) } // iterators modify queries
+
+	check := func(what string, f *fdb.Flight, expectReg string) {
+		if f == nil { t.Fatalf("%s: no flight", what) }
+		if f.Registration != expectReg {
+			t.Errorf("%s: expected registration %q, saw %q", what, expectReg, f.Registration)
+		}
+		if f.EquipmentType != "B739" {
+			t.Errorf("%s: stored equipment type was overwritten with %q", what, f.EquipmentType)
+		}
+		fromCache := f.AirframeFieldsFromOverlay()
+		if expectReg != "" && (len(fromCache) != 1 || fromCache[0] != "Registration") {
+			t.Errorf("%s: expected just Registration from the overlay, saw %v", what, fromCache)
+		}
+	}
+
+	first,err := db.LookupFirst(q())
+	if err != nil { t.Fatal(err) }
+	check("no overlay", first, "")
+
+	db.OverlayAirframes = true
+	first,err = db.LookupFirst(q())
+	if err != nil { t.Fatal(err) }
+	check("LookupFirst", first, "N12345")
+
+	keyer,_ := db.Backend.DecodeKey(first.GetDatastoreKey())
+	byKey,err := db.LookupKey(keyer)
+	if err != nil { t.Fatal(err) }
+	check("LookupKey", byKey, "N12345")
+
+	fi := db.NewIterator(q())
+	for fi.Iterate(ctx) {
+		check("Iterator", fi.Flight(), "N12345")
+	}
+	if fi.Err() != nil { t.Fatal(fi.Err()) }
+
+	pi := db.NewParallelIterator(q(), 2)
+	for f := range pi.Flights() {
+		check("ParallelIterator", f, "N12345")
+	}
+	if pi.Err() != nil { t.Fatal(pi.Err()) }
+
+	// Changes to the singleton are only seen once the in-process copy is refreshed
+	fgae.KAirframeCacheRefresh = time.Hour
+	setCache("N99999")
+	first,err = db.LookupFirst(q())
+	if err != nil { t.Fatal(err) }
+	check("before refresh", first, "N12345")
+	fgae.KAirframeCacheRefresh = 0
+	first,err = db.LookupFirst(q())
+	if err != nil { t.Fatal(err) }
+	check("after refresh", first, "N99999")
+}
+
+func TestScheduleHistory(t *testing.T) {
+	ctx := context.Background()
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(ctx, p)
+
+	t0,_ := time.Parse(time.RFC3339, "2017-04-01T10:00:00Z")
+	snap := func(callsign, orig, dest string, number int64) *fdb.FlightSnapshot {
+		fs := fdb.FlightSnapshot{}
+		fs.IcaoId,fs.Callsign = "A12345",callsign
+		fs.Origin,fs.Destination,fs.Number = orig,dest,number
+		return &fs
+	}
+
+	// Four polls, a minute apart: one schedule, then another, then the airframe disappears
+	polls := []*fdb.FlightSnapshot{
+		snap("UAL1", "SFO", "LAX", 1),
+		snap("UAL1", "SFO", "LAX", 1),
+		snap("UAL2", "LAX", "SFO", 2),
+		nil,
+	}
+	prev := ref.BlankScheduleCache()
+	for i,fs := range polls {
+		curr := ref.BlankScheduleCache()
+		curr.LastUpdated = t0.Add(time.Duration(i) * time.Minute)
+		if fs != nil { curr.Map[fs.IcaoId] = fs }
+		curr.CarrySince(&prev)
+		if err := db.RecordScheduleHistory(&prev, &curr); err != nil { t.Fatal(err) }
+		prev = curr
+	}
+
+	// Two intervals, each written when it started and again when it ended
+	if keyers,err := p.GetAll(ctx, ds.NewQuery("scheduleinterval").KeysOnly(), nil); err != nil {
+		t.Fatal(err)
+	} else if len(keyers) != 2 {
+		t.Errorf("expected 2 intervals, saw %d", len(keyers))
+	}
+
+	for _,tc := range []struct{
+		d        time.Duration
+		callsign string
+	}{
+		{-time.Minute, ""},
+		{30*time.Second, "UAL1"},
+		{time.Minute, "UAL1"},
+		{150*time.Second, "UAL2"},
+		{10*time.Minute, ""},
+	} {
+		si,err := db.LookupScheduleAt("A12345", t0.Add(tc.d))
+		if err != nil { t.Fatal(err) }
+		if (si == nil && tc.callsign != "") || (si != nil && si.Callsign != tc.callsign) {
+			t.Errorf("at t0+%s, expected %q, saw %v", tc.d, tc.callsign, si)
+		}
+	}
+
+	// A flight that landed a while back gets its schedule filled in
+	f := fdb.BlankFlight()
+	f.IcaoId = "A12345"
+	if changed,err := db.MaybeAddScheduleAt(&f, t0.Add(30*time.Second), nil); err != nil {
+		t.Fatal(err)
+	} else if !changed || f.Origin != "SFO" || f.Callsign != "UAL1" {
+		t.Errorf("schedule not added: %v, %s", changed, f.FullString())
+	}
+
+	// So does one built from fragments, without a live schedule cache
+	tp := func(d time.Duration, lat float64) fdb.Trackpoint {
+		return fdb.Trackpoint{DataSource:"ADSB", TimestampUTC:t0.Add(d), Latlong:geo.Latlong{Lat:lat, Long:-122.3}}
+	}
+	frag := fdb.TrackFragment{
+		IcaoId: "A12345",
+		Track: fdb.Track{tp(130*time.Second, 37.6), tp(135*time.Second, 37.61)},
+		DataSystem: fdb.DSADSB,
+	}
+	if err := db.AddTrackFragment(&frag, nil, nil, map[string]time.Time{}); err != nil { t.Fatal(err) }
+	if f,err := db.LookupFirst(db.NewQuery().ByIcaoId("A12345")); err != nil || f == nil {
+		t.Fatalf("frag lookup: %v, %v", err, f)
+	} else if f.Destination != "SFO" || f.Callsign != "UAL2" {
+		t.Errorf("frag did not pick up schedule: %s", f.FullString())
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	sink := &fgae.InProcessSink{}
+	events := sink.Subscribe(100)
+	db.Changes = sink
+
+	next := func() fgae.ChangeEvent {
+		select {
+		case ev := <-events: return ev
+		default: t.Fatalf("expected a change event, saw none"); return fgae.ChangeEvent{}
+		}
+	}
+
+	has := func(tags []string, tag string) bool {
+		for _,t := range tags { if t == tag { return true } }
+		return false
+	}
+
+	f := loadFlights(t, db, fakeFlights)[0]
+	f.SetTag("FOO")
+	if err := db.PersistFlightWithReason(f, "first"); err != nil { t.Fatal(err) }
+	ev := next()
+	if ev.Type != fgae.ChangeCreated || ev.Reason != "first" || ev.IdSpec != f.IdSpecString() {
+		t.Errorf("bad create event: %s", ev)
+	} else if !has(ev.TagsAdded, "FOO") || len(ev.TagsAdded) != len(f.IndexTagList()) || len(ev.TagsRemoved) != 0 {
+		t.Errorf("bad create tags: %s", ev)
+	}
+
+	keyer,_ := db.Backend.DecodeKey(ev.Key)
+	f,err := db.LookupKey(keyer)
+	if err != nil { t.Fatal(err) }
+	f.DropTag("FOO")
+	f.SetTag("BAR")
+	if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	ev = next()
+	if ev.Type != fgae.ChangeUpdated || ev.Key != keyer.Encode() {
+		t.Errorf("bad update event: %s", ev)
+	} else if strings.Join(ev.TagsAdded, ",") != "BAR" || strings.Join(ev.TagsRemoved, ",") != "FOO" {
+		t.Errorf("bad update tags: %s", ev)
+	}
+
+	// Changes in a failed transaction are never published; those in a good one are
+	bail := fmt.Errorf("bail")
+	if err := db.RunInTransaction(func(txdb fgae.FlightDB) error {
+		f.SetTag("BAZ")
+		if err := txdb.PersistFlight(f); err != nil { return err }
+		return bail
+	}); err != bail {
+		t.Fatalf("expected bail, saw %v", err)
+	}
+	if len(events) != 0 { t.Errorf("failed transaction published: %s", next()) }
+
+	if err := db.RunInTransaction(func(txdb fgae.FlightDB) error {
+		return txdb.PersistFlight(f)
+	}); err != nil { t.Fatal(err) }
+	if ev = next(); ev.Type != fgae.ChangeUpdated || strings.Join(ev.TagsAdded, ",") != "BAZ" {
+		t.Errorf("bad transaction event: %s", ev)
+	}
+
+	// Deletes go to a file sink as well
+	path := filepath.Join(t.TempDir(), "changes.json")
+	fileSink,err := fgae.NewChangeSink("file:" + path)
+	if err != nil { t.Fatal(err) }
+	if err := db.DeleteByKey(keyer); err != nil { t.Fatal(err) }
+	if ev = next(); ev.Type != fgae.ChangeDeleted || !has(ev.TagsRemoved, "BAZ") || ev.IdSpec == "" {
+		t.Errorf("bad delete event: %s", ev)
+	}
+	if err := fileSink.Publish(db.Ctx(), []fgae.ChangeEvent{ev, ev}); err != nil { t.Fatal(err) }
+	if contents,err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if n := strings.Count(string(contents), "\n"); n != 2 {
+		t.Errorf("file sink wrote %d lines, expected 2", n)
+	}
+
+	// Webhooks want a 2xx
+	posted := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		evs := []fgae.ChangeEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&evs); err != nil || len(evs) != 1 {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		posted++
+	}))
+	defer srv.Close()
+	hook := &fgae.WebhookSink{URL: srv.URL}
+	if err := hook.Publish(db.Ctx(), []fgae.ChangeEvent{ev}); err != nil { t.Fatal(err) }
+	hook.Flush()
+	if posted != 1 || hook.Failed != 0 {
+		t.Errorf("webhook: %v (posted=%d)", hook.LastErr, posted)
+	}
+	if err := hook.Publish(db.Ctx(), []fgae.ChangeEvent{ev, ev}); err != nil { t.Fatal(err) }
+	hook.Flush()
+	if hook.Failed != 1 || hook.LastErr == nil {
+		t.Errorf("webhook: expected a failure for a 400")
+	}
+
+	// A stuck endpoint doesn't hold up publishing; once the queue is full, batches get dropped
+	defer func(n int) { fgae.KWebhookQueueLen = n }(fgae.KWebhookQueueLen)
+	fgae.KWebhookQueueLen = 1
+	stuck := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-stuck }))
+	defer slow.Close()
+	hook = &fgae.WebhookSink{URL: slow.URL}
+	errs := 0
+	for i:=0; i<5; i++ {
+		if err := hook.Publish(db.Ctx(), []fgae.ChangeEvent{ev}); err != nil { errs++ }
+	}
+	close(stuck)
+	hook.Flush()
+	if errs < 3 || hook.Dropped != errs {
+		t.Errorf("webhook queue: %d errors, %d dropped", errs, hook.Dropped)
+	}
+}
+
+func TestCondensedRollups(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	flights := loadFlights(t, db, fakeFlights)
+	flights[0].SetTag("FOO")
+	for _,f := range flights {
+		if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	}
+
+	// Spans three PDT days, as the fake flights straddle a PDT midnight
+	s,_ := time.Parse(time.RFC3339, "2017-03-31T00:00:00Z")
+	e := s.Add(48*time.Hour)
+	fetch := func(s,e time.Time, tags ...string) []fdb.CondensedFlight {
+		cfs,err,str := db.FetchCondensedFlights(s, e, tags)
+		if err != nil { t.Fatalf("%v\n%s", err, str) }
+		return cfs
+	}
+
+	cfs := fetch(s, e)
+	if len(cfs) != len(flights) {
+		t.Fatalf("expected %d condensed flights, saw %d", len(flights), len(cfs))
+	}
+	for i:=1; i<len(cfs); i++ {
+		if cfs[i].Start.Before(cfs[i-1].Start) { t.Errorf("results out of order: %v", cfs) }
+	}
+
+	if cfs := fetch(s, e, "FOO"); len(cfs) != 1 || cfs[0].IdSpec != flights[0].IdSpec().String() {
+		t.Errorf("tag filter: saw %v", cfs)
+	}
+
+	// Only III1234 (00:39Z) is before 01:00Z
+	s2,_ := time.Parse(time.RFC3339, "2017-04-01T01:00:00Z")
+	if cfs := fetch(s, s2); len(cfs) != 1 || cfs[0].BestFlightNumber != flights[len(flights)-1].BestFlightNumber() {
+		t.Errorf("time filter: saw %v", cfs)
+	}
+
+	// Updates and deletes show up straight away
+	f,err := db.LookupFirst(db.NewQuery().ByCallsign("III1234"))
+	if err != nil || f == nil { t.Fatalf("lookup: %v, %v", err, f) }
+	f.SetTag("FOO")
+	if err := db.PersistFlight(f); err != nil { t.Fatal(err) }
+	if cfs := fetch(s, e, "FOO"); len(cfs) != 2 { t.Errorf("after update, saw %d FOOs", len(cfs)) }
+
+	// Inside a transaction, rollups are only updated once it commits; and they don't nest
+	bail := fmt.Errorf("bail")
+	f.SetTag("BAR")
+	if err := db.RunInTransaction(func(txdb fgae.FlightDB) error {
+		if err := txdb.PersistFlight(f); err != nil { return err }
+		return bail
+	}); err != bail {
+		t.Fatalf("expected bail, saw %v", err)
+	}
+	if cfs := fetch(s, e, "BAR"); len(cfs) != 0 { t.Errorf("rolled back update got rolled up") }
+	if err := db.RunInTransaction(func(txdb fgae.FlightDB) error {
+		if err := txdb.PersistFlight(f); err != nil { return err }
+		if cfs := fetch(s, e, "BAR"); len(cfs) != 0 { t.Errorf("rollup updated before commit") }
+		return nil
+	}); err != nil { t.Fatal(err) }
+	if cfs := fetch(s, e, "BAR"); len(cfs) != 1 { t.Errorf("after transaction, saw %d BARs", len(cfs)) }
+
+	err = db.RunInTransaction(func(txdb fgae.FlightDB) error {
+		return txdb.RunInTransaction(func(fgae.FlightDB) error { return nil })
+	})
+	if !errors.Is(err, fgae.ErrNestedTransaction) { t.Errorf("nested transaction: %v", err) }
+	err = p.RunInTransaction(db.Ctx(), func(tx ds.DatastoreProvider) error {
+		return tx.(fgae.Transactor).RunInTransaction(db.Ctx(), func(ds.DatastoreProvider) error { return nil })
+	})
+	if !errors.Is(err, fgae.ErrNestedTransaction) { t.Errorf("nested localtx: %v", err) }
+
+	keyer,_ := db.Backend.DecodeKey(f.GetDatastoreKey())
+	if err := db.DeleteByKey(keyer); err != nil { t.Fatal(err) }
+	if cfs := fetch(s, e); len(cfs) != len(flights)-1 { t.Errorf("after delete, saw %d", len(cfs)) }
+
+	if n,err := db.RebuildCondensedRollups(s, e); err != nil || n != len(flights)-1 {
+		t.Errorf("rebuild: n=%d, err=%v", n, err)
+	}
+	if cfs := fetch(s, e); len(cfs) != len(flights)-1 { t.Errorf("after rebuild, saw %d", len(cfs)) }
+
+	// Days with no rollups fall back to loading the flights
+	keyers,err := p.GetAll(db.Ctx(), ds.NewQuery("condensedrollup").KeysOnly(), nil)
+	if err != nil || len(keyers) == 0 { t.Fatalf("rollup keys: %v, %d", err, len(keyers)) }
+	if len(keyers) > 3*fgae.KCondensedRollupShards { t.Errorf("too many rollups: %d", len(keyers)) }
+	if err := p.DeleteMulti(db.Ctx(), keyers); err != nil { t.Fatal(err) }
+	if cfs := fetch(s, e); len(cfs) != len(flights)-1 { t.Errorf("without rollups, saw %d", len(cfs)) }
+	if cfs := fetch(s, e, "FOO"); len(cfs) != 1 { t.Errorf("without rollups, saw %d FOOs", len(cfs)) }
+}
+
+func TestRestrictorSetSharing(t *testing.T) {
+	p,_ := fgae.NewLocalDSProvider("")
+	db := fgae.New(context.Background(), p)
+
+	box := func(km float64) geo.Restrictor {
+		return geo.SquareBoxRestriction{Debugger:new(geo.DebugLog), SideKM:km,
+			NamedLatlong:geo.NamedLatlong{Latlong:geo.Latlong{Lat:37.5, Long:-122.0}}}
+	}
+
+	for _,grs := range []fdb.GeoRestrictorSet{
+		{Name:"mine", User:"alice@example.com", R:[]geo.Restrictor{box(1)}},
+		{Name:"shared", User:"alice@example.com", SharedWith:[]string{"Bob@Example.com"}},
+		{Name:"public", User:"carol@example.com", Public:true},
+	} {
+		if err := db.PersistRestrictorSet(grs); err != nil { t.Fatal(err) }
+	}
+
+	names := func(rsets []fdb.GeoRestrictorSet, err error) string {
+		if err != nil { t.Fatal(err) }
+		strs := []string{}
+		for _,grs := range rsets { strs = append(strs, grs.Name) }
+		return strings.Join(strs, ",")
+	}
+
+	if got := names(db.LookupSharedRestrictorSets("bob@example.com")); got != "public,shared" {
+		t.Errorf("bob sees %q", got)
+	}
+	if got := names(db.LookupSharedRestrictorSets("alice@example.com")); got != "public" {
+		t.Errorf("alice sees %q", got) // Not her own sets
+	}
+
+	// Edits keep the old versions, and can be undone
+	rsets,_ := db.LookupRestrictorSets("alice@example.com")
+	grs := rsets[0]
+	if grs.Name != "mine" { grs = rsets[1] }
+	if grs.Version != 1 { t.Errorf("new set has version %d", grs.Version) }
+	for _,km := range []float64{2, 3} {
+		grs.R = []geo.Restrictor{box(km)}
+		if err := db.PersistRestrictorSet(grs); err != nil { t.Fatal(err) }
+	}
+
+	versions,err := db.LookupRestrictorSetVersions(grs.DSKey)
+	if err != nil { t.Fatal(err) }
+	if len(versions) != 2 || versions[0].Version != 2 || versions[1].Version != 1 {
+		t.Fatalf("expected versions 2,1; saw %v", versions)
+	}
+	if !strings.Contains(versions[1].R[0].String(), "@1.0KM") {
+		t.Errorf("version 1 is wrong: %s", versions[1])
+	}
+	if got := names(db.LookupRestrictorSets("alice@example.com")); got != "mine,shared" && got != "shared,mine" {
+		t.Errorf("versions leaked into the user's sets: %q", got)
+	}
+
+	if err := db.RestoreRestrictorSetVersion(grs.DSKey, 1); err != nil { t.Fatal(err) }
+	if curr,err := db.LoadRestrictorSet(grs.DSKey); err != nil {
+		t.Fatal(err)
+	} else if curr.Version != 4 || !strings.Contains(curr.R[0].String(), "@1.0KM") {
+		t.Errorf("restore went wrong: v%d %s", curr.Version, curr)
+	}
+
+	if err := db.DeleteRestrictorSet(grs.DSKey); err != nil { t.Fatal(err) }
+	if versions,err := db.LookupRestrictorSetVersions(grs.DSKey); err != nil || len(versions) != 0 {
+		t.Errorf("versions survived delete: %v, %v", versions, err)
+	}
 }
 
 var (
@@ -164,8 +1088,6 @@ III1234,20170401607,20170401NCT9111III1234,NCT,9234,SFO,HYA,CRJ3,37.7868,-122.27
 	// }}}
 )
 
-*/
-
 // {{{ -------------------------={ E N D }=----------------------------------
 
 // Local variables:
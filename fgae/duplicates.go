@@ -0,0 +1,101 @@
+package fgae
+
+import(
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+)
+
+// DuplicateMerge records how a set of duplicate flights was (or, in a dry run, would be) merged.
+type DuplicateMerge struct {
+	Winner     string      // Key of the flight that the others were merged into
+	Losers   []string      // Keys of the flights that were merged, and then deleted (but see
+	                       // MergeDuplicates for their revisions)
+	Flight    *fdb.Flight  // The merged flight
+	Log        string
+}
+
+func (dm DuplicateMerge)String() string {
+	str := fmt.Sprintf("* merged %d into %s [%s]\n", len(dm.Losers), dm.Winner, dm.Flight.IdentityString())
+	for _,k := range dm.Losers {
+		str += fmt.Sprintf("  - %s\n", k)
+	}
+	return str + dm.Log
+}
+
+// {{{ db.MergeDuplicates
+
+// MergeDuplicates finds the flights in the time range that are duplicates of each other (see
+// fdb.GroupDuplicates), merges each group into the flight with the most trackpoints, and deletes
+// the rest. If dryrun is set, nothing is written, but the merges that would have happened are
+// still returned.
+//
+// If db.KeepRevisions is set, the deleted flights are kept as revisions (under their own keys),
+// so a bad merge can be undone with ListRevisions and RollbackToRevision.
+func (db *FlightDB)MergeDuplicates(s,e time.Time, dryrun bool) ([]DuplicateMerge, error) {
+	flights,err := db.LookupAll(QueryForTimeRange(nil, s, e))
+	if err != nil { return nil, fmt.Errorf("MergeDuplicates: %v", err) }
+
+	merges := []DuplicateMerge{}
+	for _,group := range fdb.GroupDuplicates(flights) {
+		sort.Slice(group, func(i,j int) bool {
+			ni,nj := numTrackpoints(group[i]),numTrackpoints(group[j])
+			if ni != nj { return ni > nj }
+			return group[i].GetDatastoreKey() < group[j].GetDatastoreKey()
+		})
+
+		winner := group[0]
+		dm := DuplicateMerge{Winner: winner.GetDatastoreKey(), Flight: winner}
+		for _,loser := range group[1:] {
+			_,why := winner.IsDuplicateOf(loser)
+			dm.Log += fmt.Sprintf("  - %s [%s]: %s\n", loser.GetDatastoreKey(), loser.IdentityString(), why)
+			winner.MergeFrom(loser)
+			dm.Losers = append(dm.Losers, loser.GetDatastoreKey())
+		}
+		winner.Analyse()
+
+		if !dryrun {
+			if err := db.PersistFlightWithReason(winner, "dedupe"); err != nil {
+				return merges, fmt.Errorf("MergeDuplicates: %v", err)
+			}
+			for _,k := range dm.Losers {
+				keyer,err := db.Backend.DecodeKey(k)
+				if err != nil { return merges, fmt.Errorf("MergeDuplicates: %v", err) }
+
+				if db.KeepRevisions {
+					if err := db.saveRevision(keyer, "dedupe into "+dm.Winner); err != nil {
+						return merges, fmt.Errorf("MergeDuplicates: %v", err)
+					}
+				}
+				if err := db.deleteAllKeys([]ds.Keyer{keyer}, db.KeepRevisions); err != nil {
+					return merges, fmt.Errorf("MergeDuplicates: %v", err)
+				}
+			}
+		}
+
+		merges = append(merges, dm)
+	}
+
+	return merges, nil
+}
+
+func numTrackpoints(f *fdb.Flight) int {
+	n := 0
+	for _,t := range f.Tracks {
+		if t != nil { n += len(*t) }
+	}
+	return n
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -10,6 +10,7 @@ import(
 	"context"
 
 	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
 	sprovider "github.com/skypies/util/gcp/singleton"
 	"github.com/skypies/util/singleton"
 )
@@ -19,6 +20,22 @@ type FlightDB struct {
 	StartTime         time.Time
 	Backend           ds.DatastoreProvider
 	SingletonProvider singleton.SingletonProvider
+
+	// If set, PersistFlight keeps the previous version of the flight as a revision (see revisions.go)
+	KeepRevisions     bool
+
+	// If set, lookups and iterators fill in missing airframe fields from the airframe cache
+	// (see airframes.go). Leave unset when flights will be persisted, to keep cache data out of them.
+	OverlayAirframes  bool
+
+	// If set, LookupAll also looks in the archives for flights deleted from datastore (see archive.go)
+	Archive           ArchiveStore
+
+	// If set, flights that get persisted or deleted are published to it (see changefeed.go)
+	Changes           ChangeSink
+
+	// Set inside RunInTransaction, to hold rollup updates back until commit (see condensed.go)
+	rollups          *rollupBuffer
 }
 
 func New(ctx context.Context, p ds.DatastoreProvider) FlightDB {
@@ -38,8 +55,45 @@ func (db *FlightDB)NewQuery() *FQuery {
 	return NewFlightQuery()
 }
 
+// The iterators carry on without the airframe overlay if the airframe cache can't be loaded,
+// rather than failing the whole iteration.
+
 func (db *FlightDB)NewIterator(fq *FQuery) *FlightIterator {
-	return NewFlightIterator(db.Ctx(), db.Backend, fq)
+	fi := NewFlightIterator(db.Ctx(), db.Backend, fq)
+	if ac,err := db.airframesForOverlay(); err != nil {
+		db.Warningf("NewIterator: airframe cache: %v", err)
+	} else {
+		fi.airframes = ac
+	}
+	return fi
+}
+
+func (db *FlightDB)NewParallelIterator(fq *FQuery, nWorkers int) *ParallelFlightIterator {
+	ac,err := db.airframesForOverlay()
+	if err != nil {
+		db.Warningf("NewParallelIterator: airframe cache: %v", err)
+	}
+	return newParallelFlightIterator(db.Ctx(), db.Backend, fq, nil, nWorkers, ac)
+}
+
+// NewRestrictedParallelIterator is NewParallelIterator, but it uses the cell index to skip the
+// flights in [s,e] that can't satisfy the restrictor set, without fetching them. The cells can't
+// share a composite index with fq's filters (see FQuery.ByRestrictor), so they get a query of
+// their own, and only the flights that both queries match are fetched. Only use it when
+// CellsIndexedFor(s).
+func (db *FlightDB)NewRestrictedParallelIterator(fq *FQuery, grs fdb.GeoRestrictorSet, s,e time.Time, nWorkers int) *ParallelFlightIterator {
+	ac,err := db.airframesForOverlay()
+	if err != nil {
+		db.Warningf("NewRestrictedParallelIterator: airframe cache: %v", err)
+	}
+
+	var within *FQuery
+	if cells := grs.QueryCells(); len(cells) > 0 {
+		// Generous at the end too, as fq's time filters work on whole timeslots
+		within = NewFlightQuery().ByRestrictor(grs).
+			ByStartTimeRange(s.Add(-KMaxFlightDuration), e.Add(fdb.TimeslotDuration))
+	}
+	return newParallelFlightIterator(db.Ctx(), db.Backend, fq, within, nWorkers, ac)
 }
 
 func (db *FlightDB)Ctx() context.Context { return db.ctx }
@@ -4,37 +4,48 @@ import(
 	"context"
 	"github.com/skypies/util/gcp/ds"
 	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/flightdb/ref"
 )
 
-// A shim on the dsprovider iterator that can talk flights
-type FlightIterator ds.Iterator
+// A shim on the dsprovider iterator that can talk flights. It hangs on to the provider, so it
+// can fetch the chunks of any oversize flights.
+type FlightIterator struct {
+	it     *ds.Iterator
+	ctx     context.Context
+	p       ds.DatastoreProvider
+	airframes *ref.AirframeCache // If set, overlaid onto the results
+}
 
 func NewFlightIterator(ctx context.Context, p ds.DatastoreProvider, fq *FQuery) *FlightIterator {
 	it := ds.NewIterator(ctx, p, (*ds.Query)(fq), fdb.IndexedFlightBlob{})
-	return (*FlightIterator)(it)
+	return &FlightIterator{it:it, ctx:ctx, p:p}
 }
 
 func (fi *FlightIterator)Iterate(ctx context.Context) bool {
-	it := (*ds.Iterator)(fi)
-	return it.Iterate(ctx)
+	return fi.it.Iterate(ctx)
 }
 
 func (fi *FlightIterator)Err() error {
-	it := (*ds.Iterator)(fi)
-	return it.Err()
+	return fi.it.Err()
 }
 
 func (fi *FlightIterator)Flight() *fdb.Flight {
 	blob := fdb.IndexedFlightBlob{}
 
-	it := (*ds.Iterator)(fi)
-	keyer := it.Val(&blob)
+	keyer := fi.it.Val(&blob)
+
+	if err := loadChunks(fi.ctx, fi.p, keyer, &blob); err != nil {
+		fi.it.SetErr(err)
+		return nil
+	}
 
 	f, err := blob.ToFlight(keyer.Encode())
 	if err != nil {
-		it.SetErr(err)
+		fi.it.SetErr(err)
 		return nil
 	}
 
+	overlayAirframes(fi.airframes, f)
+
 	return f
 }
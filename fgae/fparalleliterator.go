@@ -0,0 +1,214 @@
+package fgae
+
+// A concurrent alternative to FlightIterator, for when decoding blobs is the bottleneck. One
+// goroutine fetches batches of blobs ahead of the caller, and a pool of workers decodes them;
+// the flights come out on a channel, in no particular order.
+//
+//  pi := db.NewParallelIterator(q, 0)
+//  defer pi.Cancel()           // Stops the workers, if we bail out early
+//  for f := range pi.Flights() {
+//    ...
+//  }
+//  if pi.Err() != nil { ... }
+
+import(
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/flightdb/ref"
+)
+
+// How many blobs get fetched per GetMulti, and how many fetched blobs can be waiting for a worker.
+const(
+	kParallelFetchBatchSize = 50
+	kParallelQueueSize      = 2 * kParallelFetchBatchSize
+)
+
+type ParallelFlightIterator struct {
+	ctx       context.Context
+	parent    context.Context
+	cancel    context.CancelFunc
+	p         ds.DatastoreProvider
+	airframes *ref.AirframeCache   // If set, overlaid onto the results
+
+	out       chan *fdb.Flight
+
+	errOnce   sync.Once
+	err       error
+}
+
+type fetchedBlob struct {
+	keyer ds.Keyer
+	blob  fdb.IndexedFlightBlob
+}
+
+// {{{ NewParallelFlightIterator
+
+// NewParallelFlightIterator starts fetching and decoding straight away, with nWorkers decoders
+// (or one per CPU, if nWorkers isn't positive).
+func NewParallelFlightIterator(ctx context.Context, p ds.DatastoreProvider, fq *FQuery, nWorkers int) *ParallelFlightIterator {
+	return newParallelFlightIterator(ctx, p, fq, nil, nWorkers, nil)
+}
+
+// If within is set, only flights that both queries match are fetched.
+func newParallelFlightIterator(ctx context.Context, p ds.DatastoreProvider, fq, within *FQuery, nWorkers int, ac *ref.AirframeCache) *ParallelFlightIterator {
+	if nWorkers <= 0 { nWorkers = runtime.NumCPU() }
+
+	pi := &ParallelFlightIterator{
+		parent: ctx,
+		p: p,
+		airframes: ac,
+		out: make(chan *fdb.Flight, nWorkers),
+	}
+	pi.ctx,pi.cancel = context.WithCancel(ctx)
+
+	blobs := make(chan fetchedBlob, kParallelQueueSize)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pi.fetch(fq, within, blobs)
+	}()
+	for i:=0; i<nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pi.decode(blobs)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		if pi.parent.Err() != nil && pi.err == nil {
+			pi.err = pi.parent.Err()
+		}
+		pi.cancel() // release the context's resources
+		close(pi.out)
+	}()
+
+	return pi
+}
+
+// }}}
+// {{{ pi.setErr
+
+// Only the first error counts; it also stops everything else. Errors after a cancellation are
+// just fallout from the cancellation, so are ignored.
+func (pi *ParallelFlightIterator)setErr(err error) {
+	if pi.ctx.Err() != nil { return }
+	pi.errOnce.Do(func() {
+		pi.err = err
+		pi.cancel()
+	})
+}
+
+// }}}
+// {{{ pi.fetch
+
+func (pi *ParallelFlightIterator)fetch(fq, within *FQuery, blobs chan<- fetchedBlob) {
+	defer close(blobs)
+
+	keyers,err := pi.keys(fq)
+	if err != nil {
+		pi.setErr(fmt.Errorf("ParallelFlightIterator: %v", err))
+		return
+	}
+
+	if within != nil {
+		withinKeyers,err := pi.keys(within)
+		if err != nil {
+			pi.setErr(fmt.Errorf("ParallelFlightIterator: within: %v", err))
+			return
+		}
+		keep := map[string]bool{}
+		for _,k := range withinKeyers { keep[k.Encode()] = true }
+
+		both := []ds.Keyer{}
+		for _,k := range keyers {
+			if keep[k.Encode()] { both = append(both, k) }
+		}
+		keyers = both
+	}
+
+	for len(keyers) > 0 {
+		n := kParallelFetchBatchSize
+		if n > len(keyers) { n = len(keyers) }
+
+		batch := make([]fdb.IndexedFlightBlob, n)
+		if err := pi.p.GetMulti(pi.ctx, keyers[:n], batch); err != nil {
+			pi.setErr(fmt.Errorf("ParallelFlightIterator: %v", err))
+			return
+		}
+
+		for i := range batch {
+			select {
+			case blobs <- fetchedBlob{keyers[i], batch[i]}:
+			case <-pi.ctx.Done():
+				return
+			}
+		}
+		keyers = keyers[n:]
+	}
+}
+
+func (pi *ParallelFlightIterator)keys(fq *FQuery) ([]ds.Keyer, error) {
+	q := *(*ds.Query)(fq) // Don't turn the caller's query into a keys-only one
+	return pi.p.GetAll(pi.ctx, q.KeysOnly(), nil)
+}
+
+// }}}
+// {{{ pi.decode
+
+func (pi *ParallelFlightIterator)decode(blobs <-chan fetchedBlob) {
+	for fb := range blobs {
+		if pi.ctx.Err() != nil { return }
+
+		if err := loadChunks(pi.ctx, pi.p, fb.keyer, &fb.blob); err != nil {
+			pi.setErr(fmt.Errorf("ParallelFlightIterator: %v", err))
+			return
+		}
+		f,err := fb.blob.ToFlight(fb.keyer.Encode())
+		if err != nil {
+			pi.setErr(fmt.Errorf("ParallelFlightIterator: %s: %v", fb.keyer.Encode(), err))
+			return
+		}
+		overlayAirframes(pi.airframes, f)
+
+		select {
+		case pi.out <- f:
+		case <-pi.ctx.Done():
+			return
+		}
+	}
+}
+
+// }}}
+
+// {{{ pi.Flights, pi.Err, pi.Cancel
+
+// Flights returns the channel of results; it is closed when the results run out, or when
+// something went wrong (see Err), or the iterator was cancelled.
+func (pi *ParallelFlightIterator)Flights() <-chan *fdb.Flight { return pi.out }
+
+// Err returns the first error that any of the goroutines hit (including cancellation of the
+// context passed in at creation). It is only meaningful once the Flights channel is closed.
+func (pi *ParallelFlightIterator)Err() error { return pi.err }
+
+// Cancel stops the iterator early, without it counting as an error. Callers that might not read
+// all the way to the end of Flights must call it, or the goroutines will leak.
+func (pi *ParallelFlightIterator)Cancel() { pi.cancel() }
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
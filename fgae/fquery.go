@@ -5,6 +5,7 @@ package fgae
 import(
 	"time"
 	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
 	"github.com/skypies/util/date"
 
 	ds "github.com/skypies/util/gcp/ds"
@@ -19,6 +20,9 @@ func NewFlightQuery() *FQuery { return (*FQuery)(ds.NewQuery(kFlightKind)) }
 
 func (fq *FQuery)Order(str string) *FQuery { return (*FQuery)((*ds.Query)(fq).Order(str)) }
 func (fq *FQuery)Limit(val int) *FQuery { return (*FQuery)((*ds.Query)(fq).Limit(val)) }
+func (fq *FQuery)Ancestor(keyer ds.Keyer) *FQuery {
+	return (*FQuery)((*ds.Query)(fq).Ancestor(keyer))
+}
 func (fq *FQuery)Filter(str string, val interface{}) *FQuery {
 	return (*FQuery)((*ds.Query)(fq).Filter(str,val))
 }
@@ -47,6 +51,14 @@ func (q *FQuery)ByTimeRange(s,e time.Time) *FQuery {
 	//}
 }
 
+// Matches flights whose first trackpoint lies in [s,e). Unlike ByTimeRange, this can be combined
+// with ordering by StartTime, which is what LookupPage needs.
+func (q *FQuery)ByStartTimeRange(s,e time.Time) *FQuery {
+	return q.
+		Filter("StartTime >= ", s).
+		Filter("StartTime < ", e)
+}
+
 func (q *FQuery)ByIcaoId(id adsb.IcaoId) *FQuery {
 	return q.Filter("Icao24 = ", string(id))
 }
@@ -67,6 +79,46 @@ func (q *FQuery)ByWaypoints(waypoints []string) *FQuery {
 	return q
 }
 
+// Flights persisted before cells were indexed have none, and won't match; the 'cells' batch job
+// re-persists them with cells. Until it has been run over a time range, don't use these filters
+// on it (see CellsIndexedFor). These filters are coarse (a flight that passes a few km away from
+// the box may match), so callers still need to check the decoded flights properly.
+//
+// A flight has lots of cells, so the only composite index on them is with StartTime, which has
+// a single value; any other filters (tags, timeslots) would multiply the index entries. So these
+// can only be combined with ByStartTimeRange; to combine them with anything else, run them as a
+// separate query (see FlightDB.NewRestrictedParallelIterator).
+func (q *FQuery)ByBoundingBox(box geo.LatlongBox) *FQuery {
+	return q.byCells(fdb.QueryCellsForBoxes([]geo.LatlongBox{box}))
+}
+func (q *FQuery)ByRestrictor(grs fdb.GeoRestrictorSet) *FQuery {
+	return q.byCells(grs.QueryCells())
+}
+
+// Flights that started before this may not have been backfilled with cells yet. The apps set
+// it from the 'cells.backfilledfrom' config, which should be moved back as the 'cells' batch job
+// works through older days; until it is set, cells aren't used at all.
+var KCellsBackfilledFrom time.Time
+
+// How long before a time range a flight in it might have started; cell queries for the range
+// look at flights starting this far back.
+var KMaxFlightDuration = 24 * time.Hour
+
+// CellsIndexedFor says whether all the flights in a time range starting at s have cells.
+func CellsIndexedFor(s time.Time) bool {
+	if KCellsBackfilledFrom.IsZero() { return false }
+	return !s.Add(-KMaxFlightDuration).Before(KCellsBackfilledFrom)
+}
+
+func (q *FQuery)byCells(cells []string) *FQuery {
+	if len(cells) == 0 { return q } // Too big to index on; leave it all for the caller
+	vals := []interface{}{fdb.KIndexCellsOverflow} // Could be anywhere
+	for _,cell := range cells {
+		vals = append(vals, cell)
+	}
+	return q.Filter("Cells in ", vals)
+}
+
 func (q *FQuery)ByIdSpec(idspec fdb.IdSpec) *FQuery {
 	if idspec.Duration != 0 {
 		q.ByTimeRange(idspec.Time, idspec.Time.Add(idspec.Duration))
@@ -120,3 +172,10 @@ func QueryForTimeRangeWaypoint(tags []string, waypoints []string, s,e time.Time)
 		ByTimeRange(s,e)
 	//.Order("-LastUpdate")  // No index
 }
+
+// For paging through a day's flights with LookupPage
+func QueryForStartTimeRange(tags []string, s,e time.Time) *FQuery {
+	return NewFlightQuery().
+		ByTags(tags).
+		ByStartTimeRange(s,e)
+}
@@ -2,7 +2,9 @@ package fgae
 
 import(
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 	"context"
 
 	"github.com/skypies/util/gcp/ds"
@@ -11,6 +13,9 @@ import(
 
 const	kRestrictorSetKind = "RSet"
 
+// Each time a set is persisted, the version it replaces is kept as a child entity of it.
+const	kRestrictorSetVersionKind = "RSetVersion"
+
 func userToRootKey(ctx context.Context, p ds.DatastoreProvider, user string) ds.Keyer {
 	return p.NewNameKey(ctx, kRestrictorSetKind, strings.ToLower(user), nil)
 }
@@ -39,6 +44,43 @@ func (flightdb *FlightDB)LookupRestrictorSets(userEmail string) ([]fdb.GeoRestri
 	return rsets, nil
 }
 
+// }}}
+// {{{ db.LookupSharedRestrictorSets
+
+// LookupSharedRestrictorSets returns the sets owned by other users that have been shared with
+// this user, or made public.
+func (flightdb *FlightDB)LookupSharedRestrictorSets(userEmail string) ([]fdb.GeoRestrictorSet, error) {
+	user := strings.ToLower(userEmail)
+	queries := []*ds.Query{
+		ds.NewQuery(kRestrictorSetKind).Filter("SharedWith = ", user),
+		ds.NewQuery(kRestrictorSetKind).Filter("Public = ", true),
+	}
+
+	seen := map[string]bool{}
+	rsets := []fdb.GeoRestrictorSet{}
+	for _,q := range queries {
+		blobs := []fdb.IndexedRestrictorSetBlob{}
+		keyers, err := flightdb.Backend.GetAll(flightdb.Ctx(), q, &blobs)
+		if err != nil {
+			return nil, fmt.Errorf("LookupSharedRestrictorSets: %v", err)
+		}
+
+		for i,blob := range blobs {
+			key := keyers[i].Encode()
+			if seen[key] || strings.EqualFold(blob.User, user) { continue }
+			seen[key] = true
+			if rset,err := blob.ToRestrictorSet(key); err != nil {
+				return nil, fmt.Errorf("LookupSharedRestrictorSets: %v", err)
+			} else {
+				rsets = append(rsets, *rset)
+			}
+		}
+	}
+
+	sort.Slice(rsets, func(i,j int) bool { return rsets[i].Name < rsets[j].Name })
+	return rsets, nil
+}
+
 // }}}
 // {{{ db.PersistRestrictorSet
 
@@ -49,13 +91,22 @@ func (flightdb *FlightDB)PersistRestrictorSet(grs fdb.GeoRestrictorSet) error {
 	keyer := flightdb.Backend.NewIncompleteKey(flightdb.Ctx(), kRestrictorSetKind,
 		userToRootKey(flightdb.Ctx(), flightdb.Backend, grs.User))
 
+	grs.Version = 1
 	if grs.DSKey != "" {
 		var err error
 		keyer,err = flightdb.Backend.DecodeKey(grs.DSKey)
 		if err != nil {
 			return fmt.Errorf("PersistRestrictorSet[%s]: bad key '%s': %v", grs, grs.DSKey, err)
 		}
+
+		// Keep the version we're about to overwrite
+		if prev,err := flightdb.saveRestrictorSetVersion(keyer); err != nil {
+			return fmt.Errorf("PersistRestrictorSet[%s]: %v", grs, err)
+		} else {
+			grs.Version = prev + 1
+		}
 	}
+	grs.Updated = time.Now()
 
 	blob,err := grs.ToBlob()
 	if err != nil {
@@ -96,9 +147,18 @@ func (flightdb *FlightDB)LoadRestrictorSet(dskey string) (fdb.GeoRestrictorSet,
 func (flightdb *FlightDB)DeleteRestrictorSet(dskey string) (error) {
 	p := flightdb.Backend
 
-	if keyer,err := p.DecodeKey(dskey); err != nil {
+	keyer,err := p.DecodeKey(dskey)
+	if err != nil {
 		return fmt.Errorf("DeleteRestrictorSet '%s' : %v", dskey, err)
-	} else if err := p.Delete(flightdb.Ctx(), keyer); err != nil {
+	}
+
+	q := ds.NewQuery(kRestrictorSetVersionKind).Ancestor(keyer).KeysOnly()
+	versionKeyers,err := p.GetAll(flightdb.Ctx(), q, nil)
+	if err != nil {
+		return fmt.Errorf("DeleteRestrictorSet '%s' : %v", dskey, err)
+	}
+
+	if err := p.DeleteMulti(flightdb.Ctx(), append(versionKeyers, keyer)); err != nil {
 		return fmt.Errorf("DeleteRestrictorSet '%s' : %v", dskey, err)
 	}
 	return nil
@@ -106,6 +166,89 @@ func (flightdb *FlightDB)DeleteRestrictorSet(dskey string) (error) {
 
 // }}}
 
+// {{{ db.saveRestrictorSetVersion
+
+// saveRestrictorSetVersion copies the stored set into a version entity, and returns its version
+// number (or zero, if nothing is stored).
+func (flightdb *FlightDB)saveRestrictorSetVersion(keyer ds.Keyer) (int, error) {
+	p := flightdb.Backend
+	blob := fdb.IndexedRestrictorSetBlob{}
+	if err := p.Get(flightdb.Ctx(), keyer, &blob); err == ds.ErrNoSuchEntity {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("saveRestrictorSetVersion: %v", err)
+	}
+
+	if blob.Version == 0 { blob.Version = 1 } // Persisted before we kept versions
+
+	// Versions are not shared, and shouldn't turn up in queries for live sets
+	blob.Public,blob.SharedWith = false,nil
+
+	vKeyer := p.NewIDKey(flightdb.Ctx(), kRestrictorSetVersionKind, int64(blob.Version), keyer)
+	if _,err := p.Put(flightdb.Ctx(), vKeyer, &blob); err != nil {
+		return 0, fmt.Errorf("saveRestrictorSetVersion: %v", err)
+	}
+
+	return blob.Version, nil
+}
+
+// }}}
+// {{{ db.LookupRestrictorSetVersions
+
+// LookupRestrictorSetVersions returns the earlier versions of the set, most recent first. Their
+// DSKeys are those of the versions, not the set.
+func (flightdb *FlightDB)LookupRestrictorSetVersions(dskey string) ([]fdb.GeoRestrictorSet, error) {
+	p := flightdb.Backend
+	keyer,err := p.DecodeKey(dskey)
+	if err != nil {
+		return nil, fmt.Errorf("LookupRestrictorSetVersions '%s' : %v", dskey, err)
+	}
+
+	q := ds.NewQuery(kRestrictorSetVersionKind).Ancestor(keyer)
+	blobs := []fdb.IndexedRestrictorSetBlob{}
+	keyers,err := p.GetAll(flightdb.Ctx(), q, &blobs)
+	if err != nil {
+		return nil, fmt.Errorf("LookupRestrictorSetVersions '%s' : %v", dskey, err)
+	}
+
+	rsets := []fdb.GeoRestrictorSet{}
+	for i,blob := range blobs {
+		if rset,err := blob.ToRestrictorSet(keyers[i].Encode()); err != nil {
+			return nil, fmt.Errorf("LookupRestrictorSetVersions '%s' : %v", dskey, err)
+		} else {
+			rset.Version = blob.Version
+			rsets = append(rsets, *rset)
+		}
+	}
+
+	sort.Slice(rsets, func(i,j int) bool { return rsets[i].Version > rsets[j].Version })
+	return rsets, nil
+}
+
+// }}}
+// {{{ db.RestoreRestrictorSetVersion
+
+// RestoreRestrictorSetVersion makes an earlier version the current one again (which means the
+// current one becomes a version, so nothing is lost). Sharing is left as it is now.
+func (flightdb *FlightDB)RestoreRestrictorSetVersion(dskey string, version int) error {
+	curr,err := flightdb.LoadRestrictorSet(dskey)
+	if err != nil { return fmt.Errorf("RestoreRestrictorSetVersion: %v", err) }
+
+	versions,err := flightdb.LookupRestrictorSetVersions(dskey)
+	if err != nil { return fmt.Errorf("RestoreRestrictorSetVersion: %v", err) }
+
+	for _,old := range versions {
+		if old.Version != version { continue }
+		old.DSKey = dskey
+		old.Public,old.SharedWith = curr.Public,curr.SharedWith
+		return flightdb.PersistRestrictorSet(old)
+	}
+
+	return fmt.Errorf("RestoreRestrictorSetVersion '%s': no version %d", dskey, version)
+}
+
+// }}}
+
 
 // {{{ -------------------------={ E N D }=----------------------------------
 
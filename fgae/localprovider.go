@@ -0,0 +1,722 @@
+package fgae
+
+// This file contains a DatastoreProvider that needs no cloud project at all. Entities live in
+// memory; if the provider is given a directory, every entity is also mirrored into its own
+// file in there, and the directory is reloaded when the provider is next created. This is
+// enough to run (and test) FlightDB on a laptop.
+
+import(
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"context"
+
+	"github.com/skypies/util/gcp/ds"
+)
+
+const kLocalEntityFileSuffix = ".ent"
+
+func init() {
+	// Property values are held as interface{}s; gob needs to know about the non-basic types.
+	gob.Register(time.Time{})
+}
+
+// {{{ LocalKey
+
+// LocalKey is the Keyer used by LocalDSProvider. Exactly one of Name and ID is set on a
+// complete key; a key with neither is incomplete, and gets an ID when it is Put.
+type LocalKey struct {
+	Kind     string
+	Name     string
+	ID       int64
+	Parent  *LocalKey
+}
+
+type localKeyElem struct {
+	Kind string `json:"k"`
+	Name string `json:"n,omitempty"`
+	ID   int64  `json:"i,omitempty"`
+}
+
+func (k *LocalKey)path() []localKeyElem {
+	if k == nil { return nil }
+	return append(k.Parent.path(), localKeyElem{k.Kind, k.Name, k.ID})
+}
+
+func (k *LocalKey)Encode() string {
+	jsonBytes,_ := json.Marshal(k.path()) // can't fail; it's all strings and ints
+	return base64.RawURLEncoding.EncodeToString(jsonBytes)
+}
+
+func (k *LocalKey)String() string {
+	strs := []string{}
+	for _,e := range k.path() {
+		if e.Name != "" {
+			strs = append(strs, fmt.Sprintf("%s,%q", e.Kind, e.Name))
+		} else {
+			strs = append(strs, fmt.Sprintf("%s,%d", e.Kind, e.ID))
+		}
+	}
+	return "/" + strings.Join(strs, "/")
+}
+
+func (k *LocalKey)Incomplete() bool { return k.Name == "" && k.ID == 0 }
+
+// Root returns the key at the top of k's ancestor path; it identifies k's entity group.
+func (k *LocalKey)Root() *LocalKey {
+	for k.Parent != nil { k = k.Parent }
+	return k
+}
+
+// HasAncestor is true if a is k, or one of k's parents
+func (k *LocalKey)HasAncestor(a *LocalKey) bool {
+	for ; k != nil; k = k.Parent {
+		if k.Encode() == a.Encode() { return true }
+	}
+	return false
+}
+
+func decodeLocalKey(encoded string) (*LocalKey, error) {
+	jsonBytes,err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeKey{local} '%s': %v", encoded, err)
+	}
+	path := []localKeyElem{}
+	if err := json.Unmarshal(jsonBytes, &path); err != nil {
+		return nil, fmt.Errorf("DecodeKey{local} '%s': %v", encoded, err)
+	} else if len(path) == 0 {
+		return nil, fmt.Errorf("DecodeKey{local} '%s': empty key", encoded)
+	}
+
+	var k *LocalKey
+	for _,e := range path {
+		k = &LocalKey{Kind:e.Kind, Name:e.Name, ID:e.ID, Parent:k}
+	}
+	return k, nil
+}
+
+// Keys are ordered as datastore orders them: by path, with ID keys ahead of name keys.
+func localKeyLess(k1, k2 *LocalKey) bool {
+	p1,p2 := k1.path(),k2.path()
+	for i:=0; i<len(p1) && i<len(p2); i++ {
+		e1,e2 := p1[i],p2[i]
+		if e1.Kind != e2.Kind { return e1.Kind < e2.Kind }
+		if (e1.Name == "") != (e2.Name == "") { return e1.Name == "" }
+		if e1.ID != e2.ID { return e1.ID < e2.ID }
+		if e1.Name != e2.Name { return e1.Name < e2.Name }
+	}
+	return len(p1) < len(p2)
+}
+
+// }}}
+// {{{ localEntity, and its properties
+
+// A localEntity is what we keep for each Put. The object itself is held as a gob, so callers
+// can't reach in and mutate stored state; the indexable fields are pulled out as properties,
+// so queries never need to know the object's type.
+type localEntity struct {
+	Key   *LocalKey
+	Data  []byte
+	Props  map[string][]interface{}
+}
+
+// entityProperties returns the indexed properties of a struct; fields tagged `datastore:"-"` or
+// `datastore:",noindex"` are skipped. Slice fields are multi-valued properties.
+func entityProperties(src interface{}) map[string][]interface{} {
+	props := map[string][]interface{}{}
+
+	v := reflect.Indirect(reflect.ValueOf(src))
+	if v.Kind() != reflect.Struct { return props }
+
+	for i:=0; i<v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" { continue } // unexported
+
+		tagBits := strings.Split(sf.Tag.Get("datastore"), ",")
+		name,opts := tagBits[0],tagBits[1:]
+		if name == "-" { continue }
+		if name == "" { name = sf.Name }
+		noindex := false
+		for _,opt := range opts {
+			if opt == "noindex" { noindex = true }
+		}
+		if noindex { continue }
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for j:=0; j<fv.Len(); j++ {
+				if pv,ok := normalizeProperty(fv.Index(j)); ok {
+					props[name] = append(props[name], pv)
+				}
+			}
+		} else if pv,ok := normalizeProperty(fv); ok {
+			props[name] = append(props[name], pv)
+		}
+	}
+
+	return props
+}
+
+// normalizeProperty collapses named types (e.g. BlobEncoding) down to their basic kind, so that
+// stored values and query values can be compared.
+func normalizeProperty(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		return normalizeProperty(v.Elem())
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Struct:
+		if t,ok := v.Interface().(time.Time); ok { return t, true }
+	}
+	return nil, false
+}
+
+// compareProperties returns -1, 0 or +1; ok is false if the two values can't be compared.
+func compareProperties(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case string:
+		if bv,ok := b.(string); ok { return strings.Compare(av,bv), true }
+	case int64:
+		if bv,ok := b.(int64); ok {
+			if av < bv { return -1, true } else if av > bv { return 1, true }
+			return 0, true
+		}
+	case float64:
+		if bv,ok := b.(float64); ok {
+			if av < bv { return -1, true } else if av > bv { return 1, true }
+			return 0, true
+		}
+	case bool:
+		if bv,ok := b.(bool); ok {
+			if av == bv { return 0, true } else if !av { return -1, true }
+			return 1, true
+		}
+	case time.Time:
+		if bv,ok := b.(time.Time); ok {
+			if av.Before(bv) { return -1, true } else if av.After(bv) { return 1, true }
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// }}}
+
+// {{{ LocalDSProvider
+
+// LocalDSProvider implements the DatastoreProvider interface in-process, for use on machines
+// with no access to a cloud project. Dir is optional; if empty, nothing touches the disk.
+type LocalDSProvider struct {
+	Dir         string
+
+	mu          sync.RWMutex
+	entities    map[string]*localEntity // by encoded key
+	lastID      int64
+	versions    map[string]int64 // by encoded root key; bumped by every write to the entity group
+}
+
+// NewLocalDSProvider returns a provider; if dir is not empty, it is created if needed, and any
+// entities previously written to it are loaded back up.
+func NewLocalDSProvider(dir string) (*LocalDSProvider, error) {
+	p := LocalDSProvider{Dir:dir, entities:map[string]*localEntity{}, versions:map[string]int64{}}
+
+	if dir == "" { return &p, nil }
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("NewLocalDSProvider: %v", err)
+	}
+	filenames,err := filepath.Glob(filepath.Join(dir, "*"+kLocalEntityFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("NewLocalDSProvider: %v", err)
+	}
+	for _,filename := range filenames {
+		data,err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("NewLocalDSProvider: %v", err)
+		}
+		ent := localEntity{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ent); err != nil {
+			return nil, fmt.Errorf("NewLocalDSProvider %s: %v", filename, err)
+		}
+		p.entities[ent.Key.Encode()] = &ent
+		if ent.Key.ID > p.lastID { p.lastID = ent.Key.ID }
+	}
+
+	return &p, nil
+}
+
+// }}}
+// {{{ p.{un}packKeyer, p.{write|remove}File
+
+func (p *LocalDSProvider)unpackKeyer(in ds.Keyer) (*LocalKey, error) {
+	if in == nil { return nil, nil }
+	if k,ok := in.(*LocalKey); ok { return k, nil }
+	return nil, fmt.Errorf("LocalDSProvider: keyer %v is a %T, not a *LocalKey", in, in)
+}
+
+func (p *LocalDSProvider)filename(k *LocalKey) string {
+	return filepath.Join(p.Dir, k.Encode() + kLocalEntityFileSuffix)
+}
+
+func (p *LocalDSProvider)writeFile(ent *localEntity) error {
+	if p.Dir == "" { return nil }
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ent); err != nil {
+		return err
+	}
+
+	// Write then rename, so a crash can't leave a half-written entity behind.
+	tmpname := p.filename(ent.Key) + ".tmp"
+	if err := os.WriteFile(tmpname, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, p.filename(ent.Key))
+}
+
+func (p *LocalDSProvider)removeFile(k *LocalKey) error {
+	if p.Dir == "" { return nil }
+	if err := os.Remove(p.filename(k)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// }}}
+
+// {{{ p.Get, p.GetMulti
+
+func decodeEntityInto(ent *localEntity, dst reflect.Value) error {
+	// Gob merges into whatever is already there; zero it first, to get a fresh load.
+	dst.Elem().Set(reflect.Zero(dst.Elem().Type()))
+	return gob.NewDecoder(bytes.NewReader(ent.Data)).DecodeValue(dst)
+}
+
+func (p *LocalDSProvider)Get(ctx context.Context, keyer ds.Keyer, dst interface{}) error {
+	k,err := p.unpackKeyer(keyer)
+	if err != nil { return err }
+
+	p.mu.RLock()
+	ent,exists := p.entities[k.Encode()]
+	p.mu.RUnlock()
+
+	if !exists { return ds.ErrNoSuchEntity }
+
+	return decodeEntityInto(ent, reflect.ValueOf(dst))
+}
+
+// dst must be a slice (or pointer to slice) of the same length as keyers.
+func (p *LocalDSProvider)GetMulti(ctx context.Context, keyers []ds.Keyer, dst interface{}) error {
+	slice := reflect.Indirect(reflect.ValueOf(dst))
+	if slice.Kind() != reflect.Slice || slice.Len() != len(keyers) {
+		return fmt.Errorf("GetMulti{local}: dst must be a slice of len %d, was %T", len(keyers), dst)
+	}
+
+	var retErr error
+	for i,keyer := range keyers {
+		elem := slice.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() { elem.Set(reflect.New(elem.Type().Elem())) }
+		} else {
+			elem = elem.Addr()
+		}
+		if err := p.Get(ctx, keyer, elem.Interface()); err != nil {
+			retErr = err
+		}
+	}
+
+	return retErr
+}
+
+// }}}
+// {{{ p.GetAll
+
+func (p *LocalDSProvider)GetAll(ctx context.Context, q *ds.Query, dst interface{}) ([]ds.Keyer, error) {
+	if len(q.ProjectFields) != 0 || q.DistinctVals {
+		return nil, fmt.Errorf("GetAll{local}: projection queries not supported\nQuery: %s", q)
+	}
+	ancestor,err := p.unpackKeyer(q.AncestorKeyer)
+	if err != nil { return nil, err }
+
+	p.mu.RLock()
+	matches := []*localEntity{}
+	for _,ent := range p.entities {
+		if ent.Key.Kind != q.Kind { continue }
+		if ancestor != nil && !ent.Key.HasAncestor(ancestor) { continue }
+		if ok,err := entityMatchesFilters(ent, q.Filters); err != nil {
+			p.mu.RUnlock()
+			return nil, fmt.Errorf("GetAll{local}: %v\nQuery: %s", err, q)
+		} else if ok {
+			matches = append(matches, ent)
+		}
+	}
+	p.mu.RUnlock()
+
+	matches = sortEntities(matches, q.OrderStr)
+	if q.LimitVal > 0 && len(matches) > q.LimitVal {
+		matches = matches[:q.LimitVal]
+	}
+
+	keyers := []ds.Keyer{}
+	for _,ent := range matches {
+		keyers = append(keyers, ent.Key)
+	}
+
+	if q.KeysOnlyVal || dst == nil {
+		return keyers, nil
+	}
+
+	slicePtr := reflect.ValueOf(dst)
+	if slicePtr.Kind() != reflect.Ptr || slicePtr.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("GetAll{local}: dst must be a pointer to a slice, was %T", dst)
+	}
+	slice := slicePtr.Elem()
+	elemType := slice.Type().Elem()
+	for _,ent := range matches {
+		if elemType.Kind() == reflect.Ptr {
+			newVal := reflect.New(elemType.Elem())
+			if err := decodeEntityInto(ent, newVal); err != nil { return nil, err }
+			slice.Set(reflect.Append(slice, newVal))
+		} else {
+			newVal := reflect.New(elemType)
+			if err := decodeEntityInto(ent, newVal); err != nil { return nil, err }
+			slice.Set(reflect.Append(slice, newVal.Elem()))
+		}
+	}
+
+	return keyers, nil
+}
+
+// }}}
+// {{{ entityMatchesFilters
+
+// Datastore semantics for multi-valued properties: each equality filter needs some value to be
+// equal; but all the inequality filters on a property need to be satisfied by a single value.
+func entityMatchesFilters(ent *localEntity, filters []ds.Filter) (bool, error) {
+	inequalities := map[string][]ds.Filter{}
+
+	for _,filter := range filters {
+		bits := strings.Fields(filter.Field)
+		if len(bits) == 1 { bits = append(bits, "=") }
+		if len(bits) != 2 {
+			return false, fmt.Errorf("bad filter %q", filter.Field)
+		}
+		field,op := bits[0],bits[1]
+
+		if op != "=" && op != "in" {
+			inequalities[field] = append(inequalities[field], filter)
+			continue
+		}
+
+		// An "in" filter is an equality filter that any one of several values can satisfy
+		candidates := []reflect.Value{reflect.ValueOf(filter.Value)}
+		if op == "in" {
+			rv := reflect.ValueOf(filter.Value)
+			if rv.Kind() != reflect.Slice {
+				return false, fmt.Errorf("filter %q: 'in' needs a slice, not %T", field, filter.Value)
+			}
+			candidates = nil
+			for i:=0; i<rv.Len(); i++ {
+				candidates = append(candidates, rv.Index(i))
+			}
+		}
+
+		found := false
+		for _,cv := range candidates {
+			val,ok := normalizeProperty(cv)
+			if !ok { return false, fmt.Errorf("filter %q: unsupported value type %s", field, cv.Type()) }
+			for _,pv := range ent.Props[field] {
+				if cmp,ok := compareProperties(pv, val); ok && cmp == 0 {
+					found = true
+					break
+				}
+			}
+			if found { break }
+		}
+		if !found { return false, nil }
+	}
+
+	for field,ineqs := range inequalities {
+		found := false
+		for _,pv := range ent.Props[field] {
+			allOK := true
+			for _,filter := range ineqs {
+				op := strings.Fields(filter.Field)[1]
+				val,ok := normalizeProperty(reflect.ValueOf(filter.Value))
+				if !ok { return false, fmt.Errorf("filter %q: unsupported value type %T", field, filter.Value) }
+				cmp,ok := compareProperties(pv, val)
+				if !ok { allOK = false; break }
+				switch op {
+				case "<":  allOK = cmp < 0
+				case "<=": allOK = cmp <= 0
+				case ">":  allOK = cmp > 0
+				case ">=": allOK = cmp >= 0
+				case "!=": allOK = cmp != 0
+				default: return false, fmt.Errorf("bad filter operator %q", filter.Field)
+				}
+				if !allOK { break }
+			}
+			if allOK {
+				found = true
+				break
+			}
+		}
+		if !found { return false, nil }
+	}
+
+	return true, nil
+}
+
+// }}}
+// {{{ sortEntities
+
+// Without an order, results come back in key order. An ascending sort on a multi-valued property
+// uses the smallest value, a descending sort the largest; entities lacking the property are
+// dropped, just as they would be from the datastore's index.
+func sortEntities(ents []*localEntity, order string) []*localEntity {
+	if order == "" {
+		sort.Slice(ents, func(i,j int) bool { return localKeyLess(ents[i].Key, ents[j].Key) })
+		return ents
+	}
+
+	desc := strings.HasPrefix(order, "-")
+	field := strings.TrimPrefix(order, "-")
+
+	sortVal := func(ent *localEntity) interface{} {
+		var best interface{}
+		for _,pv := range ent.Props[field] {
+			if best == nil {
+				best = pv
+			} else if cmp,ok := compareProperties(pv, best); ok && ((cmp < 0) != desc) && cmp != 0 {
+				best = pv
+			}
+		}
+		return best
+	}
+
+	type sortable struct {
+		ent *localEntity
+		val  interface{}
+	}
+	sortables := []sortable{}
+	for _,ent := range ents {
+		if val := sortVal(ent); val != nil {
+			sortables = append(sortables, sortable{ent, val})
+		}
+	}
+
+	sort.SliceStable(sortables, func(i,j int) bool {
+		if cmp,ok := compareProperties(sortables[i].val, sortables[j].val); ok && cmp != 0 {
+			return (cmp < 0) != desc
+		}
+		return localKeyLess(sortables[i].ent.Key, sortables[j].ent.Key)
+	})
+
+	out := []*localEntity{}
+	for _,s := range sortables {
+		out = append(out, s.ent)
+	}
+	return out
+}
+
+// }}}
+
+// {{{ p.Put, p.PutMulti
+
+// newEntity encodes src, and allocates an ID if the key is incomplete.
+func (p *LocalDSProvider)newEntity(keyer ds.Keyer, src interface{}) (*localEntity, error) {
+	k,err := p.unpackKeyer(keyer)
+	if err != nil { return nil, err }
+	if k == nil { return nil, fmt.Errorf("nil key") }
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(src); err != nil {
+		return nil, fmt.Errorf("%s: %v", k, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k.Incomplete() {
+		p.lastID++
+		k = &LocalKey{Kind:k.Kind, ID:p.lastID, Parent:k.Parent}
+	} else if k.ID > p.lastID {
+		p.lastID = k.ID
+	}
+
+	return &localEntity{Key:k, Data:buf.Bytes(), Props:entityProperties(src)}, nil
+}
+
+// putLocked and deleteLocked need the caller to hold p.mu for writing.
+func (p *LocalDSProvider)putLocked(ent *localEntity) error {
+	if err := p.writeFile(ent); err != nil {
+		return fmt.Errorf("%s: %v", ent.Key, err)
+	}
+	p.entities[ent.Key.Encode()] = ent
+	p.versions[ent.Key.Root().Encode()]++
+	return nil
+}
+func (p *LocalDSProvider)deleteLocked(k *LocalKey) error {
+	if err := p.removeFile(k); err != nil {
+		return fmt.Errorf("%s: %v", k, err)
+	}
+	delete(p.entities, k.Encode())
+	p.versions[k.Root().Encode()]++
+	return nil
+}
+
+func (p *LocalDSProvider)Put(ctx context.Context, keyer ds.Keyer, src interface{}) (ds.Keyer, error) {
+	ent,err := p.newEntity(keyer, src)
+	if err != nil { return nil, fmt.Errorf("Put{local}: %v", err) }
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.putLocked(ent); err != nil {
+		return nil, fmt.Errorf("Put{local}: %v", err)
+	}
+
+	return ent.Key, nil
+}
+
+// forEachElem calls f on (a pointer to) each element of src, which must be a slice (or pointer
+// to a slice) of length n.
+func forEachElem(src interface{}, n int, f func(int, interface{}) error) error {
+	slice := reflect.Indirect(reflect.ValueOf(src))
+	if slice.Kind() != reflect.Slice || slice.Len() != n {
+		return fmt.Errorf("src must be a slice of len %d, was %T", n, src)
+	}
+
+	for i:=0; i<n; i++ {
+		elem := slice.Index(i)
+		if elem.Kind() != reflect.Ptr { elem = elem.Addr() }
+		if err := f(i, elem.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *LocalDSProvider)PutMulti(ctx context.Context, keyers []ds.Keyer, src interface{}) ([]ds.Keyer, error) {
+	out := []ds.Keyer{}
+	err := forEachElem(src, len(keyers), func(i int, elem interface{}) error {
+		newKeyer,err := p.Put(ctx, keyers[i], elem)
+		if err == nil { out = append(out, newKeyer) }
+		return err
+	})
+	if err != nil { return out, fmt.Errorf("PutMulti{local}: %v", err) }
+	return out, nil
+}
+
+// }}}
+// {{{ p.Delete, p.DeleteMulti
+
+// As with the datastore, deleting a key that doesn't exist is not an error.
+func (p *LocalDSProvider)Delete(ctx context.Context, keyer ds.Keyer) error {
+	k,err := p.unpackKeyer(keyer)
+	if err != nil { return err }
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.deleteLocked(k); err != nil {
+		return fmt.Errorf("Delete{local}: %v", err)
+	}
+	return nil
+}
+
+func (p *LocalDSProvider)DeleteMulti(ctx context.Context, keyers []ds.Keyer) error {
+	for _,keyer := range keyers {
+		if err := p.Delete(ctx, keyer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// }}}
+// {{{ p.New*Key, p.DecodeKey, p.Key*
+
+func (p *LocalDSProvider)NewIncompleteKey(ctx context.Context, kind string, root ds.Keyer) ds.Keyer {
+	parent,_ := p.unpackKeyer(root)
+	return &LocalKey{Kind:kind, Parent:parent}
+}
+func (p *LocalDSProvider)NewNameKey(ctx context.Context, kind, name string, root ds.Keyer) ds.Keyer {
+	parent,_ := p.unpackKeyer(root)
+	return &LocalKey{Kind:kind, Name:name, Parent:parent}
+}
+func (p *LocalDSProvider)NewIDKey(ctx context.Context, kind string, id int64, root ds.Keyer) ds.Keyer {
+	parent,_ := p.unpackKeyer(root)
+	return &LocalKey{Kind:kind, ID:id, Parent:parent}
+}
+
+func (p *LocalDSProvider)DecodeKey(encoded string) (ds.Keyer, error) {
+	k,err := decodeLocalKey(encoded)
+	if err != nil { return nil, err }
+	return k, nil
+}
+func (p *LocalDSProvider)KeyParent(in ds.Keyer) ds.Keyer {
+	if k,_ := p.unpackKeyer(in); k != nil && k.Parent != nil {
+		return k.Parent
+	}
+	return nil
+}
+func (p *LocalDSProvider)KeyName(in ds.Keyer) string {
+	if k,_ := p.unpackKeyer(in); k != nil {
+		return k.Name
+	}
+	return ""
+}
+
+// }}}
+// {{{ p.HTTPClient, logging
+
+func (p *LocalDSProvider)HTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{}
+}
+
+func (p *LocalDSProvider)Debugf(ctx context.Context, format string, args ...interface{}) {
+	if ds.Debug { log.Printf(format, args...) }
+}
+func (p *LocalDSProvider)Infof(ctx context.Context, format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+func (p *LocalDSProvider)Errorf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+func (p *LocalDSProvider)Warningf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+func (p *LocalDSProvider)Criticalf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
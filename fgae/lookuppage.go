@@ -0,0 +1,135 @@
+package fgae
+
+// Paging through query results in start-time order. Cursors are opaque to callers; inside, they
+// hold the StartTime of the last flight returned, and how many flights with exactly that
+// StartTime have been returned so far (results are ordered by key within a StartTime, so the
+// next page can skip over them). This keeps working even if flights get re-persisted between
+// pages, as long as no new flights arrive with that exact StartTime.
+
+import(
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+)
+
+// Page sizes usually come straight from a URL, so LookupPage won't return more than this.
+var KMaxPageSize = 1000
+
+type pageCursor struct {
+	Start time.Time `json:"t"`
+	Skip  int       `json:"s"`
+}
+
+// {{{ pageCursor
+
+func (c pageCursor)encode() string {
+	b,_ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageCursor(str string) (pageCursor, error) {
+	c := pageCursor{}
+	if b,err := base64.RawURLEncoding.DecodeString(str); err != nil {
+		return c, fmt.Errorf("bad cursor: %v", err)
+	} else if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("bad cursor: %v", err)
+	} else if c.Skip < 0 {
+		return c, fmt.Errorf("bad cursor: negative skip")
+	}
+	return c, nil
+}
+
+// }}}
+
+// {{{ db.LookupPage
+
+// LookupPage returns up to n flights matching the query, in order of StartTime, starting after
+// the flights returned by the call that yielded the cursor (use "" for the first page); n is
+// capped at KMaxPageSize. It also
+// returns the cursor for the next page, which is "" when there are no more results. The query
+// must not have its own ordering or limit, and its only inequality filters should be on
+// StartTime (e.g. ByStartTimeRange, rather than ByTimeRange).
+//
+// Flights persisted before StartTime was indexed won't show up; a retag or reencode pass will
+// re-persist them with it.
+func (db *FlightDB)LookupPage(fq *FQuery, cursor string, n int) ([]*fdb.Flight, string, error) {
+	if n <= 0 {
+		return nil, "", fmt.Errorf("LookupPage: bad page size %d", n)
+	} else if n > KMaxPageSize {
+		n = KMaxPageSize
+	}
+
+	// Work on a copy, so the caller can reuse the query for the next page
+	q := *(*ds.Query)(fq)
+	q.Filters = append([]ds.Filter{}, q.Filters...)
+
+	c := pageCursor{}
+	if cursor != "" {
+		var err error
+		if c,err = decodePageCursor(cursor); err != nil {
+			return nil, "", fmt.Errorf("LookupPage: %v", err)
+		}
+		q.Filter("StartTime >= ", c.Start)
+	}
+	q.Order("StartTime").Limit(c.Skip + n + 1) // the extra one tells us if there are more
+
+	blobs := []fdb.IndexedFlightBlob{}
+	keyers,err := db.Backend.GetAll(db.Ctx(), &q, &blobs)
+	if err != nil {
+		return nil, "", fmt.Errorf("LookupPage: %v", err)
+	}
+
+	if len(blobs) <= c.Skip {
+		return []*fdb.Flight{}, "", nil
+	}
+	keyers,blobs = keyers[c.Skip:], blobs[c.Skip:]
+	hasMore := len(blobs) > n
+	if hasMore {
+		keyers,blobs = keyers[:n], blobs[:n]
+	}
+
+	flights := []*fdb.Flight{}
+	for i,blob := range blobs {
+		if err := loadChunks(db.Ctx(), db.Backend, keyers[i], &blob); err != nil {
+			return nil, "", fmt.Errorf("LookupPage: %v", err)
+		} else if flight,err := blob.ToFlight(keyers[i].Encode()); err != nil {
+			return nil, "", fmt.Errorf("LookupPage: %v", err)
+		} else {
+			flights = append(flights, flight)
+		}
+	}
+
+	ac,err := db.airframesForOverlay()
+	if err != nil { return nil, "", fmt.Errorf("LookupPage: %v", err) }
+	overlayAirframes(ac, flights...)
+
+	if !hasMore {
+		return flights, "", nil
+	}
+
+	// Count how many results share the final StartTime; if they all do, then the ones we skipped
+	// over to get here share it too.
+	next := pageCursor{Start: blobs[len(blobs)-1].StartTime}
+	for i:=len(blobs)-1; i>=0 && blobs[i].StartTime.Equal(next.Start); i-- {
+		next.Skip++
+	}
+	if next.Skip == len(blobs) && next.Start.Equal(c.Start) {
+		next.Skip += c.Skip
+	}
+
+	return flights, next.encode(), nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
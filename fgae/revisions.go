@@ -0,0 +1,207 @@
+package fgae
+
+// Revision history. If FlightDB.KeepRevisions is set, then each time PersistFlight overwrites a
+// flight, the blob being overwritten is first copied into a revision entity, a child of the
+// flight entity. Revisions have IDs derived from the time they were created, so key order is
+// chronological. Oversize revision blobs are chunked, just like flight blobs (see chunks.go).
+
+import(
+	"fmt"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+)
+
+const kFlightRevisionKind = "flightrevision"
+
+// How many revisions to keep per flight; the oldest ones get deleted.
+var KMaxFlightRevisions = 10
+
+// The thing we store. None of it needs indexing, as we only ever look revisions up by ancestor.
+type flightRevision struct {
+	Reason         string           `datastore:",noindex"` // Why the revision was superseded
+	Superseded     time.Time        `datastore:",noindex"`
+	LastUpdate     time.Time        `datastore:",noindex"` // When the revision was persisted
+	Blob         []byte             `datastore:",noindex"`
+	BlobEncoding   fdb.BlobEncoding `datastore:",noindex"`
+	NumChunks      int              `datastore:",noindex"`
+}
+
+// FlightRevision describes a revision, without its contents; use LookupRevision for those.
+type FlightRevision struct {
+	Key            string
+	Reason         string
+	Superseded     time.Time
+	LastUpdate     time.Time
+}
+
+func (fr FlightRevision)String() string {
+	return fmt.Sprintf("%s superseded:%s (%q)", fr.Key, fr.Superseded.Format(time.RFC3339), fr.Reason)
+}
+
+// {{{ db.saveRevision
+
+// saveRevision snapshots the currently stored version of the flight (if there is one).
+func (db *FlightDB)saveRevision(keyer ds.Keyer, reason string) error {
+	old,err := db.LookupBlob(keyer)
+	if err == ds.ErrNoSuchEntity {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("saveRevision: %v", err)
+	}
+
+	now := time.Now()
+	chunks := old.SplitIntoChunks()
+	rev := flightRevision{
+		Reason: reason,
+		Superseded: now,
+		LastUpdate: old.LastUpdate,
+		Blob: old.Blob,
+		BlobEncoding: old.BlobEncoding,
+		NumChunks: old.NumChunks,
+	}
+
+	revKeyer := db.Backend.NewIDKey(db.Ctx(), kFlightRevisionKind, now.UnixNano(), keyer)
+	if len(chunks) > 0 {
+		keyers := chunkKeyers(db.Ctx(), db.Backend, revKeyer, len(chunks))
+		if _,err := db.Backend.PutMulti(db.Ctx(), keyers, chunks); err != nil {
+			return fmt.Errorf("saveRevision chunks: %v", err)
+		}
+	}
+	if _,err := db.Backend.Put(db.Ctx(), revKeyer, &rev); err != nil {
+		return fmt.Errorf("saveRevision: %v", err)
+	}
+
+	// Trim off the oldest, if there are too many
+	revKeyers,err := lookupRevisionKeyers(db, keyer)
+	if err != nil { return fmt.Errorf("saveRevision: %v", err) }
+	if n := len(revKeyers) - KMaxFlightRevisions; n > 0 {
+		if err := deleteRevisionKeyers(db, revKeyers[:n]); err != nil {
+			return fmt.Errorf("saveRevision: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// }}}
+
+// {{{ db.ListRevisions
+
+// ListRevisions returns the revisions of the flight, oldest first.
+func (db *FlightDB)ListRevisions(keyer ds.Keyer) ([]FlightRevision, error) {
+	q := ds.NewQuery(kFlightRevisionKind).Ancestor(keyer)
+	revs := []flightRevision{}
+	revKeyers,err := db.Backend.GetAll(db.Ctx(), q, &revs)
+	if err != nil { return nil, fmt.Errorf("ListRevisions: %v", err) }
+
+	out := []FlightRevision{}
+	for i,rev := range revs {
+		out = append(out, FlightRevision{
+			Key: revKeyers[i].Encode(),
+			Reason: rev.Reason,
+			Superseded: rev.Superseded,
+			LastUpdate: rev.LastUpdate,
+		})
+	}
+	return out, nil
+}
+
+// }}}
+// {{{ db.LookupRevision
+
+// LookupRevision decodes the revision into a flight. The flight carries the datastore key of the
+// flight it is a revision of, so persisting it will overwrite the current version.
+func (db *FlightDB)LookupRevision(revKeyer ds.Keyer) (*fdb.Flight, error) {
+	rev := flightRevision{}
+	if err := db.Backend.Get(db.Ctx(), revKeyer, &rev); err != nil {
+		return nil, fmt.Errorf("LookupRevision: %v", err)
+	}
+
+	blob := fdb.IndexedFlightBlob{
+		Blob: rev.Blob,
+		BlobEncoding: rev.BlobEncoding,
+		NumChunks: rev.NumChunks,
+		LastUpdate: rev.LastUpdate,
+	}
+	if err := loadChunks(db.Ctx(), db.Backend, revKeyer, &blob); err != nil {
+		return nil, fmt.Errorf("LookupRevision: %v", err)
+	}
+
+	f,err := blob.ToFlight(db.Backend.KeyParent(revKeyer).Encode())
+	if err != nil { return nil, fmt.Errorf("LookupRevision: %v", err) }
+	return f, nil
+}
+
+// }}}
+// {{{ db.RollbackToRevision
+
+// RollbackToRevision makes the revision the current version of its flight. The version being
+// replaced is kept as a revision, even if KeepRevisions isn't set, so the rollback can itself be
+// undone.
+func (db *FlightDB)RollbackToRevision(revKeyer ds.Keyer) error {
+	f,err := db.LookupRevision(revKeyer)
+	if err != nil { return fmt.Errorf("RollbackToRevision: %v", err) }
+
+	revdb := *db
+	revdb.KeepRevisions = true
+	if err := revdb.PersistFlightWithReason(f, "rollback to "+revKeyer.Encode()); err != nil {
+		return fmt.Errorf("RollbackToRevision: %v", err)
+	}
+	return nil
+}
+
+// }}}
+
+// {{{ lookupRevisionKeyers
+
+func lookupRevisionKeyers(db *FlightDB, keyer ds.Keyer) ([]ds.Keyer, error) {
+	q := ds.NewQuery(kFlightRevisionKind).Ancestor(keyer).KeysOnly()
+	return db.Backend.GetAll(db.Ctx(), q, nil)
+}
+
+// }}}
+// {{{ deleteRevisionKeyers
+
+func deleteRevisionKeyers(db *FlightDB, revKeyers []ds.Keyer) error {
+	if len(revKeyers) == 0 { return nil }
+	if err := db.Backend.DeleteMulti(db.Ctx(), revKeyers); err != nil {
+		return err
+	}
+	return deleteChunks(db.Ctx(), db.Backend, revKeyers)
+}
+
+// }}}
+// {{{ deleteRevisions
+
+// deleteRevisions removes all the revisions of the flights.
+func deleteRevisions(db *FlightDB, keyers []ds.Keyer) error {
+	revKeyers := []ds.Keyer{}
+	for _,keyer := range keyers {
+		these,err := lookupRevisionKeyers(db, keyer)
+		if err != nil { return fmt.Errorf("deleteRevisions: %v", err) }
+		revKeyers = append(revKeyers, these...)
+	}
+
+	for len(revKeyers) > 0 {
+		n := 500 // max keys in one DeleteMulti call
+		if n > len(revKeyers) { n = len(revKeyers) }
+		if err := deleteRevisionKeyers(db, revKeyers[:n]); err != nil {
+			return fmt.Errorf("deleteRevisions: %v", err)
+		}
+		revKeyers = revKeyers[n:]
+	}
+
+	return nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
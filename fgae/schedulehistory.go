@@ -0,0 +1,177 @@
+package fgae
+
+// The schedule cache (ref.ScheduleCache) only knows what each airframe is flying right now. The
+// schedule history remembers what they were flying in the past, as a set of intervals per
+// airframe; so flights can pick up their schedule (Origin, Destination, flight number) long
+// after they landed.
+//
+// Intervals are written when a schedule starts, and rewritten when it ends (by comparing
+// successive schedule caches; see RecordScheduleHistory), rather than on every poll.
+
+import(
+	"fmt"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/flightdb/ref"
+)
+
+const kScheduleIntervalKind = "scheduleinterval"
+
+// Intervals that never got closed (e.g. the poller stopped) are assumed to last this long.
+var KMaxOpenScheduleInterval = 6 * time.Hour
+
+// A ScheduleInterval says that the airframe was flying under the callsign and schedule from
+// Start to End. If End is zero, the interval is still open.
+type ScheduleInterval struct {
+	IcaoId      string
+	Start       time.Time
+	End         time.Time `datastore:",noindex"`
+	Callsign    string    `datastore:",noindex"`
+	fdb.Schedule          // embedded
+}
+
+func (si ScheduleInterval)String() string {
+	end := "(open)"
+	if !si.End.IsZero() { end = si.End.Format(time.RFC3339) }
+	return fmt.Sprintf("[%s] %s - %s c:%s %s [%s-%s]", si.IcaoId, si.Start.Format(time.RFC3339), end,
+		si.Callsign, si.BestFlightNumber(), si.Origin, si.Destination)
+}
+
+// Contains allows open intervals to run for up to KMaxOpenScheduleInterval.
+func (si ScheduleInterval)Contains(t time.Time) bool {
+	end := si.End
+	if end.IsZero() { end = si.Start.Add(KMaxOpenScheduleInterval) }
+	return !t.Before(si.Start) && !t.After(end)
+}
+
+// AsFlight is for passing to f.MergeIdentityFrom.
+func (si ScheduleInterval)AsFlight() fdb.Flight {
+	f := fdb.BlankFlight()
+	f.IcaoId,f.Callsign,f.Schedule = si.IcaoId,si.Callsign,si.Schedule
+	return f
+}
+
+func newScheduleInterval(id string, since time.Time, fs *fdb.FlightSnapshot) ScheduleInterval {
+	return ScheduleInterval{
+		IcaoId: id,
+		Start: since,
+		Callsign: fs.Callsign,
+		Schedule: fs.Schedule,
+	}
+}
+
+func (db *FlightDB)scheduleIntervalKeyer(si ScheduleInterval) ds.Keyer {
+	name := fmt.Sprintf("%s@%d", si.IcaoId, si.Start.Unix())
+	return db.Backend.NewNameKey(db.Ctx(), kScheduleIntervalKind, name, nil)
+}
+
+// {{{ db.RecordScheduleHistory
+
+// RecordScheduleHistory compares two successive schedule caches (curr should have had
+// CarrySince(prev) called), and records any schedules that started or ended in between.
+func (db *FlightDB)RecordScheduleHistory(prev, curr *ref.ScheduleCache) error {
+	keyers := []ds.Keyer{}
+	intervals := []ScheduleInterval{}
+	add := func(si ScheduleInterval) {
+		keyers = append(keyers, db.scheduleIntervalKeyer(si))
+		intervals = append(intervals, si)
+	}
+
+	for id,fs := range curr.Map {
+		if old := prev.Get(id); old == nil || !ref.SameSchedule(*old, *fs) {
+			add(newScheduleInterval(id, curr.Since[id], fs))
+		}
+	}
+
+	for id,old := range prev.Map {
+		since := prev.Since[id]
+		if since.IsZero() { continue } // From before we kept history, so no interval to close
+		if fs := curr.Get(id); fs == nil || !ref.SameSchedule(*old, *fs) {
+			si := newScheduleInterval(id, since, old)
+			si.End = curr.LastUpdated // The first time we saw it wasn't flying the schedule
+			add(si)
+		}
+	}
+
+	if len(keyers) == 0 { return nil }
+
+	if _,err := db.Backend.PutMulti(db.Ctx(), keyers, intervals); err != nil {
+		return fmt.Errorf("RecordScheduleHistory: %v", err)
+	}
+	return nil
+}
+
+// }}}
+// {{{ db.LookupScheduleAt
+
+// LookupScheduleAt returns the schedule the airframe was flying at time t, or nil if we don't
+// know of one.
+func (db *FlightDB)LookupScheduleAt(icaoId string, t time.Time) (*ScheduleInterval, error) {
+	if icaoId == "" { return nil, nil }
+
+	q := ds.NewQuery(kScheduleIntervalKind).
+		Filter("IcaoId = ", icaoId).
+		Filter("Start <= ", t).
+		Order("-Start").
+		Limit(1)
+
+	intervals := []ScheduleInterval{}
+	if _,err := db.Backend.GetAll(db.Ctx(), q, &intervals); err != nil {
+		return nil, fmt.Errorf("LookupScheduleAt: %v", err)
+	}
+
+	if len(intervals) == 0 || !intervals[0].Contains(t) {
+		return nil, nil
+	}
+	return &intervals[0], nil
+}
+
+// }}}
+// {{{ db.lookupScheduleWithCache
+
+// How far either side of its last update the live schedule cache is trusted to answer for.
+var KScheduleCacheWindow = 10 * time.Minute
+
+// lookupScheduleWithCache only goes to the history for times that the live schedule cache (if
+// there is one) can't answer for; the history has nothing to add for recent times.
+func (db *FlightDB)lookupScheduleWithCache(icaoId string, t time.Time, schedules *ref.ScheduleCache) (*ScheduleInterval, error) {
+	if schedules != nil && !schedules.LastUpdated.IsZero() {
+		if d := t.Sub(schedules.LastUpdated); d > -KScheduleCacheWindow && d < KScheduleCacheWindow {
+			if fs := schedules.Get(icaoId); fs != nil {
+				si := newScheduleInterval(icaoId, schedules.Since[icaoId], fs)
+				return &si, nil
+			}
+			return nil, nil
+		}
+	}
+
+	return db.LookupScheduleAt(icaoId, t)
+}
+
+// }}}
+// {{{ db.MaybeAddScheduleAt
+
+// MaybeAddScheduleAt fills in any missing identity fields from the schedule that the flight's
+// airframe was flying at time t. It returns true if the flight was changed.
+func (db *FlightDB)MaybeAddScheduleAt(f *fdb.Flight, t time.Time, schedules *ref.ScheduleCache) (bool, error) {
+	if f.IcaoId == "" || (f.Origin != "" && f.Destination != "" && f.Number != 0) {
+		return false, nil
+	}
+
+	si,err := db.lookupScheduleWithCache(f.IcaoId, t, schedules)
+	if err != nil || si == nil { return false, err }
+
+	return f.MergeIdentityFrom(si.AsFlight()), nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
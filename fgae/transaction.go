@@ -0,0 +1,216 @@
+package fgae
+
+// The ds.DatastoreProvider interface has no notion of transactions, so providers that can do
+// them also implement Transactor. As with datastore itself, concurrency control is optimistic:
+// a transaction fails with ErrConcurrentTransaction at commit time if any entity group it
+// touched was written to by someone else in the meantime, and the caller can retry it.
+
+import(
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/skypies/util/gcp/ds"
+)
+
+var(
+	ErrConcurrentTransaction = errors.New("concurrent transaction")
+	ErrNestedTransaction     = errors.New("transactions can't be nested")
+)
+
+type Transactor interface {
+	ds.DatastoreProvider
+
+	// The provider passed to f does all its reads and writes inside the transaction; its writes
+	// only happen if f returns nil and the commit succeeds. Queries must be ancestor queries.
+	RunInTransaction(ctx context.Context, f func(tx ds.DatastoreProvider) error) error
+}
+
+// How hard RunInTransaction tries; the delay doubles after each collision, with some jitter.
+var(
+	KMaxTransactionAttempts = 6
+	KTransactionRetryDelay  = 25 * time.Millisecond
+)
+
+// {{{ db.RunInTransaction
+
+// RunInTransaction calls f with a FlightDB whose backend is inside a transaction, retrying
+// from the top if it collides with a concurrent transaction (so f must be safe to rerun). If the
+// backend is not a Transactor, f is just called once, and gets no protection at all. Like
+// datastore's, these transactions don't nest; a txdb can't start another one.
+func (db *FlightDB)RunInTransaction(f func(txdb FlightDB) error) error {
+	if db.rollups != nil {
+		return fmt.Errorf("RunInTransaction: %w", ErrNestedTransaction)
+	}
+
+	t,ok := db.Backend.(Transactor)
+	if !ok {
+		return f(*db)
+	}
+
+	var err error
+	delay := KTransactionRetryDelay
+	for attempt:=1; attempt<=KMaxTransactionAttempts; attempt++ {
+		// Rollup updates and changes are held back until we know the transaction committed
+		var rollups *rollupBuffer
+		var changes *bufferSink
+		err = t.RunInTransaction(db.Ctx(), func(tx ds.DatastoreProvider) error {
+			txdb := *db
+			txdb.Backend = tx
+			rollups = &rollupBuffer{}
+			txdb.rollups = rollups
+			if db.Changes != nil {
+				changes = &bufferSink{}
+				txdb.Changes = changes
+			}
+			return f(txdb)
+		})
+		if err == nil {
+			db.applyRollups(rollups)
+			if changes != nil { db.publishChanges(changes.events...) }
+		}
+		if !errors.Is(err, ErrConcurrentTransaction) {
+			return err
+		}
+
+		db.Debugf("RunInTransaction: attempt %d collided, will retry", attempt)
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+	}
+
+	return fmt.Errorf("RunInTransaction: gave up after %d attempts: %w", KMaxTransactionAttempts, err)
+}
+
+// }}}
+
+// {{{ localTx
+
+// localTx is the transaction provider handed out by LocalDSProvider.RunInTransaction. Reads go
+// straight through to the provider, after noting the version of the entity group being read;
+// writes are buffered until commit, which fails if any of the noted versions have changed.
+type localTx struct {
+	*LocalDSProvider
+
+	seen        map[string]int64   // group versions (by root key), as of when the tx first touched them
+	writes    []*localEntity
+	deletes   []*LocalKey
+}
+
+func (tx *localTx)note(k *LocalKey) {
+	root := k.Root().Encode()
+	if _,exists := tx.seen[root]; exists { return }
+
+	tx.mu.RLock()
+	tx.seen[root] = tx.versions[root]
+	tx.mu.RUnlock()
+}
+
+func (tx *localTx)Get(ctx context.Context, keyer ds.Keyer, dst interface{}) error {
+	k,err := tx.unpackKeyer(keyer)
+	if err != nil { return err }
+	tx.note(k)
+	return tx.LocalDSProvider.Get(ctx, keyer, dst)
+}
+
+func (tx *localTx)GetMulti(ctx context.Context, keyers []ds.Keyer, dst interface{}) error {
+	for _,keyer := range keyers {
+		k,err := tx.unpackKeyer(keyer)
+		if err != nil { return err }
+		tx.note(k)
+	}
+	return tx.LocalDSProvider.GetMulti(ctx, keyers, dst)
+}
+
+func (tx *localTx)GetAll(ctx context.Context, q *ds.Query, dst interface{}) ([]ds.Keyer, error) {
+	ancestor,err := tx.unpackKeyer(q.AncestorKeyer)
+	if err != nil { return nil, err }
+	if ancestor == nil {
+		return nil, fmt.Errorf("GetAll{localtx}: only ancestor queries are allowed in transactions")
+	}
+	tx.note(ancestor)
+	return tx.LocalDSProvider.GetAll(ctx, q, dst)
+}
+
+func (tx *localTx)Put(ctx context.Context, keyer ds.Keyer, src interface{}) (ds.Keyer, error) {
+	ent,err := tx.newEntity(keyer, src)
+	if err != nil { return nil, fmt.Errorf("Put{localtx}: %v", err) }
+	tx.note(ent.Key)
+	tx.writes = append(tx.writes, ent)
+	return ent.Key, nil
+}
+
+func (tx *localTx)PutMulti(ctx context.Context, keyers []ds.Keyer, src interface{}) ([]ds.Keyer, error) {
+	// Re-implemented, so the provider's PutMulti doesn't bypass our Put
+	out := []ds.Keyer{}
+	err := forEachElem(src, len(keyers), func(i int, elem interface{}) error {
+		newKeyer,err := tx.Put(ctx, keyers[i], elem)
+		if err == nil { out = append(out, newKeyer) }
+		return err
+	})
+	if err != nil { return out, fmt.Errorf("PutMulti{localtx}: %v", err) }
+	return out, nil
+}
+
+func (tx *localTx)Delete(ctx context.Context, keyer ds.Keyer) error {
+	k,err := tx.unpackKeyer(keyer)
+	if err != nil { return err }
+	tx.note(k)
+	tx.deletes = append(tx.deletes, k)
+	return nil
+}
+
+func (tx *localTx)DeleteMulti(ctx context.Context, keyers []ds.Keyer) error {
+	for _,keyer := range keyers {
+		if err := tx.Delete(ctx, keyer); err != nil { return err }
+	}
+	return nil
+}
+
+// localTx would otherwise inherit this from the provider, and run a separate transaction that
+// could commit even if this one didn't.
+func (tx *localTx)RunInTransaction(ctx context.Context, f func(tx ds.DatastoreProvider) error) error {
+	return fmt.Errorf("RunInTransaction{localtx}: %w", ErrNestedTransaction)
+}
+
+func (tx *localTx)commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	for root,v := range tx.seen {
+		if tx.versions[root] != v {
+			return ErrConcurrentTransaction
+		}
+	}
+
+	for _,ent := range tx.writes {
+		if err := tx.putLocked(ent); err != nil { return fmt.Errorf("commit{localtx}: %v", err) }
+	}
+	for _,k := range tx.deletes {
+		if err := tx.deleteLocked(k); err != nil { return fmt.Errorf("commit{localtx}: %v", err) }
+	}
+
+	return nil
+}
+
+// }}}
+// {{{ p.RunInTransaction
+
+func (p *LocalDSProvider)RunInTransaction(ctx context.Context, f func(tx ds.DatastoreProvider) error) error {
+	tx := &localTx{LocalDSProvider:p, seen:map[string]int64{}}
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -15,10 +15,14 @@ type Flight struct {
 	Tracks map[string]*Track
 	Tags map[string]int
 	Waypoints map[string]time.Time
+	PhaseEvents []PhaseEvent // See f.AnalysePhases
+	Runways []RunwayMatch    // See f.AnalyseRunways
+	GoArounds []GoAround     // See f.AnalyseGoArounds
 	
 	// Internal fields
 	datastoreKey  string
 	lastUpdate    time.Time
+	airframeOverlaid []string // Which Airframe fields came from OverlayAirframe
 	DebugLog      string
 }
 
@@ -160,21 +164,15 @@ func (f Flight)ListTracks() []string {
 	return ret
 }
 func (f Flight)AnyTrackWithName() (Track, string) {
-	// This is kinda dumb. A track (and trackpoint) should know this key.
-	for _,name := range []string{"ADSB", "MLAT", "FA:TA", "FA:TZ", "fr24", "FOIA"} {
-		if f.HasTrack(name) { return *f.Tracks[name], name }
-	}
-	return Track{}, ""
+	name,t := f.TrackBySpec(TrackSpec{})
+	return t, name
 }
 func (f Flight)AnyTrack() Track {
 	t,_ := f.AnyTrackWithName()
 	return t
 }
 func (f Flight)PreferredTrack(pref []string) (string, Track) {
-	for _,name := range pref {
-		if f.HasTrack(name) { return name, *f.Tracks[name] }
-	}
-	return "", Track{}
+	return f.TrackBySpec(NewTrackSpec(pref...))
 }
 
 
@@ -190,6 +188,17 @@ func (f Flight)Times() (s,e time.Time) {
 	return
 }
 
+// StartTime is the earliest timestamp in any track, or the zero time if there are no
+// trackpoints. It is truncated to microseconds, as datastore would do that anyway.
+func (f Flight)StartTime() time.Time {
+	s := time.Time{}
+	for _,t := range f.Tracks {
+		if t == nil || len(*t) == 0 { continue }
+		if s.IsZero() || t.Start().Before(s) { s = t.Start() }
+	}
+	return s.Truncate(time.Microsecond)
+}
+
 func (f Flight)MidTime() time.Time {
 	s,e := f.Times()
 	dur := e.Sub(s) / 2.0
@@ -251,10 +260,21 @@ func (f *Flight)Analyse() (error, string) {
 	// useful, depending on how much track we have. Need a streaming solution.
 	f.AnalyseWaypoints()
 	f.TagCoarseFlightpathForSFO()  // SFO_S:, :SFO_S
+	f.AnalyseTrack()               // ~DESCENT, SFO:28R, GOAROUND
 	
 	return nil, ""
 }
 
+// AnalyseTrack redoes the analyses that need the whole track (and the destination); unlike the
+// rest of Analyse, they go stale whenever the track grows, or the schedule gets filled in, so
+// AddTrackFragment reruns them on every fragment.
+func (f *Flight)AnalyseTrack() {
+	t,events := f.PhasedTrack()    // Fused and classified just the once, for all of these:
+	f.AnalysePhases(events)        // ~DESCENT, ~DESCENT@EPICK
+	f.AnalyseRunways(DefaultRunwayTable, t) // SFO:28R, :SFO:28L
+	f.AnalyseGoArounds(t)          // GOAROUND
+}
+
 func NewFlightFromTrackFragment(frag *TrackFragment) *Flight {
 	f := BlankFlight()
 	f.Identity = Identity{
@@ -342,7 +362,8 @@ func (f *Flight)ArbitraryTimeslots(d time.Duration) []time.Time {
 	// smaller or larger timespans. So we pick the likely smallest in all cases, ADSB.	
 	// Note from 2017Q1: we're seeing more and more skypi flights with a mix of MLAT and ADSB
 	// tracks, that stack or overlap. We may need to merge into a synthetic 'Skypi' track
-	// or something.
+	// or something. (FuseTracks does that, but timeslots are part of a flight's identity, so
+	// switching over would need all the stored idspecs migrating.)
 	if f.HasTrack("ADSB") {
 		s,e := f.Tracks["ADSB"].Times()
 		return date.Timeslots(s,e,d)
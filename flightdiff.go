@@ -0,0 +1,109 @@
+package flightdb
+
+// A summary of how two versions of a flight differ; mostly for eyeballing what a batch job did
+// to a flight (see fgae's revision history).
+
+import(
+	"fmt"
+	"sort"
+)
+
+type FlightDiff struct {
+	Identity           []string       // One per changed field, e.g. "Callsign: UAL1 -> UAL2"
+	TagsAdded          []string
+	TagsRemoved        []string
+	WaypointsAdded     []string
+	WaypointsRemoved   []string
+	TrackPoints        map[string][2]int // Point counts, before & after, for tracks that changed
+}
+
+// {{{ DiffFlights
+
+func DiffFlights(a, b *Flight) FlightDiff {
+	d := FlightDiff{TrackPoints: map[string][2]int{}}
+
+	field := func(name, va, vb string) {
+		if va != vb { d.Identity = append(d.Identity, fmt.Sprintf("%s: %q -> %q", name, va, vb)) }
+	}
+	field("IcaoId",        a.IcaoId,                 b.IcaoId)
+	field("Callsign",      a.Callsign,               b.Callsign)
+	field("Flight",        a.BestFlightNumber(),     b.BestFlightNumber())
+	field("Origin",        a.Origin,                 b.Origin)
+	field("Destination",   a.Destination,            b.Destination)
+	field("Registration",  a.Airframe.Registration,  b.Airframe.Registration)
+	field("EquipmentType", a.Airframe.EquipmentType, b.Airframe.EquipmentType)
+
+	d.TagsAdded,d.TagsRemoved = diffStringLists(a.TagList(), b.TagList())
+	d.WaypointsAdded,d.WaypointsRemoved = diffStringLists(a.WaypointList(), b.WaypointList())
+
+	count := func(f *Flight, name string) int {
+		if t,exists := f.Tracks[name]; exists && t != nil { return len(*t) }
+		return 0
+	}
+	for _,f := range []*Flight{a,b} {
+		for name := range f.Tracks {
+			if na,nb := count(a,name), count(b,name); na != nb {
+				d.TrackPoints[name] = [2]int{na,nb}
+			}
+		}
+	}
+
+	return d
+}
+
+// diffStringLists returns the strings only in b (added), and only in a (removed).
+func diffStringLists(a, b []string) ([]string, []string) {
+	inA,inB := map[string]bool{}, map[string]bool{}
+	for _,s := range a { inA[s] = true }
+	for _,s := range b { inB[s] = true }
+
+	added,removed := []string{}, []string{}
+	for s := range inB {
+		if !inA[s] { added = append(added, s) }
+	}
+	for s := range inA {
+		if !inB[s] { removed = append(removed, s) }
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// }}}
+// {{{ d.IsEmpty, d.String
+
+func (d FlightDiff)IsEmpty() bool {
+	return len(d.Identity) == 0 && len(d.TagsAdded) == 0 && len(d.TagsRemoved) == 0 &&
+		len(d.WaypointsAdded) == 0 && len(d.WaypointsRemoved) == 0 && len(d.TrackPoints) == 0
+}
+
+func (d FlightDiff)String() string {
+	if d.IsEmpty() { return "(no differences)\n" }
+
+	str := ""
+	for _,s := range d.Identity {
+		str += fmt.Sprintf("identity  %s\n", s)
+	}
+	if len(d.TagsAdded) > 0        { str += fmt.Sprintf("tags      +%v\n", d.TagsAdded) }
+	if len(d.TagsRemoved) > 0      { str += fmt.Sprintf("tags      -%v\n", d.TagsRemoved) }
+	if len(d.WaypointsAdded) > 0   { str += fmt.Sprintf("waypoints +%v\n", d.WaypointsAdded) }
+	if len(d.WaypointsRemoved) > 0 { str += fmt.Sprintf("waypoints -%v\n", d.WaypointsRemoved) }
+
+	names := []string{}
+	for name := range d.TrackPoints { names = append(names, name) }
+	sort.Strings(names)
+	for _,name := range names {
+		str += fmt.Sprintf("track     %s: %d -> %d points\n", name, d.TrackPoints[name][0], d.TrackPoints[name][1])
+	}
+	return str
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,43 @@
+package flightdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffFlights(t *testing.T) {
+	a := BlankFlight()
+	a.IcaoId,a.Callsign = "A12345","UAL123"
+	a.SetTag("OLD")
+	a.SetTag("KEPT")
+	a.Waypoints["EPICK"] = time.Now()
+	a.Tracks["ADSB"] = &Track{}
+	*a.Tracks["ADSB"] = loadTrack(t1a)
+
+	if d := DiffFlights(&a, &a); !d.IsEmpty() {
+		t.Errorf("flight differed from itself:\n%s", d)
+	}
+
+	b := BlankFlight()
+	b.IcaoId,b.Callsign = "A12345","UAL124"
+	b.SetTag("KEPT")
+	b.SetTag("NEW")
+	b.Waypoints["EPICK"] = time.Now()
+	b.Waypoints["EDDYY"] = time.Now()
+	b.Tracks["ADSB"] = &Track{}
+	*b.Tracks["ADSB"] = append(loadTrack(t1a), loadTrack(t1b)...)
+
+	d := DiffFlights(&a, &b)
+	if len(d.Identity) != 1 {
+		t.Errorf("expected one identity change, saw %v", d.Identity)
+	}
+	if len(d.TagsAdded) != 1 || d.TagsAdded[0] != "NEW" || len(d.TagsRemoved) != 1 || d.TagsRemoved[0] != "OLD" {
+		t.Errorf("tags: saw +%v -%v", d.TagsAdded, d.TagsRemoved)
+	}
+	if len(d.WaypointsAdded) != 1 || d.WaypointsAdded[0] != "EDDYY" || len(d.WaypointsRemoved) != 0 {
+		t.Errorf("waypoints: saw +%v -%v", d.WaypointsAdded, d.WaypointsRemoved)
+	}
+	if counts,exists := d.TrackPoints["ADSB"]; !exists || counts[0] >= counts[1] {
+		t.Errorf("track points: saw %v", d.TrackPoints)
+	}
+}
@@ -46,12 +46,17 @@ func (t Track)MatchWaypoints(waypoints map[string]geo.Latlong) (map[string]time.
 // Find the point in a track at which we intersected waypoint.
 // Empty string means no match
 func (f Flight)AtWaypoint(wpName string) (string, int) {
+	return f.AtWaypointBySpec(wpName, TrackSpec{})
+}
+
+// AtWaypointBySpec looks for the waypoint in the tracks the spec picks out, in order of
+// preference; merging is ignored, as the index needs to be into a real track.
+func (f Flight)AtWaypointBySpec(wpName string, ts TrackSpec) (string, int) {
 	timeWaypoint,exists := f.Waypoints[wpName]
 	if !exists { return "", -1 }
 
-	// Really need a better approach to track selection, to avoid MLAT taking priority over ADSB, etc
-	for trackName,track := range f.Tracks {
-		if i := track.IndexAtTime(timeWaypoint); i >= 0 {
+	for _,trackName := range f.TrackNamesBySpec(ts) {
+		if i := f.Tracks[trackName].IndexAtTime(timeWaypoint); i >= 0 {
 			return trackName, i
 		}
 	}
@@ -0,0 +1,251 @@
+package flightdb
+
+// Flight phase classification: label each trackpoint as taxi, takeoff, climb, cruise, descent,
+// approach or landing, so that analyses don't all have to rebuild this from AngleOfInclination.
+//
+// Each point is classified from its altitude, groundspeed, and vertical rate (averaged over a
+// window of KPhaseRateWindow either side, so that quantized altitudes don't make it flicker).
+// Level flight is ambiguous, so it mostly carries on the previous phase; a level-off at 4000ft
+// is still part of the approach. Then any phase that lasts less than KPhaseMinDuration is
+// folded into the one before it.
+//
+// The altitudes are pressure altitudes, and assume a field elevation near sea level; this is
+// the Bay Area, after all.
+//
+// On a flight, f.AnalysePhases (given the events from f.PhasedTrack) records the phase changes as events, and sets index tags for
+// them: KPhaseTagPrefix+phase for each phase flown (e.g. "~DESCENT"), and for each phase that
+// started near a fix, KPhaseTagPrefix+phase+"@"+fix (e.g. "~DESCENT@EPICK"). So a query with
+// tags=~DESCENT@EPICK finds flights whose descent started above EPICK.
+
+import(
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/geo/sfo"
+)
+
+const KPhaseTagPrefix = "~" // Not "%", which would get unescaped out of CGI args
+
+type FlightPhase string
+const(
+	PhaseUnknown  FlightPhase = ""
+	PhaseTaxi     FlightPhase = "TAXI"
+	PhaseTakeoff  FlightPhase = "TAKEOFF"
+	PhaseClimb    FlightPhase = "CLIMB"
+	PhaseCruise   FlightPhase = "CRUISE"
+	PhaseDescent  FlightPhase = "DESCENT"
+	PhaseApproach FlightPhase = "APPROACH"
+	PhaseLanding  FlightPhase = "LANDING"
+)
+
+var(
+	KPhaseTaxiSpeed        = 50.0    // knots; slower than this, near the ground, is taxiing
+	KPhaseLevelRate        = 300.0   // feet/min; anything gentler than this is level flight
+	KPhaseLowAltitude      = 2000.0  // below this, we might be on the ground
+	KPhaseTakeoffAltitude  = 1500.0  // climbing below this is takeoff
+	KPhaseLandingAltitude  = 1000.0  // descending below this is landing
+	KPhaseApproachAltitude = 5000.0  // descending below this is approach
+	KPhaseCruiseAltitude   = 10000.0 // level above this is cruise
+
+	KPhaseRateWindow       = 30 * time.Second
+	KPhaseMinDuration      = 30 * time.Second
+
+	// Flights are analysed (phases, runways, go-arounds) using this track; see f.PhasedTrack.
+	PhaseTrackSpec         = TrackSpec{Fuse: true}
+)
+
+// A PhaseEvent is where a flight changed from one phase to another. The first event is the
+// phase the track started in, and has no Previous.
+type PhaseEvent struct {
+	Phase, Previous      FlightPhase
+	TimestampUTC         time.Time
+	geo.Latlong
+	Altitude             float64
+}
+
+// PhaseTag is the index tag for a phase, or (if fix isn't empty) for a phase starting near it.
+func PhaseTag(phase FlightPhase, fix string) string {
+	if fix == "" { return KPhaseTagPrefix + string(phase) }
+	return KPhaseTagPrefix + string(phase) + "@" + fix
+}
+
+// {{{ t.ClassifyPhases
+
+// ClassifyPhases labels each trackpoint with its flight phase (tp.Phase), and returns the
+// phase the track started in, followed by the places where the phase changed. Groundspeeds
+// should be populated (see t.PostProcess).
+func (t Track)ClassifyPhases() []PhaseEvent {
+	events := []PhaseEvent{}
+	if len(t) == 0 { return events }
+
+	rates := t.windowedVerticalRates(KPhaseRateWindow)
+	prev := PhaseUnknown
+	for i := range t {
+		t[i].Phase = classifyPhase(t[i], rates[i], prev)
+		prev = t[i].Phase
+	}
+
+	t.foldShortPhases(KPhaseMinDuration)
+
+	prev = PhaseUnknown
+	for i := range t {
+		if i == 0 || t[i].Phase != t[i-1].Phase {
+			events = append(events, PhaseEvent{
+				Phase: t[i].Phase,
+				Previous: prev,
+				TimestampUTC: t[i].TimestampUTC,
+				Latlong: t[i].Latlong,
+				Altitude: t[i].Altitude,
+			})
+			prev = t[i].Phase
+		}
+	}
+
+	return events
+}
+
+func classifyPhase(tp Trackpoint, rate float64, prev FlightPhase) FlightPhase {
+	low := tp.Altitude < KPhaseLowAltitude
+
+	switch {
+	case low && tp.GroundSpeed < KPhaseTaxiSpeed:
+		return PhaseTaxi
+
+	case rate > KPhaseLevelRate:
+		if tp.Altitude < KPhaseTakeoffAltitude { return PhaseTakeoff }
+		return PhaseClimb
+
+	case rate < -KPhaseLevelRate:
+		if tp.Altitude < KPhaseLandingAltitude { return PhaseLanding }
+		if tp.Altitude < KPhaseApproachAltitude { return PhaseApproach }
+		return PhaseDescent
+
+	case tp.Altitude >= KPhaseCruiseAltitude:
+		return PhaseCruise
+
+	case low && (prev == PhaseTaxi || prev == PhaseTakeoff):
+		return PhaseTakeoff // The takeoff roll
+	case low && (prev == PhaseApproach || prev == PhaseLanding):
+		return PhaseLanding // The rollout
+	case !low && prev == PhaseUnknown:
+		return PhaseCruise
+	}
+
+	return prev
+}
+
+// windowedVerticalRates returns the average vertical rate around each point, over the points
+// within d either side. If there are no other points nearby, it uses the point's own rate.
+func (t Track)windowedVerticalRates(d time.Duration) []float64 {
+	rates := make([]float64, len(t))
+	k,j := 0,0
+	for i := range t {
+		for t[i].TimestampUTC.Sub(t[k].TimestampUTC) > d { k++ }
+		if j < i { j = i }
+		for j+1 < len(t) && t[j+1].TimestampUTC.Sub(t[i].TimestampUTC) <= d { j++ }
+
+		if span := t[j].TimestampUTC.Sub(t[k].TimestampUTC); span > 0 {
+			rates[i] = (t[j].Altitude - t[k].Altitude) / span.Minutes()
+		} else {
+			rates[i] = t[i].VerticalRate
+		}
+	}
+	return rates
+}
+
+// foldShortPhases relabels any phase that lasts less than d (other than at the very start or
+// end of the track) as the phase before it.
+func (t Track)foldShortPhases(d time.Duration) {
+	for {
+		runs := [][2]int{}
+		for i := range t {
+			if i == 0 || t[i].Phase != t[i-1].Phase {
+				runs = append(runs, [2]int{i,i})
+			} else {
+				runs[len(runs)-1][1] = i
+			}
+		}
+
+		folded := false
+		for n:=1; n<len(runs)-1; n++ {
+			i,j := runs[n][0], runs[n][1]
+			if t[j+1].TimestampUTC.Sub(t[i].TimestampUTC) < d {
+				for k:=i; k<=j; k++ { t[k].Phase = t[i-1].Phase }
+				folded = true
+				break
+			}
+		}
+		if !folded { return }
+	}
+}
+
+// }}}
+
+// {{{ f.AnalysePhases
+
+// AnalysePhases records the phase changes (as returned by f.PhasedTrack) in f.PhaseEvents, and
+// resets the phase tags to match.
+func (f *Flight)AnalysePhases(events []PhaseEvent) {
+	for tag := range f.Tags {
+		if strings.HasPrefix(tag, KPhaseTagPrefix) { f.DropTag(tag) }
+	}
+	f.PhaseEvents = events
+
+	for n,ev := range f.PhaseEvents {
+		if ev.Phase == PhaseUnknown { continue }
+		f.SetTag(PhaseTag(ev.Phase, ""))
+		if n == 0 { continue } // Not a real start; it's just where the track starts
+		if fix := nearestFix(ev.Latlong, KWaypointSnapKM); fix != "" {
+			f.SetTag(PhaseTag(ev.Phase, fix))
+		}
+	}
+}
+
+// PhasedTrack returns a post-processed copy of the flight's PhaseTrackSpec track, with its
+// phases labelled, and the phase changes. It's not cheap, so Analyse builds it just the once,
+// and hands it to each of the analyses that need it.
+func (f Flight)PhasedTrack() (Track, []PhaseEvent) {
+	_,t := f.TrackBySpec(PhaseTrackSpec)
+	t = append(Track{}, t...) // Don't label the flight's own trackpoints
+	t.PostProcess()
+	return t, t.ClassifyPhases()
+}
+
+// nearestFix returns the name of the closest fix within maxKM, or "" if there isn't one.
+func nearestFix(pos geo.Latlong, maxKM float64) string {
+	names := []string{}
+	for name := range sfo.KFixes { names = append(names, name) }
+	sort.Strings(names) // Deterministic, in case of ties
+
+	best,bestKM := "", maxKM
+	for _,name := range names {
+		if km := pos.DistKM(sfo.KFixes[name]); km <= bestKM { best,bestKM = name,km }
+	}
+	return best
+}
+
+// }}}
+// {{{ f.PhaseAt
+
+// PhaseAt returns the phase the flight was in at the given time, according to f.PhaseEvents.
+// Before the track starts, we don't know.
+func (f Flight)PhaseAt(tm time.Time) FlightPhase {
+	phase := PhaseUnknown
+	for _,ev := range f.PhaseEvents {
+		if ev.TimestampUTC.After(tm) { break }
+		phase = ev.Phase
+	}
+	return phase
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
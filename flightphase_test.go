@@ -0,0 +1,100 @@
+package flightdb
+
+import(
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/geo/sfo"
+)
+
+// A whole flight, at 5s intervals: taxi, takeoff, climb, cruise, descent, approach, landing,
+// taxi. The cruise ends (and the descent starts) around point 192; the takeoff roll is points
+// 24-29, and the landing (through to touchdown) is points 312-354.
+func phaseTestTrack() Track {
+	t := Track{}
+	s := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	alt := 10.0
+	add := func(n int, dAlt, gs float64) {
+		for i:=0; i<n; i++ {
+			alt += dAlt
+			if alt < 10 { alt = 10 }
+			t = append(t, Trackpoint{
+				TimestampUTC: s.Add(time.Duration(len(t)) * 5 * time.Second),
+				Latlong: geo.Latlong{Lat:36.0, Long:-121.0},
+				Altitude: float64(int(alt/100) * 100), // Quantized, like real data
+				GroundSpeed: gs,
+			})
+		}
+	}
+
+	add(24,    0,  15)  // Two minutes of taxi
+	add(6,     0, 140)  // Takeoff roll
+	add(12,  200, 170)  // 2400fpm, up to 2400ft
+	add(90,  200, 280)  // Climb to 20400ft
+	add(60,    0, 450)  // Five minutes of cruise
+	add(90, -170, 300)  // 2040fpm, down to ~5100ft
+	add(30,  -70, 180)  // 840fpm on approach, down to ~3000ft
+	add(43,  -70, 140)  // ... through to touchdown
+	add(6,     0, 100)  // Rollout
+	add(24,    0,  15)  // Taxi to the gate
+
+	return t
+}
+
+func phasedTrack(f Flight) Track {
+	t,_ := f.PhasedTrack()
+	return t
+}
+
+func TestClassifyPhases(t *testing.T) {
+	tr := phaseTestTrack()
+	events := tr.ClassifyPhases()
+
+	got := []string{}
+	for _,ev := range events { got = append(got, string(ev.Phase)) }
+	expected := "TAXI TAKEOFF CLIMB CRUISE DESCENT APPROACH LANDING TAXI"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("phases wrong:\n got: %s\nwant: %s", strings.Join(got, " "), expected)
+	}
+
+	for _,tp := range tr {
+		if tp.Phase == PhaseUnknown { t.Errorf("unlabelled point: %s", tp) }
+	}
+	if len(events) > 1 && events[1].Previous != PhaseTaxi {
+		t.Errorf("takeoff event has previous %q", events[1].Previous)
+	}
+}
+
+func TestAnalysePhases(t *testing.T) {
+	fix := "EPICK"
+	pos,exists := sfo.KFixes[fix]
+	if !exists { t.Skipf("no %s fix", fix) }
+
+	tr := phaseTestTrack()
+	for i:=180; i<=200; i++ { tr[i].Latlong = pos } // Descend from over the fix
+	f := BlankFlight()
+	f.Tracks["ADSB"] = &tr
+	f.SetTag(PhaseTag(PhaseClimb, "STALE")) // Should get dropped
+
+	_,events := f.PhasedTrack()
+	f.AnalysePhases(events)
+
+	for _,tag := range []string{"~TAXI", "~CRUISE", "~DESCENT", "~DESCENT@"+fix, "~LANDING"} {
+		if !f.HasTag(tag) { t.Errorf("missing tag %q; have %v", tag, f.TagList()) }
+	}
+	if f.HasTag("~CLIMB@STALE") { t.Errorf("stale tag survived: %v", f.TagList()) }
+	if f.HasTag("~TAXI@"+fix) { t.Errorf("initial phase got a fix tag: %v", f.TagList()) }
+
+	for _,tp := range tr {
+		if tp.Phase != PhaseUnknown { t.Fatalf("flight's own trackpoints were labelled") }
+	}
+
+	if p := f.PhaseAt(tr[170].TimestampUTC); p != PhaseCruise {
+		t.Errorf("PhaseAt mid-flight: got %q", p)
+	}
+	if p := f.PhaseAt(tr[0].TimestampUTC.Add(-time.Minute)); p != PhaseUnknown {
+		t.Errorf("PhaseAt before track: got %q", p)
+	}
+}
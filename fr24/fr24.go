@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/skypies/adsb"
@@ -221,6 +222,8 @@ func (db *Fr24)ParseCurrentList(body []byte) ([]fdb.FlightSnapshot, error) {
 			},
 			Trackpoint: fdb.Trackpoint{
 				DataSource:    "fr24",
+				DataSystem:    receiverToDataSystem(v[8].(string)),
+				DataProvider:  fdb.DPFR24,
 				ReceiverName:  v[8].(string),  // e.g. "T-MLAT", or "T-F5M"
 				TimestampUTC:  time.Unix(int64(v[11].(float64)), 0).UTC(),
 				Heading:       v[4].(float64),
@@ -243,6 +246,13 @@ func (db *Fr24)ParseCurrentList(body []byte) ([]fdb.FlightSnapshot, error) {
 	return ret,nil
 }
 
+// The receiver names for multilaterated positions look like "T-MLAT2"; the rest (e.g. "T-F5M")
+// are individual feeders, which could be hearing ADS-B, or something else entirely.
+func receiverToDataSystem(receiver string) fdb.DataSystem {
+	if strings.Contains(receiver, "MLAT") { return fdb.DSMLAT }
+	return fdb.DSUnknown
+}
+
 // }}}
 // {{{ db.ParseCurrentDetails
 
@@ -285,6 +295,8 @@ func (db *Fr24)ParsePlaybackTrack(body []byte) (*fdb.Flight, error) {
 	for _,frame := range r.Result.Response.Data.Flight.Track {
 		track = append(track, fdb.Trackpoint{
 			DataSource: "fr24",
+			DataSystem: fdb.DSUnknown, // The playback frames don't say
+			DataProvider: fdb.DPFR24,
 			TimestampUTC: time.Unix(int64(frame.Timestamp),0).UTC(),
 			Heading: float64(frame.Heading),
 			Latlong: geo.Latlong{float64(frame.Latitude),float64(frame.Longitude)},
@@ -487,7 +499,7 @@ func SnapshotToAircraftData(fs fdb.FlightSnapshot) airspace.AircraftData {
 	af.Icao24 = string(fs.IcaoId)
 
 	// Hack up some fake 'message types' ...
-	if fs.Trackpoint.DataSource == "fr24" {
+	if fs.Trackpoint.GetDataProvider() == fdb.DPFR24 {
 		if tf5m := regexp.MustCompile("T-F5M").FindString(msg.ReceiverName); tf5m != "" {
 			msg.Type = "T-F5M"
 		} else if mlat := regexp.MustCompile("MLAT").FindString(msg.ReceiverName); mlat != "" {
@@ -500,7 +512,7 @@ func SnapshotToAircraftData(fs fdb.FlightSnapshot) airspace.AircraftData {
 		Airframe: af,
 		Schedule: fs.Schedule,
 		NumMessagesSeen: 1,
-		Source: fs.Trackpoint.DataSource,
+		Source: string(fs.Trackpoint.GetDataProvider()),
 	}
 }
 
@@ -122,6 +122,8 @@ func flightData2snapshot(in *LiveFeedResponse_FlightData) fdb.FlightSnapshot {
 	out := fdb.FlightSnapshot{
 		Trackpoint: fdb.Trackpoint{
 			DataSource:    "fr24",
+			DataSystem:    grpcSourceToDataSystem(in.GetSource()),
+			DataProvider:  fdb.DPFR24,
 			ReceiverName:  in.GetSource().String(),
 			TimestampUTC:  time.Unix(int64(in.Timestamp), 0).UTC(),
 			Heading:       float64(in.Heading),
@@ -152,6 +154,15 @@ func flightData2snapshot(in *LiveFeedResponse_FlightData) fdb.FlightSnapshot {
 	return out
 }
 
+func grpcSourceToDataSystem(src DataSource) fdb.DataSystem {
+	switch src {
+	case DataSource_ADSB: return fdb.DSADSB
+	case DataSource_MLAT: return fdb.DSMLAT
+	case DataSource_FAA:  return fdb.DSRadar
+	}
+	return fdb.DSUnknown
+}
+
 func flightData2flightIdentity(in *LiveFeedResponse_FlightData, id *fdb.Identity) {
 	if id.ForeignKeys == nil { id.ForeignKeys = map[string]string{} }
 	id.ForeignKeys["fr24"] = fmt.Sprintf("%d", in.Flightid)
@@ -0,0 +1,233 @@
+package flightdb
+
+// Spatial index cells, so that datastore queries can discard flights that never went near an
+// area before any blobs get fetched and decoded. Cells are geohashes; each flight is indexed at a
+// few precisions, and a query picks the finest precision that keeps its cell list short enough
+// for a single 'in' filter.
+//
+// Every cell is an index entry, so a flight with a huge footprint (a long-haul track can cross
+// thousands of cells) would get expensive, and could run into datastore's limit on index entries
+// per entity. Flights with more than KMaxIndexCells are just indexed as KIndexCellsOverflow,
+// which every cell query also asks for.
+
+import(
+	"math"
+	"sort"
+
+	"github.com/skypies/geo"
+)
+
+const kGeohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Precisions we index at. A 2-char geohash is ~1250x625km, 3-char ~156x156km, 4-char ~39x20km.
+// Because geohashes nest, the coarser cells are just prefixes of the finest.
+var KIndexCellPrecisions = []int{2,3,4}
+
+// Datastore won't accept more than 30 values in an 'in' filter; one of them is always
+// KIndexCellsOverflow.
+const KMaxQueryCells = 29
+
+// Flights with more cells than this are indexed as KIndexCellsOverflow instead.
+var KMaxIndexCells = 1000
+
+const KIndexCellsOverflow = "*" // Not a geohash character
+
+// {{{ geohash
+
+func geohash(ll geo.Latlong, precision int) string {
+	latR  := [2]float64{-90, 90}
+	longR := [2]float64{-180, 180}
+	out := make([]byte, 0, precision)
+
+	bit,ch,even := 0,0,true
+	for len(out) < precision {
+		r,v := &latR,ll.Lat
+		if even { r,v = &longR,ll.Long }
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			out = append(out, kGeohashAlphabet[ch])
+			bit,ch = 0,0
+		}
+	}
+	return string(out)
+}
+
+// geohashCellSize returns the height and width, in degrees, of a cell at the given precision.
+func geohashCellSize(precision int) (float64, float64) {
+	nBits := 5 * precision
+	longBits := (nBits+1) / 2
+	latBits := nBits / 2
+	return 180.0 / math.Exp2(float64(latBits)), 360.0 / math.Exp2(float64(longBits))
+}
+
+// }}}
+
+// {{{ t.IndexCells
+
+// IndexCells returns the cells (at the given precision) that the track passes through. We walk
+// along the line between each pair of trackpoints in steps of less than half a cell, so that
+// sparse tracks don't skip over cells; the result is sorted.
+func (t Track)IndexCells(precision int) []string {
+	h,w := geohashCellSize(precision)
+	step := math.Min(h,w) / 2.0
+
+	seen := map[string]bool{}
+	for i,tp := range t {
+		seen[geohash(tp.Latlong, precision)] = true
+		if i == 0 { continue }
+
+		prev := t[i-1].Latlong
+		dist := math.Max(math.Abs(tp.Lat-prev.Lat), math.Abs(tp.Long-prev.Long))
+		nSteps := int(dist / step)
+		for j:=1; j<=nSteps; j++ {
+			seen[geohash(prev.InterpolateTo(tp.Latlong, float64(j)/float64(nSteps+1)), precision)] = true
+		}
+	}
+
+	cells := []string{}
+	for cell := range seen {
+		cells = append(cells, cell)
+	}
+	sort.Strings(cells)
+	return cells
+}
+
+// }}}
+// {{{ f.IndexCellList
+
+// IndexCellList is the union of cells across all the flight's tracks, at all indexed precisions;
+// or, if there are more than KMaxIndexCells of them, just KIndexCellsOverflow.
+func (f *Flight)IndexCellList() []string {
+	finest := KIndexCellPrecisions[len(KIndexCellPrecisions)-1]
+
+	seen := map[string]bool{}
+	for _,t := range f.Tracks {
+		for _,cell := range t.IndexCells(finest) {
+			for _,p := range KIndexCellPrecisions {
+				seen[cell[:p]] = true
+			}
+		}
+	}
+
+	if len(seen) > KMaxIndexCells {
+		return []string{KIndexCellsOverflow}
+	}
+
+	cells := []string{}
+	for cell := range seen {
+		cells = append(cells, cell)
+	}
+	sort.Strings(cells)
+	return cells
+}
+
+// }}}
+
+// {{{ boxCells
+
+// boxCells lists the cells at the given precision that overlap the box. If there would be more
+// than max of them, it gives up and returns nil. We don't handle boxes that straddle the
+// antimeridian.
+func boxCells(box geo.LatlongBox, precision, max int) []string {
+	h,w := geohashCellSize(precision)
+
+	lat0,lat1 := math.Floor((box.SW.Lat+90)/h), math.Floor((box.NE.Lat+90)/h)
+	long0,long1 := math.Floor((box.SW.Long+180)/w), math.Floor((box.NE.Long+180)/w)
+	lat1,long1 = math.Min(lat1, 180/h - 1), math.Min(long1, 360/w - 1) // N pole, antimeridian
+	if (lat1-lat0+1) * (long1-long0+1) > float64(max) { return nil }
+
+	cells := []string{}
+	for i:=lat0; i<=lat1; i++ {
+		for j:=long0; j<=long1; j++ {
+			center := geo.Latlong{Lat: (i+0.5)*h - 90, Long: (j+0.5)*w - 180}
+			cells = append(cells, geohash(center, precision))
+		}
+	}
+	return cells
+}
+
+// }}}
+// {{{ QueryCellsForBoxes
+
+// QueryCellsForBoxes returns a list of cells such that any track passing through any of the
+// boxes will have at least one of the cells in its IndexCellList. It uses the finest precision
+// that needs no more than KMaxQueryCells; if even the coarsest precision needs more than that,
+// it returns nil (i.e. the boxes are too big to be worth filtering on).
+func QueryCellsForBoxes(boxes []geo.LatlongBox) []string {
+	if len(boxes) == 0 { return nil }
+
+	for i:=len(KIndexCellPrecisions)-1; i>=0; i-- {
+		seen := map[string]bool{}
+		fits := true
+		for _,box := range boxes {
+			cells := boxCells(box, KIndexCellPrecisions[i], KMaxQueryCells)
+			if cells == nil { fits = false; break }
+			for _,cell := range cells {
+				seen[cell] = true
+			}
+			if len(seen) > KMaxQueryCells { fits = false; break }
+		}
+		if !fits { continue }
+
+		cells := []string{}
+		for cell := range seen {
+			cells = append(cells, cell)
+		}
+		sort.Strings(cells)
+		return cells
+	}
+
+	return nil
+}
+
+// }}}
+// {{{ grs.QueryCells
+
+// QueryCells returns the cells a flight would have to have at least one of, in order to
+// possibly satisfy the restrictor set; nil means no cell filtering is possible.
+//
+// For 'all' logic, a flight has to go near every non-exclusion restrictor, so we can pick any
+// one of them; we pick the one with the shortest cell list. For 'any' logic, the flight might
+// satisfy any of the restrictors; if one of them is an exclusion (which a flight satisfies by
+// staying away), then any flight might match.
+func (grs GeoRestrictorSet)QueryCells() []string {
+	var best []string
+
+	switch grs.Logic {
+	case CombinationLogicAll:
+		for _,gr := range grs.R {
+			if gr.IsExclusion() { continue }
+			cells := QueryCellsForBoxes([]geo.LatlongBox{gr.BoundingBox()})
+			if cells == nil { continue }
+			if best == nil || len(cells) < len(best) { best = cells }
+		}
+
+	case CombinationLogicAny:
+		boxes := []geo.LatlongBox{}
+		for _,gr := range grs.R {
+			if gr.IsExclusion() { return nil }
+			boxes = append(boxes, gr.BoundingBox())
+		}
+		best = QueryCellsForBoxes(boxes)
+	}
+
+	return best
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
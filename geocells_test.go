@@ -0,0 +1,87 @@
+package flightdb
+
+import (
+	"testing"
+
+	"github.com/skypies/geo"
+)
+
+func TestGeohash(t *testing.T) {
+	tests := []struct{
+		ll       geo.Latlong
+		expected string
+	}{
+		{geo.Latlong{Lat:37.7749, Long:-122.4194}, "9q8yyk8y"},
+		{geo.Latlong{Lat:51.5074, Long:-0.1278}, "gcpvj0du"},
+		{geo.Latlong{Lat:57.64911, Long:10.40744}, "u4pruydqqvj"},
+	}
+
+	for i,test := range tests {
+		if actual := geohash(test.ll, len(test.expected)); actual != test.expected {
+			t.Errorf("[%d] %s: expected %s, saw %s", i, test.ll, test.expected, actual)
+		}
+	}
+}
+
+func TestIndexCells(t *testing.T) {
+	// Two points ~200km apart; the cells in between should still be indexed
+	sfo := geo.Latlong{Lat:37.6189, Long:-122.3750}
+	rno := geo.Latlong{Lat:39.4991, Long:-119.7681}
+	f := BlankFlight()
+	f.Tracks["test"] = &Track{Trackpoint{Latlong:sfo}, Trackpoint{Latlong:rno}}
+	cells := map[string]bool{}
+	for _,cell := range f.IndexCellList() {
+		cells[cell] = true
+	}
+
+	// Small boxes along the route, and a large one covering it all
+	boxes := []geo.LatlongBox{
+		sfo.Box(2,2),
+		rno.Box(2,2),
+		sfo.InterpolateTo(rno, 0.5).Box(2,2),
+		sfo.InterpolateTo(rno, 0.3).Box(40,40),
+		sfo.BoxTo(rno),
+	}
+	for i,box := range boxes {
+		queryCells := QueryCellsForBoxes([]geo.LatlongBox{box})
+		if len(queryCells) == 0 || len(queryCells) > KMaxQueryCells {
+			t.Errorf("[%d] box %s: bad query cells %v", i, box, queryCells)
+		}
+		found := false
+		for _,cell := range queryCells {
+			if cells[cell] { found = true }
+		}
+		if !found {
+			t.Errorf("[%d] box %s: query cells %v not in flight cells", i, box, queryCells)
+		}
+	}
+
+	// Somewhere the flight didn't go
+	lax := geo.Latlong{Lat:33.9416, Long:-118.4085}
+	for _,cell := range QueryCellsForBoxes([]geo.LatlongBox{lax.Box(2,2)}) {
+		if cells[cell] { t.Errorf("LAX cell %s was in flight cells", cell) }
+	}
+}
+
+func TestIndexCellsOverflow(t *testing.T) {
+	sfo := geo.Latlong{Lat:37.6189, Long:-122.3750}
+	lhr := geo.Latlong{Lat:51.4700, Long:-0.4543}
+	f := BlankFlight()
+	f.Tracks["test"] = &Track{Trackpoint{Latlong:sfo}, Trackpoint{Latlong:lhr}}
+
+	cells := f.IndexCellList()
+	if len(cells) < 300 || len(cells) > KMaxIndexCells {
+		t.Errorf("long-haul flight: saw %d cells", len(cells))
+	}
+
+	defer func(n int) { KMaxIndexCells = n }(KMaxIndexCells)
+	KMaxIndexCells = len(cells) - 1
+	if cells := f.IndexCellList(); len(cells) != 1 || cells[0] != KIndexCellsOverflow {
+		t.Errorf("overflowing flight: expected just the overflow cell, saw %d cells", len(cells))
+	}
+
+	f.Tracks["test"] = &Track{Trackpoint{Latlong:sfo}, Trackpoint{Latlong:sfo.MoveKM(90, 50)}}
+	if cells := f.IndexCellList(); len(cells) < 3 || cells[0] == KIndexCellsOverflow {
+		t.Errorf("short flight: saw %d cells", len(cells))
+	}
+}
@@ -8,6 +8,7 @@ import(
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/skypies/geo"
 	"github.com/skypies/geo/sfo"
@@ -33,6 +34,12 @@ type GeoRestrictorSet struct {
 	Tags     []string
 
 	R        []geo.Restrictor
+
+	// Sets are owned (and can only be edited) by User, but can be shared with others
+	Public       bool      // Anyone can view and use it
+	SharedWith []string    // These users (by email) can view and use it
+	Version      int       // Bumped every time the set is persisted
+	Updated      time.Time
 	
 	DSKey      string
 }
@@ -92,6 +99,21 @@ func (grs GeoRestrictorSet)IsAdhoc() bool {
 	return (grs.Name == "ad-hoc")
 }
 
+// }}}
+// {{{ grs.CanView, CanEdit
+
+func (grs GeoRestrictorSet)CanEdit(user string) bool {
+	return strings.EqualFold(grs.User, user)
+}
+
+func (grs GeoRestrictorSet)CanView(user string) bool {
+	if grs.Public || grs.CanEdit(user) { return true }
+	for _,u := range grs.SharedWith {
+		if strings.EqualFold(u, user) { return true }
+	}
+	return false
+}
+
 // }}}
 
 // {{{ GeoRestrictorIntoParams
@@ -231,6 +253,9 @@ type IndexedRestrictorSetBlob struct {
 	Name           string
 	Tags         []string
 	User           string
+	Public         bool
+	SharedWith   []string    // Lowercased
+	Version        int       `datastore:",noindex"`
 }
 
 // {{{ grs.ToBlob
@@ -246,10 +271,18 @@ func (grs *GeoRestrictorSet)ToBlob() (*IndexedRestrictorSetBlob, error) {
 
 	sort.Strings(grs.Tags)
 	
+	sharedWith := []string{}
+	for _,u := range grs.SharedWith {
+		sharedWith = append(sharedWith, strings.ToLower(u))
+	}
+
 	return &IndexedRestrictorSetBlob{
 		Blob: buf.Bytes(),
 		Tags: grs.Tags,
 		User: grs.User,
+		Public: grs.Public,
+		SharedWith: sharedWith,
+		Version: grs.Version,
 	}, nil
 }
 
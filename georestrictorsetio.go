@@ -0,0 +1,391 @@
+package flightdb
+
+// Import/export of GeoRestrictorSets as GeoJSON and KML, so that sets can be exchanged with
+// other people (and other tools). Each restrictor becomes one feature (or placemark):
+//  * squarebox:      a Point (the center)
+//  * verticalplane:  a LineString of two points
+//  * polygon:        a Polygon
+// The restrictor's other fields go into the feature's properties (or ExtendedData). Features
+// without a "restrictor" property (e.g. drawn in some other tool) are taken from their geometry.
+
+import(
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skypies/geo"
+)
+
+// {{{ restrictorShape
+
+// A restrictorShape is a restrictor flattened out into some points, and everything else.
+type restrictorShape struct {
+	Props    restrictorProps
+	Points []geo.NamedLatlong
+}
+
+type restrictorProps struct {
+	Restrictor   string   `json:"restrictor"`
+	Names      []string   `json:"names,omitempty"` // Names of the points (e.g. waypoints), if any
+	SideKM       float64  `json:"sidekm,omitempty"`
+	AltitudeMin  int64    `json:"altmin,omitempty"`
+	AltitudeMax  int64    `json:"altmax,omitempty"`
+	IsExcluding  bool     `json:"excluding,omitempty"`
+}
+
+func restrictorToShape(gr geo.Restrictor) (restrictorShape, error) {
+	rs := restrictorShape{}
+
+	switch t := gr.(type) {
+	case geo.SquareBoxRestriction:
+		rs.Props = restrictorProps{Restrictor:"squarebox", SideKM:t.SideKM,
+			AltitudeMin:t.AltitudeMin, AltitudeMax:t.AltitudeMax, IsExcluding:t.IsExcluding}
+		rs.Points = []geo.NamedLatlong{t.NamedLatlong}
+	case geo.VerticalPlaneRestriction:
+		rs.Props = restrictorProps{Restrictor:"verticalplane",
+			AltitudeMin:t.AltitudeMin, AltitudeMax:t.AltitudeMax, IsExcluding:t.IsExcluding}
+		rs.Points = []geo.NamedLatlong{t.Start, t.End}
+	case geo.PolygonRestriction:
+		rs.Props = restrictorProps{Restrictor:"polygon",
+			AltitudeMin:t.AltitudeMin, AltitudeMax:t.AltitudeMax, IsExcluding:t.IsExcluding}
+		for _,pos := range t.GetPoints() {
+			rs.Points = append(rs.Points, geo.NamedLatlong{Latlong:pos})
+		}
+	default:
+		return rs, fmt.Errorf("restrictorToShape: unknown restrictor %T", gr)
+	}
+
+	for _,pt := range rs.Points {
+		if pt.Name != "" {
+			for _,pt := range rs.Points { rs.Props.Names = append(rs.Props.Names, pt.Name) }
+			break
+		}
+	}
+
+	return rs, nil
+}
+
+// geometry is the GeoJSON geometry type; it's used to guess the restrictor if there isn't one.
+func (rs restrictorShape)ToRestrictor(geometry string) (geo.Restrictor, error) {
+	for i,name := range rs.Props.Names {
+		if i < len(rs.Points) { rs.Points[i].Name = name }
+	}
+
+	kind := rs.Props.Restrictor
+	if kind == "" {
+		kind = map[string]string{
+			"Point":"squarebox",
+			"LineString":"verticalplane",
+			"Polygon":"polygon",
+		}[geometry]
+	}
+
+	p := rs.Props
+	switch kind {
+	case "squarebox":
+		if len(rs.Points) != 1 { return nil, fmt.Errorf("squarebox needs one point") }
+		if p.SideKM <= 0 { return nil, fmt.Errorf("squarebox needs a sidekm") }
+		return geo.SquareBoxRestriction{Debugger:new(geo.DebugLog), NamedLatlong:rs.Points[0],
+			SideKM:p.SideKM, AltitudeMin:p.AltitudeMin, AltitudeMax:p.AltitudeMax,
+			IsExcluding:p.IsExcluding}, nil
+
+	case "verticalplane":
+		if len(rs.Points) != 2 { return nil, fmt.Errorf("verticalplane needs two points") }
+		return geo.VerticalPlaneRestriction{Debugger:new(geo.DebugLog),
+			Start:rs.Points[0], End:rs.Points[1],
+			AltitudeMin:p.AltitudeMin, AltitudeMax:p.AltitudeMax, IsExcluding:p.IsExcluding}, nil
+
+	case "polygon":
+		pts := rs.Points
+		if n := len(pts); n > 1 && pts[0].Latlong.Equal(pts[n-1].Latlong) {
+			pts = pts[:n-1] // Rings are closed in GeoJSON and KML, but not in geo.Polygon
+		}
+		if len(pts) < 3 { return nil, fmt.Errorf("polygon needs at least three points") }
+		poly := geo.NewPolygon()
+		for _,pt := range pts { poly.AddPoint(pt.Latlong) }
+		return geo.PolygonRestriction{Debugger:new(geo.DebugLog), Polygon:poly,
+			AltitudeMin:p.AltitudeMin, AltitudeMax:p.AltitudeMax, IsExcluding:p.IsExcluding}, nil
+	}
+
+	return nil, fmt.Errorf("unknown restrictor '%s' (geometry '%s')", kind, geometry)
+}
+
+func parseCombinationLogic(s string) RestrictorCombinationLogic {
+	if strings.ToLower(s) == "any" { return CombinationLogicAny }
+	return CombinationLogicAll
+}
+
+// }}}
+
+// {{{ grs.ToGeoJSON
+
+type geoJSONCollection struct {
+	Type         string           `json:"type"`
+	Name         string           `json:"name,omitempty"`
+	Logic        string           `json:"logic,omitempty"`
+	Tags       []string           `json:"tags,omitempty"`
+	Features   []geoJSONFeature   `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type         string           `json:"type"`
+	Geometry     geoJSONGeometry  `json:"geometry"`
+	Properties   restrictorProps  `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type         string           `json:"type"`
+	Coordinates  json.RawMessage  `json:"coordinates"`
+}
+
+// ToGeoJSON renders the set as a FeatureCollection; the set's name, logic and tags are
+// foreign members of the collection.
+func (grs GeoRestrictorSet)ToGeoJSON() ([]byte, error) {
+	fc := geoJSONCollection{
+		Type: "FeatureCollection",
+		Name: grs.Name,
+		Logic: grs.Logic.String(),
+		Tags: grs.Tags,
+		Features: []geoJSONFeature{},
+	}
+
+	for _,gr := range grs.R {
+		rs,err := restrictorToShape(gr)
+		if err != nil { return nil, err }
+
+		pos := [][]float64{}
+		for _,pt := range rs.Points { pos = append(pos, []float64{pt.Long, pt.Lat}) }
+
+		var geomType string
+		var coords interface{}
+		switch rs.Props.Restrictor {
+		case "squarebox":     geomType,coords = "Point", pos[0]
+		case "verticalplane": geomType,coords = "LineString", pos
+		case "polygon":       geomType,coords = "Polygon", [][][]float64{append(pos, pos[0])}
+		}
+
+		b,err := json.Marshal(coords)
+		if err != nil { return nil, err }
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{Type:geomType, Coordinates:b},
+			Properties: rs.Props,
+		})
+	}
+
+	return json.MarshalIndent(fc, "", "  ")
+}
+
+// }}}
+// {{{ GeoRestrictorSetFromGeoJSON
+
+// GeoRestrictorSetFromGeoJSON parses a FeatureCollection; the set has no user or key.
+func GeoRestrictorSetFromGeoJSON(b []byte) (GeoRestrictorSet, error) {
+	fc := geoJSONCollection{}
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return GeoRestrictorSet{}, fmt.Errorf("GeoRestrictorSetFromGeoJSON: %v", err)
+	} else if fc.Type != "FeatureCollection" {
+		return GeoRestrictorSet{}, fmt.Errorf("GeoRestrictorSetFromGeoJSON: want a FeatureCollection, not '%s'", fc.Type)
+	}
+
+	grs := GeoRestrictorSet{Name:fc.Name, Logic:parseCombinationLogic(fc.Logic), Tags:fc.Tags}
+
+	for i,feat := range fc.Features {
+		var pos [][]float64
+		var err error
+		switch feat.Geometry.Type {
+		case "Point":
+			pt := []float64{}
+			err = json.Unmarshal(feat.Geometry.Coordinates, &pt)
+			pos = [][]float64{pt}
+		case "LineString":
+			err = json.Unmarshal(feat.Geometry.Coordinates, &pos)
+		case "Polygon":
+			rings := [][][]float64{}
+			err = json.Unmarshal(feat.Geometry.Coordinates, &rings)
+			if len(rings) > 0 { pos = rings[0] } // Holes are ignored
+		default:
+			err = fmt.Errorf("unsupported geometry")
+		}
+		if err != nil {
+			return grs, fmt.Errorf("GeoRestrictorSetFromGeoJSON: feature %d (%s): %v", i, feat.Geometry.Type, err)
+		}
+
+		rs := restrictorShape{Props: feat.Properties}
+		for _,p := range pos {
+			if len(p) < 2 { return grs, fmt.Errorf("GeoRestrictorSetFromGeoJSON: feature %d: bad position", i) }
+			rs.Points = append(rs.Points, geo.NamedLatlong{Latlong:geo.Latlong{Lat:p[1], Long:p[0]}})
+		}
+
+		gr,err := rs.ToRestrictor(feat.Geometry.Type)
+		if err != nil { return grs, fmt.Errorf("GeoRestrictorSetFromGeoJSON: feature %d: %v", i, err) }
+		grs.R = append(grs.R, gr)
+	}
+
+	return grs, nil
+}
+
+// }}}
+
+// {{{ grs.ToKML
+
+const kKMLNamespace = "http://www.opengis.net/kml/2.2"
+
+type kmlRoot struct {
+	XMLName      xml.Name         `xml:"kml"`
+	Document     kmlDocument      `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name         string           `xml:"name"`
+	ExtendedData kmlExtendedData  `xml:"ExtendedData"`
+	Placemarks []kmlPlacemark     `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name         string           `xml:"name,omitempty"`
+	ExtendedData kmlExtendedData  `xml:"ExtendedData"`
+	Point       *kmlCoordinates   `xml:"Point,omitempty"`
+	LineString  *kmlCoordinates   `xml:"LineString,omitempty"`
+	Polygon     *kmlPolygon       `xml:"Polygon,omitempty"`
+}
+
+type kmlPolygon struct {
+	LinearRing   kmlCoordinates   `xml:"outerBoundaryIs>LinearRing"`
+}
+
+type kmlCoordinates struct {
+	Coordinates  string           `xml:"coordinates"`
+}
+
+type kmlExtendedData struct {
+	Data       []kmlData          `xml:"Data"`
+}
+
+type kmlData struct {
+	Name         string           `xml:"name,attr"`
+	Value        string           `xml:"value"`
+}
+
+func (ed *kmlExtendedData)set(name, val string) {
+	if val != "" { ed.Data = append(ed.Data, kmlData{Name:name, Value:val}) }
+}
+func (ed kmlExtendedData)get(name string) string {
+	for _,d := range ed.Data {
+		if d.Name == name { return strings.TrimSpace(d.Value) }
+	}
+	return ""
+}
+
+func kmlCoordinateString(pts []geo.NamedLatlong) string {
+	strs := []string{}
+	for _,pt := range pts {
+		strs = append(strs, fmt.Sprintf("%.6f,%.6f,0", pt.Long, pt.Lat))
+	}
+	return strings.Join(strs, " ")
+}
+
+// ToKML renders the set as a KML document, with a placemark for each restrictor.
+func (grs GeoRestrictorSet)ToKML() ([]byte, error) {
+	doc := kmlRoot{XMLName: xml.Name{Space:kKMLNamespace, Local:"kml"}}
+	doc.Document.Name = grs.Name
+	doc.Document.ExtendedData.set("logic", grs.Logic.String())
+	doc.Document.ExtendedData.set("tags", strings.Join(grs.Tags, ","))
+
+	for _,gr := range grs.R {
+		rs,err := restrictorToShape(gr)
+		if err != nil { return nil, err }
+
+		pm := kmlPlacemark{Name: gr.String()}
+		ed := &pm.ExtendedData
+		ed.set("restrictor", rs.Props.Restrictor)
+		ed.set("names", strings.Join(rs.Props.Names, ","))
+		if rs.Props.SideKM > 0 { ed.set("sidekm", fmt.Sprintf("%.3f", rs.Props.SideKM)) }
+		if rs.Props.AltitudeMin > 0 { ed.set("altmin", fmt.Sprintf("%d", rs.Props.AltitudeMin)) }
+		if rs.Props.AltitudeMax > 0 { ed.set("altmax", fmt.Sprintf("%d", rs.Props.AltitudeMax)) }
+		if rs.Props.IsExcluding { ed.set("excluding", "1") }
+
+		coords := kmlCoordinates{kmlCoordinateString(rs.Points)}
+		switch rs.Props.Restrictor {
+		case "squarebox":     pm.Point = &coords
+		case "verticalplane": pm.LineString = &coords
+		case "polygon":
+			ring := kmlCoordinateString(append(rs.Points, rs.Points[0]))
+			pm.Polygon = &kmlPolygon{LinearRing: kmlCoordinates{ring}}
+		}
+
+		doc.Document.Placemarks = append(doc.Document.Placemarks, pm)
+	}
+
+	b,err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil { return nil, err }
+	return append([]byte(xml.Header), b...), nil
+}
+
+// }}}
+// {{{ GeoRestrictorSetFromKML
+
+func parseKMLCoordinates(s string) ([]geo.NamedLatlong, error) {
+	pts := []geo.NamedLatlong{}
+	for _,tuple := range strings.Fields(s) {
+		vals := strings.Split(tuple, ",")
+		if len(vals) < 2 { return nil, fmt.Errorf("bad coordinate '%s'", tuple) }
+		long,err1 := strconv.ParseFloat(vals[0], 64)
+		lat,err2 := strconv.ParseFloat(vals[1], 64)
+		if err1 != nil || err2 != nil { return nil, fmt.Errorf("bad coordinate '%s'", tuple) }
+		pts = append(pts, geo.NamedLatlong{Latlong:geo.Latlong{Lat:lat, Long:long}})
+	}
+	return pts, nil
+}
+
+// GeoRestrictorSetFromKML parses a KML document; the set has no user or key. Placemarks
+// inside folders are not looked at.
+func GeoRestrictorSetFromKML(b []byte) (GeoRestrictorSet, error) {
+	doc := kmlRoot{}
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return GeoRestrictorSet{}, fmt.Errorf("GeoRestrictorSetFromKML: %v", err)
+	}
+
+	ded := doc.Document.ExtendedData
+	grs := GeoRestrictorSet{Name:doc.Document.Name, Logic:parseCombinationLogic(ded.get("logic"))}
+	if tags := ded.get("tags"); tags != "" { grs.Tags = strings.Split(tags, ",") }
+
+	for i,pm := range doc.Document.Placemarks {
+		geometry,coords := "",""
+		switch {
+		case pm.Point != nil:      geometry,coords = "Point", pm.Point.Coordinates
+		case pm.LineString != nil: geometry,coords = "LineString", pm.LineString.Coordinates
+		case pm.Polygon != nil:    geometry,coords = "Polygon", pm.Polygon.LinearRing.Coordinates
+		}
+
+		pts,err := parseKMLCoordinates(coords)
+		if err != nil { return grs, fmt.Errorf("GeoRestrictorSetFromKML: placemark %d: %v", i, err) }
+
+		ed := pm.ExtendedData
+		rs := restrictorShape{Points: pts}
+		rs.Props.Restrictor = ed.get("restrictor")
+		if names := ed.get("names"); names != "" { rs.Props.Names = strings.Split(names, ",") }
+		rs.Props.SideKM,_ = strconv.ParseFloat(ed.get("sidekm"), 64)
+		rs.Props.AltitudeMin,_ = strconv.ParseInt(ed.get("altmin"), 10, 64)
+		rs.Props.AltitudeMax,_ = strconv.ParseInt(ed.get("altmax"), 10, 64)
+		rs.Props.IsExcluding = ed.get("excluding") != ""
+
+		gr,err := rs.ToRestrictor(geometry)
+		if err != nil { return grs, fmt.Errorf("GeoRestrictorSetFromKML: placemark %d: %v", i, err) }
+		grs.R = append(grs.R, gr)
+	}
+
+	return grs, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
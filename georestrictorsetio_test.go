@@ -0,0 +1,73 @@
+package flightdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skypies/geo"
+)
+
+func TestGeoRestrictorSetIO(t *testing.T) {
+	poly := geo.NewPolygon()
+	poly.AddPoint(geo.Latlong{Lat:37.0, Long:-122.0})
+	poly.AddPoint(geo.Latlong{Lat:37.1, Long:-122.0})
+	poly.AddPoint(geo.Latlong{Lat:37.1, Long:-122.1})
+
+	grs := GeoRestrictorSet{
+		Name: "gates",
+		Logic: CombinationLogicAny,
+		Tags: []string{"SFO"},
+		R: []geo.Restrictor{
+			geo.SquareBoxRestriction{Debugger:new(geo.DebugLog), SideKM:2.5, AltitudeMax:8000,
+				NamedLatlong:geo.NamedLatlong{Name:"EPICK", Latlong:geo.Latlong{Lat:37.5, Long:-121.9}}},
+			geo.VerticalPlaneRestriction{Debugger:new(geo.DebugLog), AltitudeMin:3000, IsExcluding:true,
+				Start:geo.NamedLatlong{Latlong:geo.Latlong{Lat:37.2, Long:-122.3}},
+				End:geo.NamedLatlong{Latlong:geo.Latlong{Lat:37.3, Long:-122.4}}},
+			geo.PolygonRestriction{Debugger:new(geo.DebugLog), Polygon:poly, AltitudeMin:100},
+		},
+	}
+
+	check := func(format string, got GeoRestrictorSet, err error) {
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		} else if got.OnelineString() != grs.OnelineString() {
+			t.Errorf("%s round trip mismatch:\n got: %s\nwant: %s", format, got.OnelineString(), grs.OnelineString())
+		} else if _,err := got.ToBlob(); err != nil {
+			t.Errorf("%s: imported set can't be persisted: %v", format, err) // needs Debuggers set
+		}
+	}
+
+	if b,err := grs.ToGeoJSON(); err != nil {
+		t.Fatal(err)
+	} else {
+		got,err := GeoRestrictorSetFromGeoJSON(b)
+		check("geojson", got, err)
+	}
+
+	if b,err := grs.ToKML(); err != nil {
+		t.Fatal(err)
+	} else {
+		got,err := GeoRestrictorSetFromKML(b)
+		check("kml", got, err)
+	}
+
+	// GeoJSON from elsewhere has no restrictor properties; guess from the geometry
+	foreign := `{"type":"FeatureCollection", "features":[
+    {"type":"Feature", "properties":{},
+     "geometry":{"type":"LineString", "coordinates":[[-122.3,37.2],[-122.4,37.3]]}},
+    {"type":"Feature", "properties":{"name":"ignored"},
+     "geometry":{"type":"Polygon", "coordinates":[[[-122,37],[-122,37.1],[-122.1,37.1],[-122,37]]]}}]}`
+	if got,err := GeoRestrictorSetFromGeoJSON([]byte(foreign)); err != nil {
+		t.Errorf("foreign geojson: %v", err)
+	} else if len(got.R) != 2 || !strings.HasPrefix(got.R[0].String(), "VerticalPlane") ||
+		!strings.HasPrefix(got.R[1].String(), "3-gon") {
+		t.Errorf("foreign geojson parsed wrong: %s", got)
+	}
+
+	// Points can't be squareboxes without a size
+	point := `{"type":"FeatureCollection", "features":[{"type":"Feature", "properties":{},
+     "geometry":{"type":"Point", "coordinates":[-122.3,37.2]}}]}`
+	if _,err := GeoRestrictorSetFromGeoJSON([]byte(point)); err == nil {
+		t.Errorf("expected error for sizeless point")
+	}
+}
@@ -6,6 +6,7 @@ toolchain go1.23.4
 
 require (
 	cloud.google.com/go/bigquery v1.59.1
+	cloud.google.com/go/datastore v1.15.0
 	cloud.google.com/go/storage v1.39.1
 	github.com/jung-kurt/gofpdf v1.12.6
 	github.com/paulmach/go.geo v0.0.0-20180829195134-22b514266d33
@@ -24,7 +25,6 @@ require (
 	cloud.google.com/go/auth/oauth2adapt v0.2.8 // indirect
 	cloud.google.com/go/cloudtasks v1.12.6 // indirect
 	cloud.google.com/go/compute/metadata v0.6.0 // indirect
-	cloud.google.com/go/datastore v1.15.0 // indirect
 	cloud.google.com/go/iam v1.1.6 // indirect
 	github.com/apache/arrow/go/v14 v14.0.2 // indirect
 	github.com/codahale/hdrhistogram v0.0.0-20161010025455-3a0bb77429bd // indirect
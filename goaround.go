@@ -0,0 +1,149 @@
+package flightdb
+
+// Go-around (and missed approach) detection: a flight that gets low near its destination, and
+// then climbs away again instead of landing.
+//
+// We look for a low point (below KGoAroundMaxAltitude, within KGoAroundMaxDistKM of the
+// destination airport) that the flight descended into, by at least KGoAroundMinClimb over the
+// preceding KGoAroundWindow, and then climbed back out of by at least as much. A flight that
+// lands never climbs back out, so it isn't a go-around (but a touch-and-go is).
+//
+// The span of the go-around runs from where the flight descended through (low+KGoAroundMinClimb)
+// to where it climbed back through it.
+
+import(
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skypies/geo"
+	"github.com/skypies/geo/sfo"
+)
+
+const KGoAroundTag = "GOAROUND"
+
+var(
+	KGoAroundMaxAltitude = 3000.0
+	KGoAroundMaxDistKM   = 15.0
+	KGoAroundMinClimb    = 500.0
+	KGoAroundWindow      = 10 * time.Minute
+)
+
+// A GoAround is a bit like a waypoint; it records when the flight was lowest (and how low).
+type GoAround struct {
+	TimestampUTC    time.Time   // When the flight was lowest
+	geo.Latlong                 // ... and where
+	LowestAltitude  float64
+	DistKM          float64     // From the destination airport, at the lowest point
+	Start,End       time.Time   // The span of the whole maneuver
+}
+
+func (ga GoAround)String() string {
+	return fmt.Sprintf("go-around at %s, lowest %.0fft, %.1fKM from airport",
+		ga.TimestampUTC.Format("15:04:05 MST"), ga.LowestAltitude, ga.DistKM)
+}
+
+// {{{ t.FindGoArounds
+
+func (t Track)FindGoArounds(airport geo.Latlong) []GoAround {
+	gas := []GoAround{}
+
+	isLowAndNear := func(tp Trackpoint) bool {
+		return tp.Altitude < KGoAroundMaxAltitude && tp.DistKM(airport) < KGoAroundMaxDistKM
+	}
+
+	for i:=0; i<len(t); i++ {
+		if !isLowAndNear(t[i]) { continue }
+
+		// Follow the low point down, until we've climbed well clear of it.
+		lo,j := i,i
+		for ; j<len(t) && t[j].Altitude < t[lo].Altitude + KGoAroundMinClimb; j++ {
+			if t[j].Altitude < t[lo].Altitude { lo = j }
+		}
+		if j == len(t) { break } // Never climbed away; landed, or ran out of track
+
+		// Did we descend into it ? Find where we started the final descent; and check this really
+		// is the bottom of the dip, and not a point on the way back up out of an earlier one.
+		k,bottom := lo,true
+		for ; k >= 0 && t[k].Altitude < t[lo].Altitude + KGoAroundMinClimb; k-- {
+			if t[k].Altitude < t[lo].Altitude { bottom = false }
+		}
+		descended := k >= 0 && t[lo].TimestampUTC.Sub(t[k].TimestampUTC) <= KGoAroundWindow
+
+		if descended && bottom && isLowAndNear(t[lo]) {
+			gas = append(gas, GoAround{
+				TimestampUTC: t[lo].TimestampUTC,
+				Latlong: t[lo].Latlong,
+				LowestAltitude: t[lo].Altitude,
+				DistKM: t[lo].DistKM(airport),
+				Start: t[k].TimestampUTC,
+				End: t[j].TimestampUTC,
+			})
+		}
+		i = j
+	}
+
+	return gas
+}
+
+// }}}
+// {{{ f.AnalyseGoArounds
+
+// AnalyseGoArounds looks for go-arounds at the flight's destination, records them in
+// f.GoArounds, and tags the flight if it finds any. The track should be the flight's phased
+// track (see f.PhasedTrack).
+func (f *Flight)AnalyseGoArounds(t Track) {
+	f.DropTag(KGoAroundTag)
+	f.GoArounds = []GoAround{}
+
+	airport,exists := airportLatlong(f.Destination)
+	if !exists { return }
+
+	if f.GoArounds = t.FindGoArounds(airport); len(f.GoArounds) > 0 {
+		f.SetTag(KGoAroundTag)
+	}
+}
+
+// airportLatlong finds the airport, in sfo.KAirports or failing that, the DefaultRunwayTable.
+func airportLatlong(code string) (geo.Latlong, bool) {
+	if code == "" { return geo.Latlong{}, false }
+	if pos,exists := sfo.KAirports[code]; exists { return pos, true }
+	if pos,exists := sfo.KAirports["K"+code]; exists { return pos, true }
+
+	rwys := DefaultRunwayTable.Lookup(strings.ToUpper(code))
+	if len(rwys) == 0 { return geo.Latlong{}, false }
+	pos := geo.Latlong{}
+	for _,r := range rwys {
+		pos.Lat += r.Threshold.Lat / float64(len(rwys))
+		pos.Long += r.Threshold.Long / float64(len(rwys))
+	}
+	return pos, true
+}
+
+// }}}
+// {{{ f.HighlightGoArounds
+
+// HighlightGoArounds marks the trackpoints during each of the flight's go-arounds for display
+// (see AnalysisDisplayHighlight), and annotates the lowest one.
+func (f Flight)HighlightGoArounds(t Track) {
+	for _,ga := range f.GoArounds {
+		for i := range t {
+			tm := t[i].TimestampUTC
+			if tm.Before(ga.Start) || tm.After(ga.End) { continue }
+			t[i].AnalysisDisplay = AnalysisDisplayHighlight
+			if tm.Equal(ga.TimestampUTC) {
+				t[i].AnalysisAnnotation += "* <b>" + ga.String() + "</b>\n"
+			}
+		}
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,83 @@
+package flightdb
+
+import(
+	"testing"
+	"time"
+
+	"github.com/skypies/geo/sfo"
+)
+
+func TestGoArounds(t *testing.T) {
+	sfoPos := sfo.KAirports["KSFO"]
+	s := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tr := Track{}
+	alt := 4000.0
+	add := func(n int, dAlt, distKM float64) {
+		for i:=0; i<n; i++ {
+			alt += dAlt
+			if alt < 10 { alt = 10 }
+			tr = append(tr, Trackpoint{
+				TimestampUTC: s.Add(time.Duration(len(tr)) * 5 * time.Second),
+				Latlong: sfoPos.MoveKM(90, distKM),
+				Altitude: alt,
+			})
+		}
+	}
+
+	add(12,  -70, 20)  // Descending from 4000ft, far out
+	add(40,  -70, 10)  // Down to 360ft, on short final
+	add(30,  150,  5)  // Go around; climb to 4860ft
+	add(60,    0, 30)  // Vectors for another go
+	add(80,  -70,  8)  // Land
+	add(20,    0,  0)  // ... and taxi
+
+	gas := tr.FindGoArounds(sfoPos)
+	if len(gas) != 1 {
+		t.Fatalf("expected one go-around, got %v", gas)
+	}
+	ga := gas[0]
+	if ga.LowestAltitude != 360 || !ga.TimestampUTC.Equal(tr[51].TimestampUTC) || ga.DistKM > 10.1 {
+		t.Errorf("go-around wrong: %s", ga)
+	}
+	if !ga.Start.Before(ga.TimestampUTC) || !ga.End.After(ga.TimestampUTC) {
+		t.Errorf("go-around span wrong: %s - %s", ga.Start, ga.End)
+	}
+
+	f := BlankFlight()
+	f.Destination = "SFO"
+	f.Tracks["ADSB"] = &tr
+	f.AnalyseGoArounds(phasedTrack(f))
+	if !f.HasTag(KGoAroundTag) || len(f.GoArounds) != 1 {
+		t.Errorf("flight not flagged: %v, %v", f.TagList(), f.GoArounds)
+	}
+
+	f.HighlightGoArounds(tr)
+	nHighlit := 0
+	for _,tp := range tr {
+		if tp.AnalysisDisplay == AnalysisDisplayHighlight { nHighlit++ }
+	}
+	if nHighlit < 3 || tr[51].AnalysisDisplay != AnalysisDisplayHighlight || tr[51].AnalysisAnnotation == "" {
+		t.Errorf("highlighting wrong: %d points", nHighlit)
+	}
+	if tr[len(tr)-1].AnalysisDisplay == AnalysisDisplayHighlight {
+		t.Errorf("landing got highlighted")
+	}
+
+	// A normal arrival, or a departure from the destination airport, is not a go-around
+	f.Tracks["ADSB"] = &Track{}
+	*f.Tracks["ADSB"] = append(Track{}, tr[110:]...)
+	f.AnalyseGoArounds(phasedTrack(f))
+	if f.HasTag(KGoAroundTag) {
+		t.Errorf("normal arrival flagged: %v", f.GoArounds)
+	}
+	if gas := phaseTestTrack()[:120].FindGoArounds(phaseTestTrack()[0].Latlong); len(gas) != 0 {
+		t.Errorf("departure flagged: %v", gas)
+	}
+
+	// No destination, no detection
+	f.Destination = ""
+	f.Tracks["ADSB"] = &tr
+	f.AnalyseGoArounds(phasedTrack(f))
+	if f.HasTag(KGoAroundTag) { t.Errorf("flagged without a destination") }
+}
@@ -90,11 +90,14 @@ func (o RestrictorSetIntersectOutcome)Debug() string {
 
 // {{{ f.GetIntersectableTrack
 
+// The tracks we'd rather do geo restriction analysis on, most preferred first.
+var IntersectableTrackSpec = NewTrackSpec("FOIA", "ADSB", "MLAT", "fr24")
+
 // GetIntersectableTrack contains the logic that decides which particular track(s) within a flight
 // is best suited for georestriction analysis. Those tracks are potentially mutated, and the
 // output is returned as a track with pre-computed junk.
 func (f *Flight)GetIntersectableTrack() IntersectableTrack {
-	tName, t := f.PreferredTrack([]string{"FOIA", "ADSB", "MLAT", "fr24"})
+	tName, t := f.TrackBySpec(IntersectableTrackSpec)
 	if tName == "" {
 		return IntersectableTrack{}
 	}
@@ -17,10 +17,34 @@ const kScheduleCacheSingletonName = "schedcache"
 type ScheduleCache struct {
 	LastUpdated time.Time
 	Map map[string]*fdb.FlightSnapshot
+	Since map[string]time.Time // When the airframe was first seen with its current schedule
 }
 
 func (ac *ScheduleCache)Get(id string) *fdb.FlightSnapshot { return ac.Map[id] }
 
+// CarrySince fills in Since, keeping the times from prev for airframes whose schedule hasn't
+// changed; the others are taken to have started their schedules at LastUpdated.
+func (ac *ScheduleCache)CarrySince(prev *ScheduleCache) {
+	ac.Since = map[string]time.Time{}
+	for id,fs := range ac.Map {
+		ac.Since[id] = ac.LastUpdated
+		if prev == nil { continue }
+		if old := prev.Get(id); old != nil && SameSchedule(*old, *fs) && !prev.Since[id].IsZero() {
+			ac.Since[id] = prev.Since[id]
+		}
+	}
+}
+
+// SameSchedule is true if the two snapshots have the same callsign and schedule details.
+func SameSchedule(a,b fdb.FlightSnapshot) bool {
+	return a.Callsign == b.Callsign &&
+		a.Schedule.Number == b.Schedule.Number &&
+		a.Schedule.IATA == b.Schedule.IATA &&
+		a.Schedule.ICAO == b.Schedule.ICAO &&
+		a.Schedule.Origin == b.Schedule.Origin &&
+		a.Schedule.Destination == b.Schedule.Destination
+}
+
 func (ac ScheduleCache)String() string {
 	str := fmt.Sprintf("--- schedule cache (%d entries, age %s) ---\n", len(ac.Map),
 		time.Since(ac.LastUpdated))
@@ -33,6 +57,7 @@ func (ac ScheduleCache)String() string {
 func BlankScheduleCache() ScheduleCache {
 	return ScheduleCache{
 		Map: map[string]*fdb.FlightSnapshot{},
+		Since: map[string]time.Time{},
 	}
 }
 
@@ -13,7 +13,7 @@ import(
 
 func init() {
 	HandleReport(".list", ListReporter, "List flights meeting restrictions")
-	TrackSpec(".list", []string{"fr24", "ADSB", "MLAT", "FA", "FOIA",})
+	TrackSpec(".list", fdb.NewTrackSpec("fr24", "ADSB", "MLAT", "FA", "FOIA"))
 }
 
 var(
@@ -87,7 +87,7 @@ func ListReporter(r *Report, f *fdb.Flight, intersections []fdb.TrackIntersectio
 		addTrackpointIntersection(intersections[0].Start)
 	} else if len(r.Waypoints) > 0 {
 		for _,wpName := range r.Waypoints {
-			if trackName,i := f.AtWaypoint(wpName); trackName != "" {
+			if trackName,i := f.AtWaypointBySpec(wpName, r.PreferredTrackSpec()); trackName != "" {
 				track := f.Tracks[trackName]
 				// for interpolation, see DistAlongLine - or is it busted ?
 				addTrackpointIntersection((*track)[i])
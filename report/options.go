@@ -37,7 +37,8 @@ type Options struct {
 	// Data specification
 	CanSeeFOIA         bool    // This is locked down to a few users. Upgrade to full ACL model?
 	CanSeeFOIASources []string // if empty, can see all sources
-	TrackDataSource    string  // TODO: replace with a cleverer track-specification thing
+	TrackSpec          fdb.TrackSpec // If set, overrides the report's own preferences
+	MinQuality         fdb.QualityBar // If set, intersections must be over data at least this good
 	
 	// Options applicable to various reports
 	TextString         string  // An arbitrary string
@@ -100,10 +101,20 @@ func FormValueReportOptions(db fgae.FlightDB, r *http.Request) (Options, error)
 		}
 	}
 	
-	switch r.FormValue("datasource") {
-	case "ADSB": opt.TrackDataSource = "ADSB"
-	case "fr24": opt.TrackDataSource = "fr24"
-		// default means let the report pick
+	// A nil trackspec means let the report pick
+	if spec,err := fdb.ParseTrackSpec(r.FormValue("trackspec")); err != nil {
+		return opt,err
+	} else {
+		opt.TrackSpec = spec
+	}
+	if bar,err := fdb.ParseQualityBar(r.FormValue("minquality")); err != nil {
+		return opt,err
+	} else {
+		opt.MinQuality = bar
+	}
+	switch r.FormValue("datasource") { // Older URLs
+	case "ADSB", "fr24":
+		if opt.TrackSpec.IsNil() { opt.TrackSpec = fdb.NewTrackSpec(r.FormValue("datasource")) }
 	}
 
 	switch r.FormValue("log") {
@@ -259,7 +270,8 @@ func (o Options)URLValues() url.Values {
 
 	if o.TimeOfDay.IsInitialized() { widget.AddPrefixedValues(v, o.TimeOfDay.Values(), "tod") }
 	
-	if o.TrackDataSource != "" { v.Set("datasource", o.TrackDataSource) }
+	if !o.TrackSpec.IsNil() { v.Set("trackspec", o.TrackSpec.String()) }
+	if !o.MinQuality.IsNil() { v.Set("minquality", o.MinQuality.String()) }
 
 	return v
 }
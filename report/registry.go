@@ -5,6 +5,7 @@ import(
 	"net/http"
 	"sort"
 
+	fdb "github.com/skypies/flightdb"
 	"github.com/skypies/flightdb/fgae"
 )
 
@@ -13,7 +14,7 @@ type ReportEntry struct {
 	ReportFunc
 	SummarizeFunc
 	Name, Description string
-	TrackSpec []string
+	TrackSpec fdb.TrackSpec
 }
 
 var reportRegistry = map[string]ReportEntry{}
@@ -30,9 +31,9 @@ func SummarizeReport(name string, sf SummarizeFunc) {
 	entry.SummarizeFunc = sf
 	reportRegistry[name] = entry
 }
-func TrackSpec(name string, tracks []string) {
+func TrackSpec(name string, spec fdb.TrackSpec) {
 	entry := reportRegistry[name]
-	entry.TrackSpec = tracks
+	entry.TrackSpec = spec
 	reportRegistry[name] = entry
 }
 
@@ -82,9 +83,6 @@ func InstantiateReport(name string) (Report,error) {
 		r.Func = entry.ReportFunc
 		r.SummarizeFunc = entry.SummarizeFunc
 		r.TrackSpec = entry.TrackSpec
-		if r.TrackSpec == nil {
-			r.TrackSpec = []string{}
-		}
 	}
 	return r, nil
 }
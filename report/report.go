@@ -33,7 +33,7 @@ type Report struct {
 	Options           // embedded
 	Func              ReportFunc
 	SummarizeFunc     // embedded, but just to avoid a more confusing name
-	TrackSpec       []string
+	TrackSpec         fdb.TrackSpec // How the report likes to pick tracks
 
 	// Private state a report might accumulate (be careful about RAM though!)
 	Blobs map[string]interface{}
@@ -85,14 +85,23 @@ func (r *Report)AddRow(html *[]string, text *[]string) {
 	if text != nil { r.RowsText = append(r.RowsText, *text) }
 }
 
-func (r *Report)ListPreferredDataSources() []string {
-	// Dumb logic for now ...
-	if r.Options.TrackDataSource != "" {
-		return []string{r.Options.TrackDataSource}
+// PreferredTrackSpec is the report's own trackspec, unless the user asked for something else
+func (r *Report)PreferredTrackSpec() fdb.TrackSpec {
+	if !r.Options.TrackSpec.IsNil() {
+		return r.Options.TrackSpec.WithDefaultNames(r.TrackSpec.Names...)
 	}
 	return r.TrackSpec
 }
 
+// TrackByRestrictedSpec is for reports that can only use the tracks their own trackspec names;
+// the user's trackspec can narrow those down, but not add to them. The name is empty if no
+// track was acceptable.
+func (r *Report)TrackByRestrictedSpec(f *fdb.Flight) (string, fdb.Track) {
+	ts,ok := r.PreferredTrackSpec().Intersect(r.TrackSpec)
+	if !ok { return "", fdb.Track{} }
+	return f.TrackBySpec(ts)
+}
+
 // Ensure the flight matches all the search restrictions
 func (r *Report)PreProcess(f *fdb.Flight) (bool, []fdb.TrackIntersection) {
 	r.I["[A] PreProcessed"]++
@@ -117,7 +126,7 @@ func (r *Report)PreProcess(f *fdb.Flight) (bool, []fdb.TrackIntersection) {
 			return false, []fdb.TrackIntersection{}
 		}
 		t := f.Tracks["FOIA"]
-		foiaSrc := (*t)[0].DataSource
+		foiaSrc := (*t)[0].FOIADataset()
 		if allowed,str := r.CanSeeThisFOIASource(foiaSrc); !allowed {
 			r.I[str]++
 			return false, []fdb.TrackIntersection{}
@@ -131,7 +140,7 @@ func (r *Report)PreProcess(f *fdb.Flight) (bool, []fdb.TrackIntersection) {
 	if !r.Options.GRS.IsNil() {
 		tStart := time.Now()
 		satisfied,outcomes := f.SatisfiesGeoRestrictorSet(r.Options.GRS)
-		r.Debugf("---- %s\nSources: %v\n", f.IdentityString(), r.ListPreferredDataSources())
+		r.Debugf("---- %s\nTrackSpec: %s\n", f.IdentityString(), r.PreferredTrackSpec())
 		r.Debugf("--{ GRS }--\n%s", r.Options.GRS)
 		r.Debugf("--{ Outcome satisfies=%v }--\n", satisfied)
 		r.Debugf("--{ Debug }--\n%s\n", outcomes.Debug())
@@ -150,6 +159,18 @@ func (r *Report)PreProcess(f *fdb.Flight) (bool, []fdb.TrackIntersection) {
 
 	r.I["[B] <b>Satisfied geo restrictions</b> "]++
 
+	// The intersections index into the track that was used for the restrictions.
+	if !r.Options.MinQuality.IsNil() && len(intersections) > 0 {
+		_,t := f.TrackBySpec(fdb.IntersectableTrackSpec)
+		for _,ti := range intersections {
+			if ok,why := t.QualityBetween(ti.I, ti.J).Meets(r.Options.MinQuality); !ok {
+				r.I["[Ba] Eliminated: poor track quality: "+why]++
+				return false, intersections
+			}
+		}
+		r.I["[Ba] <b>Satisfied track quality</b> "]++
+	}
+
 	if r.TimeOfDay.IsInitialized() {
 
 		//r.Info(fmt.Sprintf("**** ToD %s, %s\n", r.TimeOfDay, f))
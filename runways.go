@@ -0,0 +1,271 @@
+package flightdb
+
+// Runway inference: work out which runway a flight departed from, or arrived on.
+//
+// We take the departure points (the takeoff, and the climb up to KRunwayMaxAltitude) from the
+// start of the track, or the arrival points (the approach below KRunwayMaxAltitude, and the
+// landing) from the end; see t.ClassifyPhases. Then, for each runway end at the airport, we
+// keep the points that are within KRunwayMaxDistKM of its threshold and heading along it, and
+// see how far they are from its extended centerline. The runway with the points closest to
+// its centerline wins.
+//
+// Confidence is in [0,1]; it drops when the points stray from the centerline, when there are
+// few of them, and when a parallel runway is nearly as good a fit.
+//
+// Runway data comes from a RunwayTable; DefaultRunwayTable has a few local airports built in,
+// and more can be loaded (see LoadRunwayTable).
+
+import(
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skypies/geo"
+)
+
+var(
+	KRunwayMaxAltitude      = 2000.0 // Only look at points below this
+	KRunwayMaxDistKM        = 10.0   // ... and this close to the threshold
+	KRunwayMaxHeadingErr    = 20.0   // ... and flying within this many degrees of the runway
+	KRunwayMaxLateralKM     = 0.4    // The average distance from the centerline must be less than this
+	KRunwayMinPoints        = 3
+	KRunwayConfidentPoints  = 10     // Fewer points than this reduces the confidence
+	KRunwayMinTagConfidence = 0.3    // Matches less confident than this don't get tagged
+)
+
+// A Runway is one end of a strip of tarmac; 28L and 10R are two Runways.
+type Runway struct {
+	Airport    string      // IATA code, as per f.Origin (e.g. "SFO")
+	Name       string      // e.g. "28L"
+	Threshold  geo.Latlong // Where this end starts
+	Heading    float64     // True heading, in degrees, when taking off or landing on it
+}
+
+func (r Runway)String() string { return r.Airport + ":" + r.Name }
+
+// A RunwayMatch is a runway we think a flight used.
+type RunwayMatch struct {
+	Runway
+	Departure  bool    // If false, it was an arrival
+	Confidence float64
+	NumPoints  int
+	LateralKM  float64 // Average distance of the points from the extended centerline
+}
+
+// Tag is the index tag for the match; "SFO:28R" for a departure, ":SFO:28L" for an arrival
+// (see SetAirportComboTagsFor).
+func (m RunwayMatch)Tag() string {
+	if m.Departure { return m.Airport + ":" + m.Name }
+	return ":" + m.Airport + ":" + m.Name
+}
+
+func (m RunwayMatch)String() string {
+	return fmt.Sprintf("%s (conf=%.2f, %d pts, %.0fm off centerline)", m.Tag(), m.Confidence,
+		m.NumPoints, m.LateralKM*1000)
+}
+
+// {{{ RunwayTable
+
+// A RunwayTable lists the runways, by airport (IATA code).
+type RunwayTable map[string][]Runway
+
+// Lookup finds the runways for the airport; it accepts US ICAO codes (e.g. "KSFO") too.
+func (rt RunwayTable)Lookup(airport string) []Runway {
+	if rwys,exists := rt[airport]; exists { return rwys }
+	if len(airport) == 4 && strings.HasPrefix(airport, "K") { return rt[airport[1:]] }
+	return nil
+}
+
+func (rt RunwayTable)Add(r Runway) { rt[r.Airport] = append(rt[r.Airport], r) }
+
+// LoadRunwayTable reads CSV lines of the form `airport,runway,lat,long,heading`, e.g.
+// `SFO,28L,37.611711,-122.358143,297.6`. Lines starting with '#' are ignored.
+func LoadRunwayTable(r io.Reader) (RunwayTable, error) {
+	rt := RunwayTable{}
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+	reader.TrimLeadingSpace = true
+
+	for {
+		rec,err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("LoadRunwayTable: %v", err)
+		}
+
+		vals := [3]float64{}
+		for i,str := range rec[2:] {
+			if vals[i],err = strconv.ParseFloat(str, 64); err != nil {
+				return nil, fmt.Errorf("LoadRunwayTable: %v: %v", rec, err)
+			}
+		}
+		rt.Add(Runway{
+			Airport: strings.ToUpper(rec[0]),
+			Name: strings.ToUpper(rec[1]),
+			Threshold: geo.Latlong{Lat:vals[0], Long:vals[1]},
+			Heading: math.Mod(vals[2]+360, 360),
+		})
+	}
+
+	return rt, nil
+}
+
+// Both ends of a strip; the headings come from the positions.
+func (rt RunwayTable)addStrip(airport, name1, name2 string, end1, end2 geo.Latlong) {
+	rt.Add(Runway{Airport:airport, Name:name1, Threshold:end1, Heading:end1.BearingTowards(end2)})
+	rt.Add(Runway{Airport:airport, Name:name2, Threshold:end2, Heading:end2.BearingTowards(end1)})
+}
+
+// Approximate positions of the runway ends; good enough to tell parallels apart.
+var DefaultRunwayTable = func() RunwayTable {
+	rt := RunwayTable{}
+	rt.addStrip("SFO", "10L", "28R",
+		geo.Latlong{Lat:37.628735, Long:-122.393392}, geo.Latlong{Lat:37.613534, Long:-122.357141})
+	rt.addStrip("SFO", "10R", "28L",
+		geo.Latlong{Lat:37.626413, Long:-122.393105}, geo.Latlong{Lat:37.611711, Long:-122.358143})
+	rt.addStrip("SFO", "01L", "19R",
+		geo.Latlong{Lat:37.607977, Long:-122.382570}, geo.Latlong{Lat:37.626886, Long:-122.370872})
+	rt.addStrip("SFO", "01R", "19L",
+		geo.Latlong{Lat:37.606329, Long:-122.380946}, geo.Latlong{Lat:37.627291, Long:-122.367670})
+	return rt
+}()
+
+// }}}
+
+// {{{ t.MatchRunway
+
+// MatchRunway finds the runway (at one of the airports) that the track departed from (or arrived
+// on). The track's phases must already be labelled (see t.ClassifyPhases).
+func (t Track)MatchRunway(rt RunwayTable, airports []string, departure bool) (RunwayMatch, bool) {
+	pts := t.runwayPoints(departure)
+	if len(pts) < KRunwayMinPoints { return RunwayMatch{}, false }
+
+	matches := []RunwayMatch{}
+	for _,airport := range airports {
+		for _,rwy := range rt.Lookup(airport) {
+			if m,ok := pts.fitRunway(rwy); ok {
+				m.Departure = departure
+				matches = append(matches, m)
+			}
+		}
+	}
+	if len(matches) == 0 { return RunwayMatch{}, false }
+
+	sort.SliceStable(matches, func(i,j int) bool { return matches[i].LateralKM < matches[j].LateralKM })
+	best := matches[0]
+
+	best.Confidence = 1.0 - best.LateralKM/KRunwayMaxLateralKM
+	if best.NumPoints < KRunwayConfidentPoints {
+		best.Confidence *= float64(best.NumPoints) / float64(KRunwayConfidentPoints)
+	}
+	if len(matches) > 1 { // How much better than the runner-up (probably a parallel) ?
+		second := matches[1].LateralKM
+		if second+best.LateralKM > 0 {
+			best.Confidence *= (second - best.LateralKM) / (second + best.LateralKM)
+		}
+	}
+
+	return best, true
+}
+
+// runwayPoints returns the low departure (or arrival) points, from the start (or end) of the track.
+func (t Track)runwayPoints(departure bool) Track {
+	pts := Track{}
+	for n := range t {
+		i := n
+		if !departure { i = len(t)-1-n }
+		tp := t[i]
+
+		ok := false
+		if departure {
+			ok = tp.Phase == PhaseTakeoff || tp.Phase == PhaseClimb
+		} else {
+			ok = tp.Phase == PhaseLanding || tp.Phase == PhaseApproach
+		}
+		if !ok || tp.Altitude >= KRunwayMaxAltitude {
+			if len(pts) > 0 { break } // We're past the stretch we wanted
+			if tp.Phase != PhaseTaxi && tp.Phase != PhaseUnknown { break } // Track doesn't go low enough
+			continue
+		}
+
+		// The course, from the neighbouring points
+		prev,next := t[max(i-1,0)], t[min(i+1,len(t)-1)]
+		if prev.DistKM(next.Latlong) < 0.05 { continue } // Too slow to have a meaningful course
+		tp.Heading = prev.BearingTowards(next.Latlong)
+
+		pts = append(pts, tp)
+	}
+	return pts
+}
+
+// fitRunway looks at the points heading along the runway near its threshold, and measures
+// how far they are from its extended centerline.
+func (pts Track)fitRunway(rwy Runway) (RunwayMatch, bool) {
+	proj := newFlatProjection(rwy.Threshold)
+	h := rwy.Heading * math.Pi / 180.0
+
+	n, totKM := 0, 0.0
+	for _,tp := range pts {
+		if headingDiff(tp.Heading, rwy.Heading) > KRunwayMaxHeadingErr { continue }
+		x,y := proj.project(tp.Latlong)
+		if math.Hypot(x,y) > KRunwayMaxDistKM { continue }
+		totKM += math.Abs(x*math.Cos(h) - y*math.Sin(h)) // Distance off the centerline
+		n++
+	}
+	if n < KRunwayMinPoints { return RunwayMatch{}, false }
+
+	m := RunwayMatch{Runway:rwy, NumPoints:n, LateralKM:totKM/float64(n)}
+	return m, m.LateralKM < KRunwayMaxLateralKM
+}
+
+func headingDiff(a, b float64) float64 {
+	d := math.Abs(math.Mod(a-b+360, 360))
+	return math.Min(d, 360-d)
+}
+
+// }}}
+// {{{ f.AnalyseRunways
+
+// AnalyseRunways works out which runways the flight departed from and arrived on, records them
+// in f.Runways, and resets the runway tags to match. If the flight has no origin (or
+// destination), all the airports in the table are considered. The track should be the flight's
+// phased track (see f.PhasedTrack).
+func (f *Flight)AnalyseRunways(rt RunwayTable, t Track) {
+	for _,m := range f.Runways { f.DropTag(m.Tag()) }
+	f.Runways = []RunwayMatch{}
+
+	allAirports := []string{}
+	for airport := range rt { allAirports = append(allAirports, airport) }
+	sort.Strings(allAirports)
+	airportsFor := func(airport string) []string {
+		if airport == "" { return allAirports }
+		return []string{airport}
+	}
+
+	if m,ok := t.MatchRunway(rt, airportsFor(f.Origin), true); ok {
+		f.Runways = append(f.Runways, m)
+	}
+	if m,ok := t.MatchRunway(rt, airportsFor(f.Destination), false); ok {
+		f.Runways = append(f.Runways, m)
+	}
+
+	for _,m := range f.Runways {
+		if m.Confidence >= KRunwayMinTagConfidence { f.SetTag(m.Tag()) }
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
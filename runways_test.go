@@ -0,0 +1,96 @@
+package flightdb
+
+import(
+	"strings"
+	"testing"
+
+	"github.com/skypies/geo"
+)
+
+// Puts points i..j of the track on the extended centerline of the runway, spaced out by stepKM,
+// with point k on the threshold.
+func putOnCenterline(t Track, rwy Runway, i, j, k int, stepKM float64) {
+	for n:=i; n<=j; n++ {
+		along := float64(n-k) * stepKM
+		if along >= 0 {
+			t[n].Latlong = rwy.Threshold.MoveKM(rwy.Heading, along)
+		} else {
+			t[n].Latlong = rwy.Threshold.MoveKM(rwy.Heading+180, -along)
+		}
+	}
+}
+
+func findRunway(rt RunwayTable, airport, name string) Runway {
+	for _,r := range rt.Lookup(airport) {
+		if r.Name == name { return r }
+	}
+	return Runway{}
+}
+
+func TestAnalyseRunways(t *testing.T) {
+	rt := DefaultRunwayTable
+	r28R, r28L := findRunway(rt, "SFO", "28R"), findRunway(rt, "KSFO", "28L")
+	if r28R.Name == "" || r28L.Name == "" { t.Fatalf("runways missing from default table") }
+
+	tr := phaseTestTrack()
+	for i:=0; i<24; i++ { tr[i].Latlong = r28R.Threshold.MoveKM(r28R.Heading+90, 0.3) } // At the gate
+	putOnCenterline(tr, r28R,  24,  50,  24, 0.3)  // Take off from the threshold and climb out
+	putOnCenterline(tr, r28L, 300, 360, 350, 0.35) // Approach; touchdown at 350; rollout
+
+	f := BlankFlight()
+	f.Origin, f.Destination = "SFO", "SFO"
+	f.Tracks["ADSB"] = &tr
+	f.SetTag(":SFO:01R")
+	f.Runways = []RunwayMatch{{Runway:findRunway(rt, "SFO", "01R")}} // A stale match
+
+	f.AnalyseRunways(rt, phasedTrack(f))
+
+	if len(f.Runways) != 2 {
+		t.Fatalf("expected two matches, got %v", f.Runways)
+	}
+	dep,arr := f.Runways[0], f.Runways[1]
+	if !dep.Departure || dep.Tag() != "SFO:28R" || dep.Confidence < 0.8 {
+		t.Errorf("departure wrong: %s", dep)
+	}
+	if arr.Departure || arr.Tag() != ":SFO:28L" || arr.Confidence < 0.8 {
+		t.Errorf("arrival wrong: %s", arr)
+	}
+	for _,tag := range []string{"SFO:28R", ":SFO:28L"} {
+		if !f.HasTag(tag) { t.Errorf("missing tag %q: %v", tag, f.TagList()) }
+	}
+	if f.HasTag(":SFO:01R") { t.Errorf("stale tag survived: %v", f.TagList()) }
+
+	// Halfway between the parallels, we can't be confident about which one it was
+	halfway := r28R.Threshold.MoveKM(r28R.Heading-90, r28R.Threshold.DistKM(r28L.Threshold)/2)
+	r28R.Threshold = halfway
+	putOnCenterline(tr, r28R, 24, 50, 24, 0.3)
+	f.AnalyseRunways(rt, phasedTrack(f))
+	if len(f.Runways) == 0 || !f.Runways[0].Departure || f.Runways[0].Confidence > 0.3 {
+		t.Errorf("ambiguous departure was too confident: %v", f.Runways)
+	}
+	if f.HasTag("SFO:28R") || f.HasTag("SFO:28L") { t.Errorf("ambiguous departure got tagged") }
+
+	// A flight that never gets low enough near an airport gets nothing
+	tr = phaseTestTrack()
+	f.Tracks["ADSB"] = &tr
+	f.AnalyseRunways(rt, phasedTrack(f))
+	if len(f.Runways) != 0 { t.Errorf("far-away flight matched: %v", f.Runways) }
+}
+
+func TestLoadRunwayTable(t *testing.T) {
+	csv := "# airport,runway,lat,long,heading\n" +
+		"SJC, 30L, 37.3527, -121.9240, 311.5\n" +
+		"sjc,12r,37.3715,-121.9477,131.5\n"
+
+	rt,err := LoadRunwayTable(strings.NewReader(csv))
+	if err != nil { t.Fatal(err) }
+	rwys := rt.Lookup("KSJC")
+	if len(rwys) != 2 || rwys[1].String() != "SJC:12R" || rwys[0].Heading != 311.5 ||
+		rwys[0].Threshold != (geo.Latlong{Lat:37.3527, Long:-121.9240}) {
+		t.Errorf("loaded table wrong: %v", rt)
+	}
+
+	if _,err := LoadRunwayTable(strings.NewReader("SJC,30L,37.35,north,311\n")); err == nil {
+		t.Errorf("expected error for bad longitude")
+	}
+}
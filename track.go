@@ -77,7 +77,7 @@ func (t Track)String() string {
 		str += fmt.Sprintf(", %s, %.1fKM (%.0f deg)",
 			date.RoundDuration(e.TimestampUTC.Sub(s.TimestampUTC)),
 			s.Dist(e.Latlong), s.BearingTowards(e.Latlong))
-		str += fmt.Sprintf(", src=%s", s.DataSource)
+		str += fmt.Sprintf(", src=%s", s.SourceLabel())
 		if s.ReceiverName != "" { str += "/" + s.ReceiverName }
 	}
 
@@ -93,7 +93,7 @@ func (t Track)MediumString() string {
 	str := fmt.Sprintf("%4d pts, start=%s", len(t),
 		t[0].TimestampUTC.Format("2006.01.02 15:04"))
 	if len(t) > 1 {
-		str += fmt.Sprintf(", src=%s", t[0].DataSource)
+		str += fmt.Sprintf(", src=%s", t[0].SourceLabel())
 	}
 
 	if t.Notes() != "" {
@@ -169,17 +169,7 @@ func (t Track)LongSource() string {
 
 func (t Track)DataSourceIsFAA () bool {
 	if len(t) == 0 { return false }
-
-	sources := []string{
-		"RG-FOIA", "EB-FOIA", // older hardcoded formats
-		"mtv-foia", "eastbay-foia", // newer, bucketname-derived formats
-	}
-
-	for _,src := range sources {
-		if t[0].DataSource == src { return true }
-	}
-
-	return false
+	return t[0].GetDataProvider() == DPFAAFOIA
 }
 
 // }}}
@@ -191,7 +181,7 @@ func (t Track)DataSourceIsFAA () bool {
 // Derive a bunch of data fields from the raw data.
 // NOTE - the vertical data gets too jerky with ADSB, because altitude change appears more like
 // an occasional step function when the datapoints are too close. You should use t.SampleEvery()
-// to space things out a bit before using those fields.
+// to space things out a bit before using those fields. Or use t.PostProcessSmoothed().
 func (t Track)PostProcess() {
 	// Skip the first point
 	for i:=1; i<len(t); i++ {
@@ -674,7 +664,7 @@ func (from Trackpoint)LatlongTimeBoxTo(to Trackpoint) geo.LatlongTimeBox {
 		Start: from.TimestampUTC,
 		End: to.TimestampUTC,
 		HeadingDelta: geo.HeadingDelta(from.Heading, to.Heading),
-		Source: from.DataSource,
+		Source: from.SourceLabel(),
 	}
 }
 
@@ -724,7 +714,7 @@ func (t Track)AsContiguousBoxes() []geo.LatlongTimeBox {
 					" - centroid: %.2f; sITP: %.2f; delta: %.2f\n"+
 					" - interp: %d points\n"+
 					" - sITP: %s\n - eITP: %s\n",
-					t[0].DataSource, sTP, eTP, startFrac, endFrac,
+					t[0].SourceLabel(), sTP, eTP, startFrac, endFrac,
 					centroidHeading, sITP.Heading, box.CentroidHeadingDelta,
 					nNeeded,
 					sITP, eITP)
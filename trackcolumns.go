@@ -0,0 +1,199 @@
+package flightdb
+
+// A compact serialization for tracks. Instead of gob-encoding each trackpoint struct, we store
+// each field as a column; numeric columns hold varint deltas from the previous point (which are
+// small, as adjacent trackpoints are similar), and string columns hold indices into a dictionary
+// of the distinct values in the track.
+//
+// Numbers are stored as fixed-point integers, so this is lossy beyond the following precisions:
+//   timestamps: 1ns (i.e. lossless)
+//   lat/long:   1e-7 degrees (~1cm)
+//   others:     0.01 units (feet, knots, degrees, feet per minute)
+//
+// The DataSystem and DataProvider columns came later, so they go in a second string block at
+// the end; blobs written before then don't have it, and decode with those fields empty (which
+// GetData{System|Provider} knows how to deal with).
+//
+// Only the stored fields of a Trackpoint survive; the derived and transient ones (tagged with
+// `datastore:"-"`) need recomputing via PostProcess etc., just as with the other encodings.
+
+import(
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const(
+	kColumnsLatlongScale = 1e7
+	kColumnsValueScale   = 1e2
+)
+
+// {{{ t.ToColumns
+
+func (t Track)ToColumns() []byte {
+	buf := []byte{}
+	buf = binary.AppendUvarint(buf, uint64(len(t)))
+
+	// Time is in the first column
+	prev := int64(0)
+	for _,tp := range t {
+		n := tp.TimestampUTC.UnixNano()
+		buf = binary.AppendVarint(buf, n - prev)
+		prev = n
+	}
+
+	// Then the numeric columns
+	floatCol := func(get func(Trackpoint) float64, scale float64) {
+		prev := int64(0)
+		for _,tp := range t {
+			v := int64(math.Round(get(tp) * scale))
+			buf = binary.AppendVarint(buf, v - prev)
+			prev = v
+		}
+	}
+	floatCol(func(tp Trackpoint) float64 { return tp.Lat },          kColumnsLatlongScale)
+	floatCol(func(tp Trackpoint) float64 { return tp.Long },         kColumnsLatlongScale)
+	floatCol(func(tp Trackpoint) float64 { return tp.Altitude },     kColumnsValueScale)
+	floatCol(func(tp Trackpoint) float64 { return tp.GroundSpeed },  kColumnsValueScale)
+	floatCol(func(tp Trackpoint) float64 { return tp.Heading },      kColumnsValueScale)
+	floatCol(func(tp Trackpoint) float64 { return tp.VerticalRate }, kColumnsValueScale)
+
+	// Then the strings, via a dictionary
+	buf = appendStringColumns(buf, t, func(tp Trackpoint) []string {
+		return []string{tp.DataSource, tp.ReceiverName, tp.Squawk}
+	})
+	buf = appendStringColumns(buf, t, func(tp Trackpoint) []string {
+		return []string{string(tp.DataSystem), string(tp.DataProvider)}
+	})
+
+	return buf
+}
+
+// }}}
+// {{{ TrackFromColumns
+
+func TrackFromColumns(b []byte) (Track, error) {
+	r := bytes.NewReader(b)
+
+	n,err := binary.ReadUvarint(r)
+	if err != nil { return nil, fmt.Errorf("TrackFromColumns: %v", err) }
+	if n > uint64(len(b)) { return nil, fmt.Errorf("TrackFromColumns: bad length %d", n) }
+	t := make(Track, n)
+
+	prev := int64(0)
+	for i := range t {
+		delta,err := binary.ReadVarint(r)
+		if err != nil { return nil, fmt.Errorf("TrackFromColumns time: %v", err) }
+		prev += delta
+		t[i].TimestampUTC = time.Unix(0, prev).UTC()
+	}
+
+	floatCol := func(set func(*Trackpoint, float64), scale float64) error {
+		prev := int64(0)
+		for i := range t {
+			delta,err := binary.ReadVarint(r)
+			if err != nil { return err }
+			prev += delta
+			set(&t[i], float64(prev) / scale)
+		}
+		return nil
+	}
+	cols := []struct{
+		set   func(*Trackpoint, float64)
+		scale float64
+	}{
+		{func(tp *Trackpoint, v float64) { tp.Latlong.Lat = v },  kColumnsLatlongScale},
+		{func(tp *Trackpoint, v float64) { tp.Latlong.Long = v }, kColumnsLatlongScale},
+		{func(tp *Trackpoint, v float64) { tp.Altitude = v },     kColumnsValueScale},
+		{func(tp *Trackpoint, v float64) { tp.GroundSpeed = v },  kColumnsValueScale},
+		{func(tp *Trackpoint, v float64) { tp.Heading = v },      kColumnsValueScale},
+		{func(tp *Trackpoint, v float64) { tp.VerticalRate = v }, kColumnsValueScale},
+	}
+	for _,col := range cols {
+		if err := floatCol(col.set, col.scale); err != nil {
+			return nil, fmt.Errorf("TrackFromColumns: %v", err)
+		}
+	}
+
+	err = readStringColumns(r, t, func(tp *Trackpoint) []*string {
+		return []*string{&tp.DataSource, &tp.ReceiverName, &tp.Squawk}
+	})
+	if err != nil { return nil, fmt.Errorf("TrackFromColumns: %v", err) }
+
+	if r.Len() > 0 { // Older blobs stop here
+		err = readStringColumns(r, t, func(tp *Trackpoint) []*string {
+			return []*string{(*string)(&tp.DataSystem), (*string)(&tp.DataProvider)}
+		})
+		if err != nil { return nil, fmt.Errorf("TrackFromColumns: %v", err) }
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("TrackFromColumns: %d trailing bytes", r.Len())
+	}
+
+	return t, nil
+}
+
+// }}}
+// {{{ appendStringColumns, readStringColumns
+
+// appendStringColumns writes out a dictionary of all the values of the fields, followed by a
+// dictionary index per field per trackpoint.
+func appendStringColumns(buf []byte, t Track, fields func(Trackpoint) []string) []byte {
+	dict := map[string]uint64{}
+	words := []string{}
+	indices := []uint64{}
+	for _,tp := range t {
+		for _,s := range fields(tp) {
+			if _,exists := dict[s]; !exists {
+				dict[s] = uint64(len(words))
+				words = append(words, s)
+			}
+			indices = append(indices, dict[s])
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(words)))
+	for _,word := range words {
+		buf = binary.AppendUvarint(buf, uint64(len(word)))
+		buf = append(buf, word...)
+	}
+	for _,i := range indices {
+		buf = binary.AppendUvarint(buf, i)
+	}
+	return buf
+}
+
+func readStringColumns(r *bytes.Reader, t Track, fields func(*Trackpoint) []*string) error {
+	nWords,err := binary.ReadUvarint(r)
+	if err != nil { return fmt.Errorf("dict: %v", err) }
+	words := []string{}
+	for i:=uint64(0); i<nWords; i++ {
+		l,err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) { return fmt.Errorf("dict: bad entry") }
+		word := make([]byte, l)
+		r.Read(word)
+		words = append(words, string(word))
+	}
+
+	for i := range t {
+		for _,dst := range fields(&t[i]) {
+			j,err := binary.ReadUvarint(r)
+			if err != nil { return fmt.Errorf("strings: %v", err) }
+			if j >= uint64(len(words)) { return fmt.Errorf("strings: bad dict index %d", j) }
+			*dst = words[j]
+		}
+	}
+	return nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,78 @@
+package flightdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestColumnsRoundTrip(t *testing.T) {
+	orig := append(loadTrack(t1a), loadTrack(t1b)...)
+	for i := range orig {
+		orig[i].DataSource = "ADSB"
+		orig[i].DataSystem = []DataSystem{DSADSB,DSMLAT}[i%2]
+		orig[i].DataProvider = DPSkypi
+		orig[i].ReceiverName = []string{"ScottsValley","Saratoga"}[i%2]
+		orig[i].Squawk = "1200"
+		orig[i].VerticalRate = 1664
+	}
+
+	t2,err := TrackFromColumns(orig.ToColumns())
+	if err != nil { t.Fatal(err) }
+	if len(t2) != len(orig) { t.Fatalf("length: expected %d, saw %d", len(orig), len(t2)) }
+
+	near := func(a,b,tol float64) bool { return math.Abs(a-b) <= tol }
+	for i := range orig {
+		a,b := orig[i],t2[i]
+		if !a.TimestampUTC.Equal(b.TimestampUTC) ||
+			!near(a.Lat, b.Lat, 1e-7) || !near(a.Long, b.Long, 1e-7) ||
+			!near(a.Altitude, b.Altitude, 0.01) || !near(a.GroundSpeed, b.GroundSpeed, 0.01) ||
+			!near(a.Heading, b.Heading, 0.01) || !near(a.VerticalRate, b.VerticalRate, 0.01) ||
+			a.DataSource != b.DataSource || a.ReceiverName != b.ReceiverName || a.Squawk != b.Squawk ||
+			a.DataSystem != b.DataSystem || a.DataProvider != b.DataProvider {
+			t.Errorf("[%d] mismatch:\n  orig: %#v\n  new : %#v", i, a, b)
+		}
+	}
+
+	if _,err := TrackFromColumns(orig.ToColumns()[:20]); err == nil {
+		t.Errorf("truncated columns decoded without error")
+	}
+
+	// Blobs from before the DataSystem/DataProvider columns just lack the final string block,
+	// which (when the fields are all empty) is a one-word dict, and a zero index per field.
+	legacy := append(Track{}, orig...)
+	for i := range legacy {
+		legacy[i].DataSystem,legacy[i].DataProvider = "",""
+	}
+	b := legacy.ToColumns()
+	b = b[:len(b) - (2 + 2*len(legacy))]
+	if t3,err := TrackFromColumns(b); err != nil {
+		t.Errorf("legacy columns: %v", err)
+	} else if t3[0].DataSystem != "" || t3[0].GetDataSystem() != DSADSB || t3[0].GetDataProvider() != DPSkypi {
+		t.Errorf("legacy columns decoded wrong: %#v", t3[0])
+	}
+}
+
+func TestColumnarBlob(t *testing.T) {
+	f := BlankFlight()
+	f.IcaoId,f.Callsign = "A12345","UAL123"
+	t1 := append(loadTrack(t1a), loadTrack(t1b)...)
+	f.Tracks["ADSB"] = &t1
+	f.SetTag("FOO")
+
+	blob,err := f.ToBlobWithEncoding(AsGzippedColumns)
+	if err != nil { t.Fatal(err) }
+	f2,err := blob.ToFlight("")
+	if err != nil { t.Fatal(err) }
+
+	if f2.IcaoId != f.IcaoId || f2.Callsign != f.Callsign || !f2.HasTag("FOO") {
+		t.Errorf("flight mismatch:\n  orig: %s\n  new : %s", f, f2)
+	} else if !f2.HasTrack("ADSB") || len(*f2.Tracks["ADSB"]) != len(t1) {
+		t.Errorf("track mismatch:\n  orig: %s\n  new : %s", f, f2)
+	}
+
+	gobBlob,_ := f.ToBlobWithEncoding(AsGzippedGob)
+	if len(blob.Blob) >= len(gobBlob.Blob) {
+		t.Errorf("columns (%d bytes) no smaller than gzipped gob (%d bytes)",
+			len(blob.Blob), len(gobBlob.Blob))
+	}
+}
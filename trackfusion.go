@@ -0,0 +1,105 @@
+package flightdb
+
+// Track fusion: build a single best-estimate track out of all the tracks a flight has.
+//
+// Each track is weighted by its data system (see FusionWeights). Tracks are taken in order of
+// weight, best first, and each contributes only the points that don't conflict with what's
+// already in the fused track; a point conflicts if the fused track has a point within
+// KFusionWindow of it. So the best source wins wherever it has data, and the lesser sources
+// fill in its gaps. Tracks of equal weight are taken in the order given.
+//
+// Fused points are unaltered copies of the originals, so each still says where it came from
+// (via GetData{System|Provider}, or TrackKey for the name of the track).
+
+import(
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var(
+	// Higher is better. Anything not listed gets zero.
+	FusionWeights = map[DataSystem]int{
+		DSADSB:           4,
+		DSMLAT:           3,
+		DSCorrectedRadar: 2,
+		DSRadar:          1,
+	}
+
+	KFusionWindow = 10 * time.Second
+)
+
+// {{{ FuseTracks
+
+func FuseTracks(tracks ...Track) Track {
+	order := []int{}
+	for i,t := range tracks {
+		if len(t) > 0 { order = append(order, i) }
+	}
+	sort.SliceStable(order, func(a,b int) bool {
+		return FusionWeights[tracks[order[a]][0].GetDataSystem()] >
+			FusionWeights[tracks[order[b]][0].GetDataSystem()]
+	})
+
+	fused := Track{}
+	for _,i := range order {
+		contribution := Track{}
+		for _,tp := range tracks[i] {
+			if !fused.hasPointNear(tp.TimestampUTC, KFusionWindow) {
+				tp.Notes = "" // Only meaningful on the first point of its own track
+				contribution = append(contribution, tp)
+			}
+		}
+		fused = append(fused, contribution...)
+		sort.Stable(TrackByTimestampAscending(fused))
+	}
+
+	if len(fused) > 0 {
+		fused[0].Notes = "(fused: " + fused.OriginSummary() + ")"
+	}
+
+	return fused
+}
+
+// Assumes the track is in time order
+func (t Track)hasPointNear(tm time.Time, d time.Duration) bool {
+	i := sort.Search(len(t), func(i int) bool { return !t[i].TimestampUTC.Before(tm.Add(-d)) })
+	return i < len(t) && !t[i].TimestampUTC.After(tm.Add(d))
+}
+
+// }}}
+// {{{ t.OriginSummary
+
+// OriginSummary counts up where the points came from, e.g. "ADSB:120 MLAT:14"
+func (t Track)OriginSummary() string {
+	counts := map[string]int{}
+	for _,tp := range t { counts[tp.TrackKey()]++ }
+
+	keys := []string{}
+	for k := range counts { keys = append(keys, k) }
+	sort.Strings(keys)
+
+	strs := []string{}
+	for _,k := range keys { strs = append(strs, fmt.Sprintf("%s:%d", k, counts[k])) }
+	return strings.Join(strs, " ")
+}
+
+// }}}
+// {{{ f.FusedTrack
+
+// FusedTrack fuses all of the flight's tracks.
+func (f Flight)FusedTrack() Track {
+	_,t := f.TrackBySpec(TrackSpec{Fuse: true})
+	return t
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
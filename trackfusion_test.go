@@ -0,0 +1,48 @@
+package flightdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFuseTracks(t *testing.T) {
+	t0 := loadTrack(t1a)[0].TimestampUTC
+	pts := func(src string, secs ...int) Track {
+		ret := Track{}
+		for _,s := range secs {
+			ret = append(ret, Trackpoint{DataSource:src, TimestampUTC:t0.Add(time.Duration(s)*time.Second)})
+		}
+		return ret
+	}
+
+	// ADSB wins where it has data; MLAT fills the gap in the middle, and fr24 fills the end
+	adsb := pts("ADSB", 0, 1, 2, 3, 60, 61)
+	mlat := pts("MLAT", 2, 30, 40, 62)
+	fr24 := pts("fr24", 35, 100)
+
+	f := BlankFlight()
+	f.Tracks["ADSB"],f.Tracks["MLAT"],f.Tracks["fr24"] = &adsb,&mlat,&fr24
+	fused := f.FusedTrack()
+
+	origins := []string{}
+	for i,tp := range fused {
+		origins = append(origins, tp.TrackKey())
+		if i > 0 && tp.TimestampUTC.Before(fused[i-1].TimestampUTC) {
+			t.Errorf("fused track out of order at %d", i)
+		}
+	}
+	expected := []string{"ADSB", "ADSB", "ADSB", "ADSB", "MLAT", "MLAT", "ADSB", "ADSB", "fr24"}
+	if !reflect.DeepEqual(origins, expected) {
+		t.Errorf("fused origins: expected %v, saw %v", expected, origins)
+	}
+	if fused.OriginSummary() != "ADSB:6 MLAT:2 fr24:1" {
+		t.Errorf("summary: %q", fused.OriginSummary())
+	}
+
+	// Filters apply before fusion
+	ts,_ := ParseTrackSpec("provider=SkyPi,fused")
+	if name,t2 := f.TrackBySpec(ts); name != "fused" || len(t2) != 8 {
+		t.Errorf("filtered fusion: %q, %d points", name, len(t2))
+	}
+}
@@ -1,5 +1,7 @@
 package flightdb
 
+import "fmt"
+
 /* Cleanups ...
 
 // The creation flow
@@ -23,16 +25,19 @@ trackpoint.go     LongSource
 
 1. Identify all callsites of tp.DataSource; move to GetData{System|Provider} or stringifiers
 2. Some are to do with restricting operations to just locally received data, or to ADSB
-3. Prob need a more formal approach to 'trackspec', PreferredTrack et al.
+3. Prob need a more formal approach to 'trackspec', PreferredTrack et al. (see trackspec.go)
 4. What to do with track keynames ? Make them irrelevant - *always* pluck a track via a
     trackspec ??
 
-Intermediate goal:
+Intermediate goal (done):
 * no piece of code direactly accesses tp.DataSource, except this file (and CTORs)
 
-5. Add the Data{System|Provider} fields to fdb.Trackpoint
-6. Update CTORs to specify these fields, and not tp.DataSource
+5. Add the Data{System|Provider} fields to fdb.Trackpoint (done)
+6. Update CTORs to specify these fields (done). They still fill in tp.DataSource too, as a
+    label; for FOIA data it names the dataset, which the FOIA ACLs are written in terms of.
 7. Leave tp.DataSource as legacy / deprecated.
+8. Backfill the fields onto stored flights (the 'datafields' batch job, via
+    f.BackfillDataFields). Unbackfilled data still works, via the fallbacks below.
 
 End state
 * Everything uses these semantically clean methods, even on old crappy data
@@ -57,40 +62,108 @@ const(
 	DSCorrectedRadar  DataSystem = "F"
 )
 
+// The values that FOIA trackpoints have in DataSource; they name the dataset.
+var legacyFOIASources = []string{
+	"RG-FOIA", "EB-FOIA", // older hardcoded formats
+	"mtv-foia", "eastbay-foia", // newer, bucketname-derived formats
+}
+
+func isLegacyFOIASource(src string) bool {
+	for _,foia := range legacyFOIASources {
+		if src == foia { return true }
+	}
+	return src == "FOIA"
+}
+
 // These two functions serve as a backwards-compatibility layer
 func (tp Trackpoint)GetDataSystem() DataSystem {
-//	if tp.DataSystem != "" {
-//		return tp.DataSystem
-//	}
+	if tp.DataSystem != "" {
+		return tp.DataSystem
+	}
 	switch tp.DataSource {
 	case "ADSB":  return DSADSB
+	case "MLAT":  return DSMLAT
 	case "fr24":  return DSUnknown
 	case "FA:TZ": return DSRadar
 	case "FA:TA": return DSADSB
-	case "FOIA":  return DSCorrectedRadar
-	default:      return DSUnknown
 	}
+	if isLegacyFOIASource(tp.DataSource) { return DSCorrectedRadar }
+	return DSUnknown
 }
 
 func (tp Trackpoint)GetDataProvider() DataProvider {
-//	if tp.DataProvider != "" {
-//		return tp.DataProvider
-//	}
+	if tp.DataProvider != "" {
+		return tp.DataProvider
+	}
 	switch tp.DataSource {
 	case "ADSB":  return DPSkypi
+	case "MLAT":  return DPSkypi
 	case "fr24":  return DPFR24
 	case "FA:TZ": return DPFA
 	case "FA:TA": return DPFA
-	case "FOIA":  return DPFAAFOIA
-	default:      return DPUnknown
 	}
+	if isLegacyFOIASource(tp.DataSource) { return DPFAAFOIA }
+	return DPUnknown
+}
+
+// FOIADataset names the FAA FOIA dataset the point came from (e.g. "mtv-foia"), for checking
+// against FOIA ACLs. It is empty for non-FOIA data.
+func (tp Trackpoint)FOIADataset() string {
+	if tp.GetDataProvider() != DPFAAFOIA { return "" }
+	return tp.DataSource
+}
+
+// SourceLabel is a short description of where the point came from, for debug output and the
+// like. Don't parse it; use GetData{System|Provider}.
+func (tp Trackpoint)SourceLabel() string {
+	if tp.DataSource != "" { return tp.DataSource }
+	return fmt.Sprintf("%s:%s", tp.GetDataProvider(), tp.GetDataSystem())
+}
+
+// TrackKey is the name of the track that this point would be stored in, in fdb.Flight.Tracks
+func (tp Trackpoint)TrackKey() string {
+	switch dp,ds := tp.GetDataProvider(), tp.GetDataSystem(); dp {
+	case DPSkypi:
+		if ds == DSMLAT { return "MLAT" }
+		return "ADSB"
+	case DPFA:
+		if ds == DSRadar { return "FA:TZ" }
+		return "FA:TA"
+	case DPFR24:    return "fr24"
+	case DPFAAFOIA: return "FOIA"
+	}
+	return tp.DataSource
 }
 
-//func (tp Trackpoint)TrackKey() string {
-//	name := tp.GetDataProvider().String()
-//	return name
-//}
+// {{{ BackfillDataFields
+
+// BackfillDataFields fills in DataSystem and DataProvider on points that only have the legacy
+// DataSource, and says whether anything changed. Points we can't make sense of are left alone,
+// in case a future version of the fallbacks can.
+func (t Track)BackfillDataFields() bool {
+	changed := false
+	for i,tp := range t {
+		if tp.DataSystem == "" && tp.GetDataSystem() != DSUnknown {
+			t[i].DataSystem = tp.GetDataSystem()
+			changed = true
+		}
+		if tp.DataProvider == "" && tp.GetDataProvider() != DPUnknown {
+			t[i].DataProvider = tp.GetDataProvider()
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (f *Flight)BackfillDataFields() bool {
+	changed := false
+	for _,t := range f.Tracks {
+		if t.BackfillDataFields() { changed = true }
+	}
+	return changed
+}
 
+// }}}
 
 func (dp DataProvider)LongString() string {
 	switch dp {
@@ -0,0 +1,58 @@
+package flightdb
+
+import "testing"
+
+func TestDataSystemAndProvider(t *testing.T) {
+	tests := []struct{
+		tp Trackpoint
+		ds DataSystem
+		dp DataProvider
+		key string
+	}{
+		// Legacy points, with just a DataSource
+		{Trackpoint{DataSource:"ADSB"},     DSADSB,           DPSkypi,   "ADSB"},
+		{Trackpoint{DataSource:"MLAT"},     DSMLAT,           DPSkypi,   "MLAT"},
+		{Trackpoint{DataSource:"FA:TZ"},    DSRadar,          DPFA,      "FA:TZ"},
+		{Trackpoint{DataSource:"fr24"},     DSUnknown,        DPFR24,    "fr24"},
+		{Trackpoint{DataSource:"mtv-foia"}, DSCorrectedRadar, DPFAAFOIA, "FOIA"},
+		{Trackpoint{DataSource:"wibble"},   DSUnknown,        DPUnknown, "wibble"},
+
+		// The fields win, if present
+		{Trackpoint{DataSource:"fr24", DataSystem:DSMLAT, DataProvider:DPFR24}, DSMLAT, DPFR24, "fr24"},
+		{Trackpoint{DataSystem:DSRadar, DataProvider:DPFA},                     DSRadar, DPFA, "FA:TZ"},
+	}
+
+	for i,test := range tests {
+		if ds,dp := test.tp.GetDataSystem(), test.tp.GetDataProvider(); ds != test.ds || dp != test.dp {
+			t.Errorf("[%d] %q: expected %s/%s, saw %s/%s", i, test.tp.DataSource, test.ds, test.dp, ds, dp)
+		} else if key := test.tp.TrackKey(); key != test.key {
+			t.Errorf("[%d] %q: expected key %q, saw %q", i, test.tp.DataSource, test.key, key)
+		}
+	}
+
+	if foia := (Trackpoint{DataSource:"mtv-foia"}); foia.FOIADataset() != "mtv-foia" {
+		t.Errorf("FOIA dataset lost: %q", foia.FOIADataset())
+	} else if adsb := (Trackpoint{DataSource:"ADSB"}); adsb.FOIADataset() != "" {
+		t.Errorf("non-FOIA point has a dataset: %q", adsb.FOIADataset())
+	}
+}
+
+func TestBackfillDataFields(t *testing.T) {
+	f := BlankFlight()
+	adsb := Track{{DataSource:"ADSB"}, {DataSource:"MLAT"}}
+	odd := Track{{DataSource:"wibble"}}
+	f.Tracks["ADSB"],f.Tracks["odd"] = &adsb,&odd
+
+	if !f.BackfillDataFields() {
+		t.Fatalf("backfill changed nothing")
+	}
+	if adsb[0].DataSystem != DSADSB || adsb[1].DataSystem != DSMLAT || adsb[1].DataProvider != DPSkypi {
+		t.Errorf("backfill wrong: %#v", adsb)
+	}
+	if odd[0].DataSystem != "" || odd[0].DataProvider != "" {
+		t.Errorf("backfill guessed at unknown data: %#v", odd[0])
+	}
+	if f.BackfillDataFields() {
+		t.Errorf("second backfill changed things")
+	}
+}
@@ -10,10 +10,9 @@ import (
 
 // Trackpoint is a data point that locates an aircraft in space and time, etc
 type Trackpoint struct {
-	DataSource   string    // What kind of trackpoint is this; flightaware radar, local ADSB, etc
-
-	//DataSystem   // embedded string
-	//DataProvider // embedded string
+	DataSystem   DataSystem   // What kind of trackpoint is this; ADS-B, radar, etc
+	DataProvider DataProvider // Who gave it to us; flightaware, local receiver, etc
+	DataSource   string       // Legacy label (e.g. "ADSB", "FA:TZ", FOIA dataset); see trackpoint-data.go
 
 	ReceiverName string    // For local ADSB
 
@@ -40,6 +39,7 @@ type Trackpoint struct {
 	VerticalSpeedFPM          float64 `datastore:"-" json:"-"` // Feet per minute (~== VerticalRate)
 	VerticalAccelerationFPMPS float64 `datastore:"-" json:"-"` // In (feet per minute) per second
 	AngleOfInclination        float64 `datastore:"-" json:"-"` // In degrees. +ve means climbing
+	Phase                     FlightPhase `datastore:"-" json:"-"` // See t.ClassifyPhases
 	
 	// Populated just in first trackpoint, to hold transient notes for the whole track.
 	Notes                     string  `datastore:"-" json:"-"`
@@ -99,7 +99,7 @@ func (tp Trackpoint)String() string {
 func (tp Trackpoint)ToJSString() string {
 	return fmt.Sprintf("source:%q, receiver:%q, pos:{lat:%.6f,lng:%.6f}, "+
 		"alt:%.0f, speed:%.0f, track:%.0f, vert:%.0f, t:\"%s\"",
-		tp.DataSource, tp.ReceiverName, tp.Lat, tp.Long,
+		tp.SourceLabel(), tp.ReceiverName, tp.Lat, tp.Long,
 		tp.Altitude, tp.GroundSpeed, tp.Heading, tp.VerticalRate, tp.TimestampUTC)
 }
 
@@ -107,14 +107,19 @@ func (tp Trackpoint)ToJSString() string {
 // {{{ tp.LongSource
 
 func (tp Trackpoint)LongSource() string {
-	switch tp.DataSource {
-	case "":      return "(none specified)"
-	case "FA:TZ": return "FlightAware, Radar (TZ)"
-	case "FA:TA": return "FlightAware, ADS-B Mode-ES (TA)"
-	case "ADSB":  return "Private receiver, ADS-B Mode-ES ("+tp.ReceiverName+")"
-	case "MLAT":  return "MLAT ("+tp.ReceiverName+")"
+	dp,ds := tp.GetDataProvider(), tp.GetDataSystem()
+	if dp == DPUnknown && ds == DSUnknown {
+		if tp.DataSource == "" { return "(none specified)" }
+		return tp.DataSource
+	}
+
+	str := dp.LongString() + ", " + ds.LongString()
+	if tp.ReceiverName != "" {
+		str += " ("+tp.ReceiverName+")"
+	} else if dataset := tp.FOIADataset(); dataset != "" {
+		str += " ("+dataset+")"
 	}
-	return tp.DataSource
+	return str
 }
 
 // }}}
@@ -123,8 +128,9 @@ func (tp Trackpoint)LongSource() string {
 
 func TrackpointFromADSB(m *adsb.CompositeMsg) Trackpoint {
 	tp := Trackpoint{
-		//DataSystem: "ADSB",
 		DataSource: "ADSB",
+		DataSystem: DSADSB,
+		DataProvider: DPSkypi,
 		ReceiverName: m.ReceiverName,
 		TimestampUTC: m.GeneratedTimestampUTC,
 		Latlong: m.Position,
@@ -135,9 +141,9 @@ func TrackpointFromADSB(m *adsb.CompositeMsg) Trackpoint {
 		Squawk: m.Squawk,
 	}
 
-	// Need to really clean all this up
 	if m.IsMLAT() {
 		tp.DataSource = "MLAT"
+		tp.DataSystem = DSMLAT
 	}
 
 	return tp
@@ -202,6 +208,8 @@ func (from Trackpoint)InterpolateTo(to Trackpoint, ratio float64) InterpolatedTr
 		Post: &to,
 		Ratio: ratio,
 		Trackpoint: Trackpoint{
+			DataSystem: from.DataSystem,
+			DataProvider: from.DataProvider,
 			GroundSpeed: interpolateFloat64(from.GroundSpeed, to.GroundSpeed, ratio),
 			VerticalRate: interpolateFloat64(from.VerticalRate, to.VerticalRate, ratio),
 			Altitude: interpolateFloat64(from.Altitude, to.Altitude, ratio),
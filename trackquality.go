@@ -0,0 +1,185 @@
+package flightdb
+
+// Track segmentation and quality. A track is just a flat slice of points, but coverage is not
+// continuous; receivers drop out, and radar data has holes in it. Analyses that interpolate
+// across a gap of several minutes will happily make things up, so this code splits tracks into
+// segments at the big gaps, and measures how good the data is within each one.
+//
+// A QualityBar is a minimum standard, which can be applied to any stretch of track (e.g. the
+// window of a TrackIntersection). Its string form (e.g. for a &minquality= CGI arg) is a
+// comma-separated list of terms:
+//   rate=6          at least six updates per minute, on average
+//   gap=30s         no gap between points longer than this
+//   rejected=0.05   the sanity filter (t.AsSanityFilteredTrack) removes no more than 5% of points
+
+import(
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var(
+	// Gaps larger than these (in time, or in distance) split a track into separate segments.
+	KSegmentMaxGap   = 2 * time.Minute
+	KSegmentMaxGapKM = 10.0
+
+	// Within a segment, gaps larger than this are counted up as a quality metric.
+	KQualityGap      = 30 * time.Second
+)
+
+type TrackQuality struct {
+	NumPoints          int
+	Duration           time.Duration
+	UpdatesPerMinute   float64
+	NumGaps            int           // Gaps longer than KQualityGap
+	MaxGap             time.Duration
+	RejectedFrac       float64       // Share of points removed by the sanity filter
+}
+
+func (q TrackQuality)String() string {
+	return fmt.Sprintf("%d pts over %s, %.1f/min, %d gaps (max %s), %.0f%% rejected",
+		q.NumPoints, q.Duration, q.UpdatesPerMinute, q.NumGaps, q.MaxGap, q.RejectedFrac*100)
+}
+
+// A TrackSegment is a contiguous stretch of a track with no big gaps in it.
+type TrackSegment struct {
+	Track              Track         // Shares trackpoints with the original track
+	I,J                int           // Indices into the original track (inclusive)
+	Quality            TrackQuality
+}
+
+func (s TrackSegment)String() string {
+	return fmt.Sprintf("[%d,%d] %s", s.I, s.J, s.Quality)
+}
+
+// {{{ t.Segments
+
+// Segments splits the track wherever consecutive points are more than KSegmentMaxGap apart in
+// time, or KSegmentMaxGapKM apart in distance.
+func (t Track)Segments() []TrackSegment {
+	segs := []TrackSegment{}
+	if len(t) == 0 { return segs }
+
+	start := 0
+	for i:=1; i<=len(t); i++ {
+		if i < len(t) {
+			gap := t[i].TimestampUTC.Sub(t[i-1].TimestampUTC)
+			if gap <= KSegmentMaxGap && t[i].DistKM(t[i-1].Latlong) <= KSegmentMaxGapKM { continue }
+		}
+		segs = append(segs, TrackSegment{Track:t[start:i], I:start, J:i-1, Quality:t[start:i].Quality()})
+		start = i
+	}
+
+	return segs
+}
+
+// }}}
+// {{{ t.Quality, t.QualityBetween
+
+func (t Track)Quality() TrackQuality {
+	q := TrackQuality{NumPoints: len(t)}
+	if len(t) == 0 { return q }
+
+	q.Duration = t[len(t)-1].TimestampUTC.Sub(t[0].TimestampUTC)
+	if q.Duration > 0 {
+		q.UpdatesPerMinute = float64(len(t)-1) / q.Duration.Minutes()
+	}
+
+	for i:=1; i<len(t); i++ {
+		gap := t[i].TimestampUTC.Sub(t[i-1].TimestampUTC)
+		if gap > q.MaxGap { q.MaxGap = gap }
+		if gap > KQualityGap { q.NumGaps++ }
+	}
+
+	// The sanity filter needs distances, so work on a post-processed copy.
+	c := make(Track, len(t))
+	copy(c, t)
+	c.PostProcess()
+	q.RejectedFrac = float64(len(c) - len(c.AsSanityFilteredTrack())) / float64(len(c))
+
+	return q
+}
+
+// QualityBetween measures the stretch of track from i to j (inclusive). If j is not after i
+// (e.g. the J of a point TrackIntersection is zero), it measures from i to the next point.
+func (t Track)QualityBetween(i, j int) TrackQuality {
+	if i < 0 { i = 0 }
+	if j <= i { j = i+1 }
+	if j >= len(t) { j = len(t)-1 }
+	if i > j { return TrackQuality{} }
+	return t[i:j+1].Quality()
+}
+
+// }}}
+
+// {{{ QualityBar
+
+// A QualityBar is a minimum standard for a stretch of track. Zero fields aren't checked.
+type QualityBar struct {
+	MinUpdatesPerMinute  float64
+	MaxGap               time.Duration
+	MaxRejectedFrac      float64
+}
+
+func (bar QualityBar)IsNil() bool {
+	return bar.MinUpdatesPerMinute == 0 && bar.MaxGap == 0 && bar.MaxRejectedFrac == 0
+}
+
+// Meets returns false, and which part of the bar it failed, if the quality doesn't meet the bar.
+func (q TrackQuality)Meets(bar QualityBar) (bool, string) {
+	if bar.MinUpdatesPerMinute > 0 && q.UpdatesPerMinute < bar.MinUpdatesPerMinute {
+		return false, fmt.Sprintf("update rate below %g/min", bar.MinUpdatesPerMinute)
+	}
+	if bar.MaxGap > 0 && q.MaxGap > bar.MaxGap {
+		return false, fmt.Sprintf("gap longer than %s", bar.MaxGap)
+	}
+	if bar.MaxRejectedFrac > 0 && q.RejectedFrac > bar.MaxRejectedFrac {
+		return false, fmt.Sprintf("more than %g rejected", bar.MaxRejectedFrac)
+	}
+	return true, ""
+}
+
+// }}}
+// {{{ ParseQualityBar
+
+func ParseQualityBar(s string) (QualityBar, error) {
+	bar := QualityBar{}
+	for _,term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" { continue }
+
+		key,val,_ := strings.Cut(term, "=")
+		var err error
+		switch key {
+		case "rate":     bar.MinUpdatesPerMinute,err = strconv.ParseFloat(val, 64)
+		case "gap":      bar.MaxGap,err = time.ParseDuration(val)
+		case "rejected": bar.MaxRejectedFrac,err = strconv.ParseFloat(val, 64)
+		default:
+			return QualityBar{}, fmt.Errorf("ParseQualityBar: don't know what to do with %q", term)
+		}
+		if err != nil {
+			return QualityBar{}, fmt.Errorf("ParseQualityBar: %q: %v", term, err)
+		}
+	}
+	return bar, nil
+}
+
+// String is the inverse of ParseQualityBar.
+func (bar QualityBar)String() string {
+	terms := []string{}
+	if bar.MinUpdatesPerMinute > 0 { terms = append(terms, fmt.Sprintf("rate=%g", bar.MinUpdatesPerMinute)) }
+	if bar.MaxGap > 0 { terms = append(terms, "gap="+bar.MaxGap.String()) }
+	if bar.MaxRejectedFrac > 0 { terms = append(terms, fmt.Sprintf("rejected=%g", bar.MaxRejectedFrac)) }
+	return strings.Join(terms, ",")
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
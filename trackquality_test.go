@@ -0,0 +1,69 @@
+package flightdb
+
+import(
+	"testing"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+func TestSegments(t *testing.T) {
+	tr := Track{}
+	s := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	add := func(offset time.Duration, lat float64) {
+		tr = append(tr, Trackpoint{TimestampUTC:s.Add(offset), Latlong:geo.Latlong{Lat:lat, Long:-122.0}})
+	}
+
+	// Segment 1: 5s updates for a minute, with one 40s gap in it
+	for i:=0; i<=6; i++ { add(time.Duration(i*5)*time.Second, 37.0+float64(i)*0.001) }
+	add(70*time.Second, 37.007)
+	// A five minute dropout; segment 2
+	for i:=0; i<10; i++ { add(time.Duration(370+i*5)*time.Second, 37.02+float64(i)*0.001) }
+	// A jump of more than 10KM, with no time gap; segment 3
+	add(420*time.Second, 37.2)
+	add(425*time.Second, 37.201)
+
+	segs := tr.Segments()
+	if len(segs) != 3 {
+		t.Fatalf("expected 3 segments, got %d: %v", len(segs), segs)
+	}
+	if segs[0].I != 0 || segs[0].J != 7 || segs[1].I != 8 || segs[2].J != len(tr)-1 {
+		t.Errorf("segment boundaries wrong: %v", segs)
+	}
+
+	q := segs[0].Quality
+	if q.NumPoints != 8 || q.NumGaps != 1 || q.MaxGap != 40*time.Second {
+		t.Errorf("segment 0 quality wrong: %s", q)
+	}
+	if q.UpdatesPerMinute < 5.9 || q.UpdatesPerMinute > 6.1 {
+		t.Errorf("segment 0 update rate wrong: %s", q)
+	}
+	if q1 := segs[1].Quality; q1.NumGaps != 0 || q1.MaxGap != 5*time.Second || q1.RejectedFrac != 0 {
+		t.Errorf("segment 1 quality wrong: %s", q1)
+	}
+
+	// The whole track has the 5 minute gap, and a teleport that the sanity filter catches
+	whole := tr.Quality()
+	if whole.MaxGap != 300*time.Second || whole.RejectedFrac == 0 {
+		t.Errorf("whole track quality wrong: %s", whole)
+	}
+
+	bar,err := ParseQualityBar("rate=6,gap=30s")
+	if err != nil { t.Fatal(err) }
+	if bar.String() != "rate=6,gap=30s" {
+		t.Errorf("round trip: got %q", bar.String())
+	}
+	if ok,_ := segs[1].Quality.Meets(bar); !ok {
+		t.Errorf("segment 1 should meet %s", bar)
+	}
+	if ok,why := q.Meets(bar); ok || why != "gap longer than 30s" {
+		t.Errorf("segment 0 should fail %s on the gap, got %v %q", bar, ok, why)
+	}
+	if ok,_ := tr.QualityBetween(8, 0).Meets(bar); !ok {
+		t.Errorf("point window at 8 should meet %s", bar)
+	}
+
+	if _,err := ParseQualityBar("speed=fast"); err == nil {
+		t.Errorf("expected error for bad term")
+	}
+}
@@ -0,0 +1,167 @@
+package flightdb
+
+// Track simplification: drop the trackpoints that don't add much, so maps and vector JSON
+// don't have to ship every single point.
+//
+// This is Douglas-Peucker, applied in two dimensions at once. A dropped point's lateral error is
+// its distance (in a flat KM projection) from the line segment that replaces it; its vertical
+// error is the difference between its altitude and the altitude linearly interpolated (by time)
+// along that segment. The point that is worst, relative to the tolerances, gets kept, and we
+// recurse on either side, until every dropped point is within both tolerances.
+//
+// Some points are never dropped: the first and last, any point flagged for highlighting by
+// analysis (AnalysisDisplayHighlight), and any indices the caller asks to keep (e.g. the points
+// matched to waypoints; see f.WaypointIndices).
+
+import(
+	"math"
+	"sort"
+
+	"github.com/skypies/geo"
+)
+
+// Default vertical tolerance, for callers that only care to specify the lateral one.
+var KSimplifyVerticalFeet = 100.0
+
+// {{{ t.Simplify
+
+// Simplify returns the (ascending) indices of the trackpoints worth keeping, such that no
+// dropped point is more than lateralKM sideways, or verticalFeet up or down, from the
+// simplified track. A tolerance of zero means that dimension isn't considered; if both are
+// zero, every point is kept.
+func (t Track)Simplify(lateralKM, verticalFeet float64, mustKeep ...int) []int {
+	if len(t) == 0 { return []int{} }
+	if lateralKM <= 0 && verticalFeet <= 0 {
+		all := make([]int, len(t))
+		for i := range t { all[i] = i }
+		return all
+	}
+
+	keep := make([]bool, len(t))
+	keep[0], keep[len(t)-1] = true, true
+	for i,tp := range t {
+		if tp.AnalysisDisplay == AnalysisDisplayHighlight { keep[i] = true }
+	}
+	for _,i := range mustKeep {
+		if i >= 0 && i < len(t) { keep[i] = true }
+	}
+
+	proj := newFlatProjection(t[0].Latlong)
+	xs,ys := make([]float64, len(t)), make([]float64, len(t))
+	for i,tp := range t { xs[i],ys[i] = proj.project(tp.Latlong) }
+
+	// The error of point k against the segment i->j, as a multiple of the tolerance; so
+	// anything over 1.0 is out of bounds, in at least one dimension.
+	errorOf := func(i, j, k int) float64 {
+		e := 0.0
+		if lateralKM > 0 {
+			e = math.Max(e, distToSegment(xs[k],ys[k], xs[i],ys[i], xs[j],ys[j]) / lateralKM)
+		}
+		if verticalFeet > 0 {
+			alt := t[i].Altitude
+			if span := t[j].TimestampUTC.Sub(t[i].TimestampUTC); span > 0 {
+				frac := float64(t[k].TimestampUTC.Sub(t[i].TimestampUTC)) / float64(span)
+				alt += frac * (t[j].Altitude - t[i].Altitude)
+			}
+			e = math.Max(e, math.Abs(t[k].Altitude - alt) / verticalFeet)
+		}
+		return e
+	}
+
+	// Iterate over the spans between consecutive anchors, rather than recursing; long tracks
+	// that need little simplification would otherwise recurse very deeply.
+	anchors := []int{}
+	for i := range keep {
+		if keep[i] { anchors = append(anchors, i) }
+	}
+	type span struct { i, j int }
+	todo := []span{}
+	for n:=1; n<len(anchors); n++ {
+		todo = append(todo, span{anchors[n-1], anchors[n]})
+	}
+
+	for len(todo) > 0 {
+		s := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		worst, worstErr := -1, 1.0
+		for k:=s.i+1; k<s.j; k++ {
+			if e := errorOf(s.i, s.j, k); e > worstErr { worst, worstErr = k, e }
+		}
+		if worst < 0 { continue }
+
+		keep[worst] = true
+		todo = append(todo, span{s.i, worst}, span{worst, s.j})
+	}
+
+	ret := []int{}
+	for i := range keep {
+		if keep[i] { ret = append(ret, i) }
+	}
+	return ret
+}
+
+// Distance from (px,py) to the segment (ax,ay)->(bx,by).
+func distToSegment(px,py, ax,ay, bx,by float64) float64 {
+	dx,dy := bx-ax, by-ay
+	if lenSq := dx*dx + dy*dy; lenSq > 0 {
+		u := ((px-ax)*dx + (py-ay)*dy) / lenSq
+		u = math.Max(0, math.Min(1, u))
+		ax,ay = ax + u*dx, ay + u*dy
+	}
+	return math.Hypot(px-ax, py-ay)
+}
+
+// }}}
+// {{{ t.AsLinesSimplified
+
+// AsLinesSimplified is like AsLinesSampledEvery, but picks the points via t.Simplify. The
+// lines' I and J fields index into the original track.
+func (t Track)AsLinesSimplified(lateralKM, verticalFeet float64, mustKeep ...int) []geo.LatlongLine {
+	lines := []geo.LatlongLine{}
+
+	idx := t.Simplify(lateralKM, verticalFeet, mustKeep...)
+	for n:=1; n<len(idx); n++ {
+		i,j := idx[n-1], idx[n]
+		line := t[i].BuildLine(t[j].Latlong)
+		line.I,line.J = i,j
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// }}}
+// {{{ f.WaypointIndices
+
+// WaypointIndices returns the (ascending) indices of the points in the track that are closest
+// in time to each of the flight's waypoints. Waypoints outside the track's timespan are ignored.
+func (f Flight)WaypointIndices(t Track) []int {
+	if len(t) == 0 { return []int{} }
+
+	seen := map[int]bool{}
+	ret := []int{}
+	for _,tm := range f.Waypoints {
+		i := t.IndexAtTime(tm)
+		if i < 0 {
+			if !tm.Equal(t.End()) { continue }
+			i = len(t)-1
+		} else if i+1 < len(t) && t[i+1].TimestampUTC.Sub(tm) < tm.Sub(t[i].TimestampUTC) {
+			i++
+		}
+		if !seen[i] { seen[i] = true; ret = append(ret, i) }
+	}
+
+	sort.Ints(ret)
+	return ret
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,97 @@
+package flightdb
+
+import(
+	"math"
+	"testing"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// A straight, level line with a dogleg and a step climb in it
+func simplifyTestTrack() Track {
+	t := Track{}
+	s := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i:=0; i<100; i++ {
+		tp := Trackpoint{
+			TimestampUTC: s.Add(time.Duration(i) * 5 * time.Second),
+			Latlong: geo.Latlong{Lat:37.0, Long:-122.0 + float64(i)*0.002},
+			Altitude: 5000,
+		}
+		if i >= 50 { tp.Latlong.Lat += float64(i-50) * 0.002 } // turn north-east
+		if i >= 70 { tp.Altitude = 6000 }
+		t = append(t, tp)
+	}
+	return t
+}
+
+func TestSimplify(t *testing.T) {
+	tr := simplifyTestTrack()
+
+	if idx := tr.Simplify(0, 0); len(idx) != len(tr) {
+		t.Errorf("zero tolerance dropped points: kept %d/%d", len(idx), len(tr))
+	}
+
+	idx := tr.Simplify(0.05, 100)
+	if len(idx) > 10 {
+		t.Errorf("kept too many points (%d): %v", len(idx), idx)
+	}
+	if idx[0] != 0 || idx[len(idx)-1] != len(tr)-1 {
+		t.Errorf("endpoints not kept: %v", idx)
+	}
+	has := func(i int) bool {
+		for _,j := range idx { if i == j { return true } }
+		return false
+	}
+	if !has(50) { t.Errorf("dogleg at 50 not kept: %v", idx) }
+	if !has(69) || !has(70) { t.Errorf("step climb at 69/70 not kept: %v", idx) }
+
+	// Check every dropped point is within bounds of the simplified track
+	proj := newFlatProjection(tr[0].Latlong)
+	for n:=1; n<len(idx); n++ {
+		a,b := tr[idx[n-1]], tr[idx[n]]
+		ax,ay := proj.project(a.Latlong)
+		bx,by := proj.project(b.Latlong)
+		for k:=idx[n-1]+1; k<idx[n]; k++ {
+			px,py := proj.project(tr[k].Latlong)
+			if d := distToSegment(px,py, ax,ay, bx,by); d > 0.05 {
+				t.Errorf("point %d is %.3fKM off the simplified track", k, d)
+			}
+			frac := float64(tr[k].TimestampUTC.Sub(a.TimestampUTC)) / float64(b.TimestampUTC.Sub(a.TimestampUTC))
+			if dz := math.Abs(tr[k].Altitude - (a.Altitude + frac*(b.Altitude-a.Altitude))); dz > 100 {
+				t.Errorf("point %d is %.0fft off the simplified track", k, dz)
+			}
+		}
+	}
+
+	// Highlighted points, and explicitly requested ones, must survive
+	tr[23].AnalysisDisplay = AnalysisDisplayHighlight
+	idx = tr.Simplify(0.05, 100, 37)
+	has23, has37 := false, false
+	for _,i := range idx {
+		if i == 23 { has23 = true }
+		if i == 37 { has37 = true }
+	}
+	if !has23 || !has37 {
+		t.Errorf("highlighted/mustKeep points dropped: %v", idx)
+	}
+
+	lines := tr.AsLinesSimplified(0.05, 100)
+	if len(lines) == 0 || lines[0].I != 0 || lines[len(lines)-1].J != len(tr)-1 {
+		t.Errorf("AsLinesSimplified lines look wrong: %d lines", len(lines))
+	}
+}
+
+func TestWaypointIndices(t *testing.T) {
+	tr := simplifyTestTrack()
+	f := BlankFlight()
+	f.SetWaypoint("MIDDL", tr[40].TimestampUTC.Add(2 * time.Second)) // nearer 40 than 41
+	f.SetWaypoint("LATER", tr[60].TimestampUTC.Add(4 * time.Second)) // nearer 61
+	f.SetWaypoint("ENDPT", tr.End())
+	f.SetWaypoint("NEVER", tr.End().Add(time.Hour))
+
+	idx := f.WaypointIndices(tr)
+	if len(idx) != 3 || idx[0] != 40 || idx[1] != 61 || idx[2] != len(tr)-1 {
+		t.Errorf("WaypointIndices wrong: %v", idx)
+	}
+}
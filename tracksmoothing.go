@@ -0,0 +1,333 @@
+package flightdb
+
+// A motion-model smoother for tracks. Position (in a local flat projection) and altitude are
+// each run through a constant-velocity Kalman filter, and then a Rauch-Tung-Striebel smoother,
+// so every point's estimate uses all the data (both before and after it). Measurements are
+// gated on their Mahalanobis distance from the prediction; those that fail are rejected as
+// outliers, and don't influence the estimates. If several in a row fail, we assume the model
+// has lost the plot (e.g. a data gap across a sharp turn), and restart it from scratch (and
+// stop calling those points outliers).
+//
+// Measurement noise depends on the point's DataSystem (SmootherNoise); FOIA radar is much
+// noisier than ADS-B, and has 100ft altitude resolution.
+
+import(
+	"fmt"
+	"math"
+
+	"github.com/skypies/geo"
+)
+
+type SmootherNoise struct {
+	PositionKM   float64 // 1-sigma, per axis
+	AltitudeFeet float64
+}
+
+var(
+	SmootherNoises = map[DataSystem]SmootherNoise{
+		DSADSB:           {0.01, 25},
+		DSMLAT:           {0.10, 25},
+		DSRadar:          {0.25, 100},
+		DSCorrectedRadar: {0.25, 100},
+		DSUnknown:        {0.05, 50},
+	}
+
+	KSmootherHorizAccel    = 0.003  // Process noise: typical horizontal acceleration, km/s^2
+	KSmootherVertAccel     = 5.0    // Process noise: typical vertical acceleration, ft/s^2
+	KSmootherPositionGate  = 13.8   // Chi-square, 2 degrees of freedom, 99.9%
+	KSmootherAltitudeGate  = 10.8   // Chi-square, 1 degree of freedom, 99.9%
+	KSmootherMaxRejections = 3      // This many outliers in a row restarts the filter
+)
+
+// SmoothedState is the smoother's estimate at the time of one trackpoint.
+type SmoothedState struct {
+	geo.Latlong
+	Altitude           float64 // Feet
+	GroundSpeed        float64 // Knots
+	Heading            float64 // Degrees; [0,360). Direction of travel, not of the nose.
+	VerticalRate       float64 // Feet per minute
+
+	// The 1-sigma uncertainties of the above
+	PositionSigmaKM      float64 // Radial
+	AltitudeSigmaFeet    float64
+	GroundSpeedSigmaKts  float64
+	VerticalRateSigmaFPM float64
+
+	PositionOutlier    bool    // The trackpoint's position was rejected
+	AltitudeOutlier    bool    // The trackpoint's altitude was rejected
+}
+
+// {{{ mat2
+
+type mat2 [2][2]float64
+
+func (a mat2)mul(b mat2) mat2 {
+	return mat2{
+		{a[0][0]*b[0][0] + a[0][1]*b[1][0], a[0][0]*b[0][1] + a[0][1]*b[1][1]},
+		{a[1][0]*b[0][0] + a[1][1]*b[1][0], a[1][0]*b[0][1] + a[1][1]*b[1][1]},
+	}
+}
+func (a mat2)add(b mat2) mat2 {
+	return mat2{{a[0][0]+b[0][0], a[0][1]+b[0][1]}, {a[1][0]+b[1][0], a[1][1]+b[1][1]}}
+}
+func (a mat2)sub(b mat2) mat2 {
+	return mat2{{a[0][0]-b[0][0], a[0][1]-b[0][1]}, {a[1][0]-b[1][0], a[1][1]-b[1][1]}}
+}
+func (a mat2)t() mat2 { return mat2{{a[0][0], a[1][0]}, {a[0][1], a[1][1]}} }
+func (a mat2)inv() mat2 {
+	det := a[0][0]*a[1][1] - a[0][1]*a[1][0]
+	if det == 0 { return mat2{} }
+	return mat2{{a[1][1]/det, -a[0][1]/det}, {-a[1][0]/det, a[0][0]/det}}
+}
+func (a mat2)apply(v [2]float64) [2]float64 {
+	return [2]float64{a[0][0]*v[0] + a[0][1]*v[1], a[1][0]*v[0] + a[1][1]*v[1]}
+}
+
+// }}}
+// {{{ flatProjection
+
+// A flat projection around an origin point, in KM; plenty good enough for one flight.
+type flatProjection struct {
+	origin               geo.Latlong
+	kmPerLat, kmPerLong  float64
+}
+
+func newFlatProjection(origin geo.Latlong) flatProjection {
+	return flatProjection{
+		origin: origin,
+		kmPerLat: 110.574,
+		kmPerLong: 111.320 * math.Cos(origin.Lat * math.Pi/180.0),
+	}
+}
+
+func (p flatProjection)project(pos geo.Latlong) (float64, float64) {
+	return (pos.Long - p.origin.Long) * p.kmPerLong, (pos.Lat - p.origin.Lat) * p.kmPerLat
+}
+
+func (p flatProjection)unproject(x, y float64) geo.Latlong {
+	return geo.Latlong{Lat: p.origin.Lat + y/p.kmPerLat, Long: p.origin.Long + x/p.kmPerLong}
+}
+
+// }}}
+// {{{ kalmanAxis
+
+// A constant-velocity Kalman filter along one axis; the state is {position, velocity}, and
+// only position is measured. It keeps its history, for the smoother.
+type kalmanAxis struct {
+	x        [2]float64
+	P        mat2
+
+	xp, xf [][2]float64 // Predicted and filtered states, per point
+	Pp, Pf []mat2
+	dt     []float64    // Time since the previous point
+	reset  []bool       // The filter was (re)started at this point
+}
+
+func (a *kalmanAxis)start(z, r, velSigma float64) {
+	a.x = [2]float64{z, 0}
+	a.P = mat2{{r*r, 0}, {0, velSigma*velSigma}}
+}
+
+func (a *kalmanAxis)predict(dt, q float64) {
+	F := mat2{{1, dt}, {0, 1}}
+	Q := mat2{{q*dt*dt*dt/3, q*dt*dt/2}, {q*dt*dt/2, q*dt}}
+	a.x = F.apply(a.x)
+	a.P = F.mul(a.P).mul(F.t()).add(Q)
+
+	a.xp,a.Pp,a.dt = append(a.xp, a.x), append(a.Pp, a.P), append(a.dt, dt)
+}
+
+// The innovation (measurement residual), and its variance
+func (a *kalmanAxis)innovation(z, r float64) (float64, float64) {
+	return z - a.x[0], a.P[0][0] + r*r
+}
+
+func (a *kalmanAxis)update(z, r float64) {
+	y,s := a.innovation(z, r)
+	k := [2]float64{a.P[0][0]/s, a.P[1][0]/s}
+	a.x = [2]float64{a.x[0] + k[0]*y, a.x[1] + k[1]*y}
+	a.P = mat2{
+		{(1-k[0])*a.P[0][0], (1-k[0])*a.P[0][1]},
+		{a.P[1][0] - k[1]*a.P[0][0], a.P[1][1] - k[1]*a.P[0][1]},
+	}
+}
+
+func (a *kalmanAxis)record(reset bool) {
+	a.xf,a.Pf,a.reset = append(a.xf, a.x), append(a.Pf, a.P), append(a.reset, reset)
+}
+
+// smooth runs the RTS smoother back over the history. Restarts are treated as boundaries.
+func (a *kalmanAxis)smooth() ([][2]float64, []mat2) {
+	n := len(a.xf)
+	xs,Ps := make([][2]float64, n), make([]mat2, n)
+	for k := n-1; k >= 0; k-- {
+		if k == n-1 || a.reset[k+1] {
+			xs[k],Ps[k] = a.xf[k],a.Pf[k]
+			continue
+		}
+		F := mat2{{1, a.dt[k+1]}, {0, 1}}
+		C := a.Pf[k].mul(F.t()).mul(a.Pp[k+1].inv())
+		d := C.apply([2]float64{xs[k+1][0] - a.xp[k+1][0], xs[k+1][1] - a.xp[k+1][1]})
+		xs[k] = [2]float64{a.xf[k][0] + d[0], a.xf[k][1] + d[1]}
+		Ps[k] = a.Pf[k].add(C.mul(Ps[k+1].sub(a.Pp[k+1])).mul(C.t()))
+	}
+	return xs, Ps
+}
+
+// }}}
+
+// {{{ t.Smooth
+
+// Smooth returns the smoothed state at each trackpoint. The track should be in time order.
+func (t Track)Smooth() []SmoothedState {
+	if len(t) == 0 { return []SmoothedState{} }
+
+	proj := newFlatProjection(t[0].Latlong)
+
+	noise := func(tp Trackpoint) SmootherNoise {
+		if n,exists := SmootherNoises[tp.GetDataSystem()]; exists { return n }
+		return SmootherNoises[DSUnknown]
+	}
+
+	qH := KSmootherHorizAccel * KSmootherHorizAccel
+	qV := KSmootherVertAccel * KSmootherVertAccel
+	ax,ay,alt := kalmanAxis{}, kalmanAxis{}, kalmanAxis{}
+	out := make([]SmoothedState, len(t))
+	posRejects,altRejects := 0,0
+
+	for i,tp := range t {
+		n := noise(tp)
+		x,y := proj.project(tp.Latlong)
+
+		if i == 0 {
+			ax.start(x, n.PositionKM, 0.3)       // ~600 knots
+			ay.start(y, n.PositionKM, 0.3)
+			alt.start(tp.Altitude, n.AltitudeFeet, 100.0) // ~6000 fpm
+			for _,a := range []*kalmanAxis{&ax, &ay, &alt} {
+				a.predict(0, 0)
+				a.record(true)
+			}
+			continue
+		}
+
+		dt := tp.TimestampUTC.Sub(t[i-1].TimestampUTC).Seconds()
+		ax.predict(dt, qH)
+		ay.predict(dt, qH)
+		alt.predict(dt, qV)
+
+		// Position
+		yx,sx := ax.innovation(x, n.PositionKM)
+		yy,sy := ay.innovation(y, n.PositionKM)
+		reset := false
+		if yx*yx/sx + yy*yy/sy <= KSmootherPositionGate {
+			ax.update(x, n.PositionKM)
+			ay.update(y, n.PositionKM)
+			posRejects = 0
+		} else if posRejects++; posRejects >= KSmootherMaxRejections {
+			ax.start(x, n.PositionKM, 0.3)
+			ay.start(y, n.PositionKM, 0.3)
+			posRejects,reset = 0,true
+			for j:=i-1; j>=0 && out[j].PositionOutlier; j-- { out[j].PositionOutlier = false }
+		} else {
+			out[i].PositionOutlier = true
+		}
+		ax.record(reset)
+		ay.record(reset)
+
+		// Altitude
+		ya,sa := alt.innovation(tp.Altitude, n.AltitudeFeet)
+		reset = false
+		if ya*ya/sa <= KSmootherAltitudeGate {
+			alt.update(tp.Altitude, n.AltitudeFeet)
+			altRejects = 0
+		} else if altRejects++; altRejects >= KSmootherMaxRejections {
+			alt.start(tp.Altitude, n.AltitudeFeet, 100.0)
+			altRejects,reset = 0,true
+			for j:=i-1; j>=0 && out[j].AltitudeOutlier; j-- { out[j].AltitudeOutlier = false }
+		} else {
+			out[i].AltitudeOutlier = true
+		}
+		alt.record(reset)
+	}
+
+	xs,Pxs := ax.smooth()
+	ys,Pys := ay.smooth()
+	as,Pas := alt.smooth()
+
+	for i := range out {
+		vx,vy := xs[i][1], ys[i][1] // KM per sec
+		speed := math.Sqrt(vx*vx + vy*vy)
+
+		// Project the velocity variance onto the direction of travel
+		speedVar := (Pxs[i][1][1] + Pys[i][1][1]) / 2
+		if speed > 0 {
+			ux,uy := vx/speed, vy/speed
+			speedVar = ux*ux*Pxs[i][1][1] + uy*uy*Pys[i][1][1]
+		}
+
+		out[i].Latlong = proj.unproject(xs[i][0], ys[i][0])
+		out[i].Altitude = as[i][0]
+		out[i].GroundSpeed = speed * 3600.0 / 1.852
+		out[i].Heading = math.Mod(math.Atan2(vx, vy) * 180.0/math.Pi + 360.0, 360.0)
+		out[i].VerticalRate = as[i][1] * 60.0
+
+		out[i].PositionSigmaKM = math.Sqrt(Pxs[i][0][0] + Pys[i][0][0])
+		out[i].AltitudeSigmaFeet = math.Sqrt(Pas[i][0][0])
+		out[i].GroundSpeedSigmaKts = math.Sqrt(speedVar) * 3600.0 / 1.852
+		out[i].VerticalRateSigmaFPM = math.Sqrt(Pas[i][1][1]) * 60.0
+	}
+
+	return out
+}
+
+// }}}
+// {{{ t.PostProcessSmoothed
+
+// PostProcessSmoothed is like PostProcess, but derives the fields from the smoothed estimates,
+// which makes them far less noisy (especially rates of change, and FOIA groundspeeds). The raw
+// positions and altitudes are left alone; rejected outliers are noted in AnalysisAnnotation.
+func (t Track)PostProcessSmoothed() {
+	if len(t) == 0 { return }
+	s := t.Smooth()
+
+	isFAA := t.DataSourceIsFAA()
+	nOutliers := 0
+	for i := range t {
+		if s[i].PositionOutlier || s[i].AltitudeOutlier {
+			t[i].AnalysisAnnotation += fmt.Sprintf("* smoother: rejected outlier (position:%v, "+
+				"altitude:%v); estimate %s, %.0fft\n", s[i].PositionOutlier, s[i].AltitudeOutlier,
+				s[i].Latlong, s[i].Altitude)
+			nOutliers++
+		}
+
+		if isFAA {
+			t[i].GroundSpeed = s[i].GroundSpeed // FOIA data has no groundspeed data
+		}
+		t[i].VerticalSpeedFPM = s[i].VerticalRate
+
+		// AngleOfInclination; horizontal speed=adjacent, vertical speed=opposite
+		horizKMPerMin := s[i].GroundSpeed * 1.852 / 60.0
+		vertKMPerMin := s[i].VerticalRate / geo.KFeetPerKM
+		t[i].AngleOfInclination = math.Atan2(vertKMPerMin, horizKMPerMin) * 180.0/math.Pi
+
+		if i == 0 { continue }
+		t[i].DistanceTravelledKM = t[i-1].DistanceTravelledKM + s[i].DistKM(s[i-1].Latlong)
+		if dur := t[i].TimestampUTC.Sub(t[i-1].TimestampUTC).Seconds(); dur > 0 {
+			t[i].GroundAccelerationKPS = (s[i].GroundSpeed - s[i-1].GroundSpeed) / dur
+			t[i].VerticalAccelerationFPMPS = (s[i].VerticalRate - s[i-1].VerticalRate) / dur
+		}
+	}
+
+	if isFAA { t[0].Notes += "(groundspeeds derived from smoothed positions)" }
+	if nOutliers > 0 { t[0].Notes += fmt.Sprintf("(smoother rejected %d outliers)", nOutliers) }
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
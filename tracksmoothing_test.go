@@ -0,0 +1,110 @@
+package flightdb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// A FOIA-like track: every 5s, heading 045 at 240 knots, climbing at 1200 fpm, with radar
+// sized noise, and a couple of wild points.
+func noisyClimb(n int) (Track, []Trackpoint) {
+	rng := rand.New(rand.NewSource(1))
+	start := geo.Latlong{Lat:37.5, Long:-122.2}
+	t0 := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	truth := []Trackpoint{}
+	t := Track{}
+	for i:=0; i<n; i++ {
+		secs := float64(i * 5)
+		tp := Trackpoint{
+			DataSystem: DSCorrectedRadar,
+			TimestampUTC: t0.Add(time.Duration(secs) * time.Second),
+			Latlong: start.MoveKM(45.0, secs * 240.0 * 1.852 / 3600.0),
+			Altitude: 3000.0 + secs * 20.0,
+		}
+		truth = append(truth, tp)
+
+		tp.Latlong = tp.Latlong.MoveKM(rng.Float64()*360.0, math.Abs(rng.NormFloat64()) * 0.15)
+		tp.Altitude = 100.0 * math.Round((tp.Altitude + rng.NormFloat64()*50.0) / 100.0)
+		t = append(t, tp)
+	}
+
+	t[20].Latlong = t[20].Latlong.MoveKM(135.0, 5.0)
+	t[30].Altitude += 4000.0
+
+	return t, truth
+}
+
+func TestSmooth(t *testing.T) {
+	track,truth := noisyClimb(60)
+	s := track.Smooth()
+	if len(s) != len(track) { t.Fatalf("expected %d states, saw %d", len(track), len(s)) }
+
+	if !s[20].PositionOutlier || s[20].AltitudeOutlier {
+		t.Errorf("point 20 should be a position outlier: %+v", s[20])
+	}
+	if !s[30].AltitudeOutlier || s[30].PositionOutlier {
+		t.Errorf("point 30 should be an altitude outlier: %+v", s[30])
+	}
+
+	for i := 5; i < len(s)-5; i++ {
+		if i == 20 || i == 30 { continue }
+		if s[i].PositionOutlier || s[i].AltitudeOutlier {
+			t.Errorf("[%d] unexpected outlier: %+v", i, s[i])
+		}
+		if d := s[i].DistKM(truth[i].Latlong); d > 0.15 {
+			t.Errorf("[%d] smoothed position %.3fKM off", i, d)
+		}
+		if math.Abs(s[i].Altitude - truth[i].Altitude) > 60 {
+			t.Errorf("[%d] smoothed altitude %.0f, expected %.0f", i, s[i].Altitude, truth[i].Altitude)
+		}
+		if math.Abs(s[i].GroundSpeed - 240.0) > 15 || math.Abs(s[i].Heading - 45.0) > 5 {
+			t.Errorf("[%d] smoothed velocity %.0fkts @ %.0fdeg", i, s[i].GroundSpeed, s[i].Heading)
+		}
+		// 100ft altitude resolution every 5s makes vertical rates hard to pin down
+		if math.Abs(s[i].VerticalRate - 1200.0) > 400 || s[i].VerticalRateSigmaFPM > 600 {
+			t.Errorf("[%d] smoothed vertical rate %.0f (+/-%.0f) fpm", i, s[i].VerticalRate, s[i].VerticalRateSigmaFPM)
+		}
+		if s[i].PositionSigmaKM <= 0 || s[i].PositionSigmaKM > 0.25 || s[i].GroundSpeedSigmaKts <= 0 {
+			t.Errorf("[%d] odd uncertainties: %+v", i, s[i])
+		}
+	}
+
+	// A real change of course shouldn't be thrown away for good
+	turn := append(Track{}, track[:40]...)
+	for i := 40; i < 60; i++ {
+		tp := track[i]
+		tp.Latlong = tp.Latlong.MoveKM(315.0, float64(i-39) * 4.0)
+		turn = append(turn, tp)
+	}
+	if s := turn.Smooth(); s[59].PositionOutlier || s[59].DistKM(turn[59].Latlong) > 0.3 {
+		t.Errorf("smoother never recovered from the turn: %+v", s[59])
+	}
+}
+
+func TestPostProcessSmoothed(t *testing.T) {
+	raw,_ := noisyClimb(60)
+	smoothed := append(Track{}, raw...)
+	raw.PostProcess()
+	smoothed.PostProcessSmoothed()
+
+	// The whole point: derived rates are much calmer
+	spread := func(t Track, get func(Trackpoint) float64) float64 {
+		lo,hi := math.Inf(1), math.Inf(-1)
+		for _,tp := range t[5:len(t)-5] {
+			lo,hi = math.Min(lo, get(tp)), math.Max(hi, get(tp))
+		}
+		return hi - lo
+	}
+	vAccel := func(tp Trackpoint) float64 { return tp.VerticalAccelerationFPMPS }
+	if r,s := spread(raw, vAccel), spread(smoothed, vAccel); s > r/10 {
+		t.Errorf("vertical acceleration spread: raw %.0f, smoothed %.0f", r, s)
+	}
+	if smoothed[20].AnalysisAnnotation == "" {
+		t.Errorf("outlier not annotated")
+	}
+}
@@ -0,0 +1,259 @@
+package flightdb
+
+// A TrackSpec says which of a flight's tracks to use. Everything that needs to pick a track
+// (analysis, reports, the UI) should go via f.TrackBySpec, so they all pick the same way.
+//
+// The string form (e.g. for a &trackspec= CGI arg) is a comma-separated list of terms:
+//   ADSB,MLAT,FOIA      track names, in order of preference
+//   FA                  a name prefix; matches FA:TA and FA:TZ (alphabetically)
+//   *                   any other track, in DefaultTrackOrder (then alphabetically)
+//   system=A|M          only tracks whose DataSystem is one of these
+//   provider=SkyPi|fr24 only tracks whose DataProvider is one of these
+//   min=20              only tracks with at least this many points
+//   merge               merge all the tracks that match, rather than picking the first
+//   fused               fuse all the tracks that match into a best estimate (see trackfusion.go)
+// A spec with no names at all is treated as "*".
+
+import(
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The order we look at tracks in when nobody has expressed a preference.
+var DefaultTrackOrder = []string{"ADSB", "MLAT", "FA:TA", "FA:TZ", "fr24", "FOIA"}
+
+type TrackSpec struct {
+	Names       []string       // In order of preference; may include "*"
+	Systems     []DataSystem   // If not empty, the track's system must be one of these
+	Providers   []DataProvider // If not empty, the track's provider must be one of these
+	MinPoints     int
+	Merge         bool
+	Fuse          bool           // Takes precedence over Merge
+}
+
+// NewTrackSpec is a spec that prefers the named tracks, in that order.
+func NewTrackSpec(names ...string) TrackSpec {
+	return TrackSpec{Names: names}
+}
+
+// WithDefaultNames fills in the names, if the spec doesn't have any (e.g. it only had filters).
+func (ts TrackSpec)WithDefaultNames(names ...string) TrackSpec {
+	if len(ts.Names) == 0 { ts.Names = names }
+	return ts
+}
+
+func (ts TrackSpec)IsNil() bool {
+	return len(ts.Names) == 0 && len(ts.Systems) == 0 && len(ts.Providers) == 0 &&
+		ts.MinPoints == 0 && !ts.Merge && !ts.Fuse
+}
+
+// {{{ ParseTrackSpec
+
+func ParseTrackSpec(s string) (TrackSpec, error) {
+	ts := TrackSpec{}
+	for _,term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" { continue }
+
+		key,val,hasVal := strings.Cut(term, "=")
+		switch {
+		case !hasVal && term == "merge":
+			ts.Merge = true
+		case !hasVal && term == "fused":
+			ts.Fuse = true
+		case !hasVal:
+			ts.Names = append(ts.Names, term)
+		case key == "system":
+			for _,v := range strings.Split(val, "|") { ts.Systems = append(ts.Systems, DataSystem(v)) }
+		case key == "provider":
+			for _,v := range strings.Split(val, "|") { ts.Providers = append(ts.Providers, DataProvider(v)) }
+		case key == "min":
+			n,err := strconv.Atoi(val)
+			if err != nil || n < 0 { return TrackSpec{}, fmt.Errorf("ParseTrackSpec: bad min %q", val) }
+			ts.MinPoints = n
+		default:
+			return TrackSpec{}, fmt.Errorf("ParseTrackSpec: don't know what to do with %q", term)
+		}
+	}
+	return ts, nil
+}
+
+// }}}
+// {{{ ts.String
+
+// String is the inverse of ParseTrackSpec.
+func (ts TrackSpec)String() string {
+	terms := append([]string{}, ts.Names...)
+	if len(ts.Systems) > 0 {
+		strs := []string{}
+		for _,s := range ts.Systems { strs = append(strs, string(s)) }
+		terms = append(terms, "system="+strings.Join(strs, "|"))
+	}
+	if len(ts.Providers) > 0 {
+		strs := []string{}
+		for _,p := range ts.Providers { strs = append(strs, string(p)) }
+		terms = append(terms, "provider="+strings.Join(strs, "|"))
+	}
+	if ts.MinPoints > 0 { terms = append(terms, fmt.Sprintf("min=%d", ts.MinPoints)) }
+	if ts.Merge { terms = append(terms, "merge") }
+	if ts.Fuse { terms = append(terms, "fused") }
+	return strings.Join(terms, ",")
+}
+
+// }}}
+// {{{ ts.accepts
+
+func (ts TrackSpec)accepts(t *Track) bool {
+	if t == nil || len(*t) == 0 || len(*t) < ts.MinPoints { return false }
+
+	if len(ts.Systems) > 0 {
+		found := false
+		for _,s := range ts.Systems {
+			if (*t)[0].GetDataSystem() == s { found = true; break }
+		}
+		if !found { return false }
+	}
+	if len(ts.Providers) > 0 {
+		found := false
+		for _,p := range ts.Providers {
+			if (*t)[0].GetDataProvider() == p { found = true; break }
+		}
+		if !found { return false }
+	}
+
+	return true
+}
+
+// }}}
+
+// {{{ ts.Intersect
+
+// Intersect narrows the spec down to the tracks that the other spec also allows; e.g. for a
+// report that can only use some sources, but will follow the user's preferences within those.
+// The names (and their order) come from ts, limited to what the other spec names; both specs'
+// filters apply. It returns false if no track could match both.
+func (ts TrackSpec)Intersect(other TrackSpec) (TrackSpec, bool) {
+	mine,theirs := ts.Names, other.Names
+	if len(mine) == 0 { mine = []string{"*"} }
+	if len(theirs) == 0 { theirs = []string{"*"} }
+
+	allowed := func(name string) bool {
+		for _,n := range theirs {
+			if n == "*" || n == name || strings.HasPrefix(name, n+":") { return true }
+		}
+		return false
+	}
+
+	ret := TrackSpec{
+		MinPoints: max(ts.MinPoints, other.MinPoints),
+		Merge: ts.Merge || other.Merge,
+		Fuse: ts.Fuse || other.Fuse,
+	}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] { ret.Names = append(ret.Names, name) }
+		seen[name] = true
+	}
+	for _,name := range mine {
+		if name == "*" {
+			for _,n := range theirs { add(n) }
+		} else if allowed(name) {
+			add(name)
+		} else {
+			for _,n := range theirs { // A prefix of theirs, e.g. "FA" when they allow "FA:TA"
+				if strings.HasPrefix(n, name+":") { add(n) }
+			}
+		}
+	}
+
+	var okS,okP bool
+	ret.Systems,okS = intersectFilters(ts.Systems, other.Systems)
+	ret.Providers,okP = intersectFilters(ts.Providers, other.Providers)
+
+	return ret, len(ret.Names) > 0 && okS && okP
+}
+
+// An empty filter allows everything.
+func intersectFilters[T comparable](a, b []T) ([]T, bool) {
+	if len(a) == 0 { return b, true }
+	if len(b) == 0 { return a, true }
+	ret := []T{}
+	for _,x := range a {
+		for _,y := range b {
+			if x == y { ret = append(ret, x); break }
+		}
+	}
+	return ret, len(ret) > 0
+}
+
+// }}}
+
+// {{{ f.TrackNamesBySpec
+
+// TrackNamesBySpec lists the names of the flight's tracks that match the spec, most preferred
+// first.
+func (f Flight)TrackNamesBySpec(ts TrackSpec) []string {
+	names := ts.Names
+	if len(names) == 0 { names = []string{"*"} }
+
+	seen := map[string]bool{}
+	ret := []string{}
+	add := func(name string) {
+		if seen[name] || !ts.accepts(f.Tracks[name]) { return }
+		seen[name] = true
+		ret = append(ret, name)
+	}
+
+	for _,name := range names {
+		if name == "*" {
+			for _,dflt := range DefaultTrackOrder { add(dflt) }
+			for _,other := range f.ListTracks() { add(other) }
+			continue
+		}
+
+		add(name)
+		for _,other := range f.ListTracks() {
+			if strings.HasPrefix(other, name+":") { add(other) }
+		}
+	}
+
+	return ret
+}
+
+// }}}
+// {{{ f.TrackBySpec
+
+// TrackBySpec returns the best matching track (or, for merge and fuse specs, all the matching
+// tracks combined into one) and its name. The name is empty if nothing matched. Merged and
+// fused tracks are copies; otherwise the track shares trackpoints with the flight.
+func (f Flight)TrackBySpec(ts TrackSpec) (string, Track) {
+	names := f.TrackNamesBySpec(ts)
+	if len(names) == 0 { return "", Track{} }
+
+	if ts.Fuse {
+		tracks := []Track{}
+		for _,name := range names { tracks = append(tracks, *f.Tracks[name]) }
+		return "fused", FuseTracks(tracks...)
+	}
+
+	if !ts.Merge || len(names) == 1 {
+		return names[0], *f.Tracks[names[0]]
+	}
+
+	merged := Track{}
+	for _,name := range names {
+		merged.Merge(f.Tracks[name])
+	}
+	return strings.Join(names, "+"), merged
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,99 @@
+package flightdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTrackSpec(t *testing.T) {
+	for _,str := range []string{"ADSB,MLAT", "*,system=A|M,provider=SkyPi,min=20,merge", "FA", ""} {
+		ts,err := ParseTrackSpec(str)
+		if err != nil {
+			t.Errorf("%q: %v", str, err)
+		} else if ts.String() != str {
+			t.Errorf("%q: round trip gave %q", str, ts.String())
+		}
+	}
+
+	for _,str := range []string{"min=lots", "wibble=1"} {
+		if _,err := ParseTrackSpec(str); err == nil {
+			t.Errorf("%q: expected an error", str)
+		}
+	}
+}
+
+func TestTrackBySpec(t *testing.T) {
+	f := BlankFlight()
+	adsb := loadTrack(t1a)
+	mlat := Track{{DataSource:"MLAT", TimestampUTC:adsb[0].TimestampUTC.Add(-1)}}
+	fata := Track{{DataSource:"FA:TA"}}
+	for i := range adsb { adsb[i].DataSource = "ADSB" }
+	f.Tracks["ADSB"],f.Tracks["MLAT"],f.Tracks["FA:TA"],f.Tracks["empty"] = &adsb,&mlat,&fata,&Track{}
+
+	tests := []struct{
+		spec  string
+		names []string
+	}{
+		{"",               []string{"ADSB", "MLAT", "FA:TA"}},
+		{"MLAT,ADSB",      []string{"MLAT", "ADSB"}},
+		{"FA,fr24",        []string{"FA:TA"}},  // Prefixes work; missing tracks are skipped
+		{"empty",          []string{}},         // Empty tracks never match
+		{"*,system=M",     []string{"MLAT"}},
+		{"*,provider=FA",  []string{"FA:TA"}},
+		{"*,min=2",        []string{"ADSB"}},
+		{"MLAT,*",         []string{"MLAT", "ADSB", "FA:TA"}},
+	}
+	for _,test := range tests {
+		ts,_ := ParseTrackSpec(test.spec)
+		if names := f.TrackNamesBySpec(ts); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%q: expected %v, saw %v", test.spec, test.names, names)
+		}
+	}
+
+	ts,_ := ParseTrackSpec("ADSB,MLAT,merge")
+	name,merged := f.TrackBySpec(ts)
+	if name != "ADSB+MLAT" || len(merged) != len(adsb)+1 || merged[0].DataSource != "MLAT" {
+		t.Errorf("merge: got %q, %d points (from %s)", name, len(merged), merged[0].DataSource)
+	} else if len(*f.Tracks["ADSB"]) != len(adsb) {
+		t.Errorf("merge mangled the flight's own tracks")
+	}
+
+	if name,_ := f.PreferredTrack([]string{"fr24", "MLAT"}); name != "MLAT" {
+		t.Errorf("PreferredTrack: got %q", name)
+	}
+}
+
+func TestTrackSpecIntersect(t *testing.T) {
+	report := NewTrackSpec("ADSB", "FA", "FOIA")
+
+	tests := []struct{
+		user     string
+		expected string // Empty if nothing should match
+	}{
+		{"",                 "ADSB,FA,FOIA"},
+		{"FOIA,ADSB",        "FOIA,ADSB"},
+		{"MLAT,FA:TA",       "FA:TA"},        // MLAT isn't allowed; FA:TA is, via the prefix
+		{"MLAT",             ""},
+		{"*,min=10,fused",   "ADSB,FA,FOIA,min=10,fused"},
+		{"FA,system=M",      "FA,system=M"},
+	}
+	for _,test := range tests {
+		ts,_ := ParseTrackSpec(test.user)
+		got,ok := ts.Intersect(report)
+		if !ok && test.expected != "" {
+			t.Errorf("%q: expected %q, saw nothing", test.user, test.expected)
+		} else if ok && got.String() != test.expected {
+			t.Errorf("%q: expected %q, saw %q (ok=%v)", test.user, test.expected, got, ok)
+		}
+	}
+
+	a,_ := ParseTrackSpec("*,system=A|M")
+	b,_ := ParseTrackSpec("ADSB,system=A")
+	if got,ok := a.Intersect(b); !ok || got.String() != "ADSB,system=A" {
+		t.Errorf("systems: saw %q (ok=%v)", got, ok)
+	}
+	c,_ := ParseTrackSpec("*,system=M")
+	if got,ok := c.Intersect(b); ok {
+		t.Errorf("disjoint systems: expected nothing, saw %q", got)
+	}
+}
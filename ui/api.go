@@ -6,6 +6,7 @@ import(
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/skypies/util/date"
@@ -20,6 +21,8 @@ import(
 
 // ?idspec=F12123@144001232[,...]
 // &json=1
+// &trackspec=ADSB,MLAT   (default is to fuse all tracks)
+// &tolerance=0.05        (simplify the track; see Simplification)
 
 func VectorHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	ctx := db.Ctx()
@@ -58,13 +61,17 @@ func VectorHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 func OutputFlightAsVectorJSON(db fgae.FlightDB, w http.ResponseWriter, r *http.Request, f *fdb.Flight) {
 	// This is such a botch job
 	ctx := db.Ctx()
-	trackspecs := widget.FormValueCommaSepStrings(r, "trackspec")
-	if len(trackspecs) == 0 {
-		trackspecs = []string{"FOIA", "ADSB", "MLAT", "FA", "fr24"}
+	trackspec,err := fdb.ParseTrackSpec(r.FormValue("trackspec"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if trackspec.IsNil() {
+		trackspec.Fuse = true // Use everything we've got
 	}
-	trackName,_ := f.PreferredTrack(trackspecs)
 
 	colorscheme := FormValueColorScheme(r)
+	simplification := FormValueSimplification(r)
 	complaintTimes := []time.Time{}
 	if colorscheme.Strategy == ByComplaints || colorscheme.Strategy == ByTotalComplaints {
 		client := db.HTTPClient()
@@ -86,7 +93,7 @@ func OutputFlightAsVectorJSON(db fgae.FlightDB, w http.ResponseWriter, r *http.R
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
-	lines := FlightToMapLines(f, trackName, colorscheme, complaintTimes)
+	lines := FlightToMapLines(f, trackspec, colorscheme, simplification, complaintTimes)
 	jsonBytes,err := json.Marshal(lines)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -134,8 +141,13 @@ func MapLineFormat(f *fdb.Flight, t fdb.Track, trackName string, l geo.LatlongLi
 		fallthrough
 	default:
 		color = "#223399" // FOIA
-		colorMap := map[string]string{"ADSB":"#dd6610", "fr24":"#08aa08", "FA":"#0808aa"}
-		if k,exists := colorMap[trackName]; exists { color = k }
+		colorMap := map[string]string{"ADSB":"#dd6610", "MLAT":"#aa10aa", "fr24":"#08aa08", "FA":"#0808aa"}
+
+		// Fused (and merged) tracks mix sources, so go by where this point came from
+		key := trackName
+		if _,exists := f.Tracks[trackName]; !exists { key = tp.TrackKey() }
+		key,_,_ = strings.Cut(key, ":") // "FA:TA" and "FA:TZ" are both "FA"
+		if k,exists := colorMap[key]; exists { color = k }
 	}
 	
 	return color,opacity
@@ -144,16 +156,15 @@ func MapLineFormat(f *fdb.Flight, t fdb.Track, trackName string, l geo.LatlongLi
 // }}}
 // {{{ FlightToMapLines
 
-func FlightToMapLines(f *fdb.Flight, trackName string, colorscheme ColorScheme, times []time.Time) []MapLine{
+func FlightToMapLines(f *fdb.Flight, trackspec fdb.TrackSpec, colorscheme ColorScheme, simplification Simplification, times []time.Time) []MapLine{
 	lines   := []MapLine{}
 
-	if trackName == "" { trackName = "fr24"}
-	
 	sampleRate := time.Millisecond * 2500
-	_,origTrack := f.PreferredTrack([]string{trackName})
+	trackName,origTrack := f.TrackBySpec(trackspec)
 
 	origTrack.PostProcess()
 	track := origTrack.AsSanityFilteredTrack()
+	f.HighlightGoArounds(track) // So that simplification doesn't smooth them away
 
 	// If a report said some data points were uninteresting, we remove them here.
 	toRemove := []int{}
@@ -167,15 +178,20 @@ func FlightToMapLines(f *fdb.Flight, trackName string, colorscheme ColorScheme,
 		track = append(track[:index], track[index+1:]...)
 	}
 	
-	flightLines := track.AsLinesSampledEvery(sampleRate)
+	flightLines := []geo.LatlongLine{}
+	if simplification.IsSet() {
+		flightLines = track.AsLinesSimplified(simplification.LateralKM, simplification.VerticalFeet,
+			f.WaypointIndices(track)...)
+	} else {
+		flightLines = track.AsLinesSampledEvery(sampleRate)
+	}
 
 	complaintCounts := make([]int, len(flightLines))
 	if colorscheme.Strategy == ByComplaints {
 		// Walk through lines; for each, bucket up the complaints that occur during it
 		j := 0
-		t := f.Tracks[trackName]
 		for i,l := range flightLines {
-			s, e := (*t)[l.I].TimestampUTC, (*t)[l.J].TimestampUTC
+			s, e := track[l.I].TimestampUTC, track[l.J].TimestampUTC
 			for j < len(times) {
 				if times[j].After(s) && !times[j].After(e) {
 					// This complaint timestamp hits this flightline
@@ -219,12 +235,39 @@ func FlightToMapLines(f *fdb.Flight, trackName string, colorscheme ColorScheme,
 
 // ?idspec=F12123@144001232:155001232   (note - time range - may return multiple matches)
 //   &trackdata=1                       (include trackdata; omitted by default)
+// Each flight's airframe fields are listed with their source: 'stored' in the flight, or
+// from the airframe 'cache'.
+
+// ?day=2017/04/01                      (all flights starting that day, in time order, paginated)
+//   &tags=FOIA                         (optional)
+//   &n=100                             (page size)
+//   &cursor=...                        (the 'next:' value from the previous page)
 
 func FlightLookupHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	ctx := db.Ctx()
 	opt,_ := GetUIOptions(ctx)
 	str := "OK\n"
 
+	if r.FormValue("day") != "" {
+		day := date.ArbitraryDatestring2MidnightPdt(r.FormValue("day"), "2006/01/02")
+		s,e := date.WindowForTime(day)
+		q := fgae.QueryForStartTimeRange(widget.FormValueCommaSepStrings(r, "tags"), s, e)
+
+		flights,next,err := db.LookupPage(q, r.FormValue("cursor"), widget.FormValueIntWithDefault(r,"n",100))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _,f := range flights {
+			str += fmt.Sprintf("  %s\n", f)
+		}
+		str += fmt.Sprintf("next: %s\n", next)
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(str))
+		return
+	}
+
 	idspecs,err := opt.IdSpecs()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -243,6 +286,7 @@ func FlightLookupHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Reques
 		} else {
 			for _,f := range flights {
 				str += fmt.Sprintf("  %s\n", f)
+				str += fmt.Sprintf("    airframe: %s\n", airframeProvenance(f))
 			}
 		}
 	}
@@ -251,6 +295,31 @@ func FlightLookupHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Reques
 	w.Write([]byte(str))
 }
 
+// }}}
+// {{{ airframeProvenance
+
+// Where each of the flight's airframe fields came from; the stored flight, or the airframe cache.
+func airframeProvenance(f *fdb.Flight) string {
+	fromCache := map[string]bool{}
+	for _,field := range f.AirframeFieldsFromOverlay() { fromCache[field] = true }
+
+	str := ""
+	for _,field := range []struct{ name, val string }{
+		{"Registration", f.Registration},
+		{"EquipmentType", f.EquipmentType},
+		{"CallsignPrefix", f.CallsignPrefix},
+	} {
+		source := "stored"
+		if field.val == "" {
+			source = "none"
+		} else if fromCache[field.name] {
+			source = "cache"
+		}
+		str += fmt.Sprintf("%s=%q[%s] ", field.name, field.val, source)
+	}
+	return str
+}
+
 // }}}
 
 // {{{ WriteEncodedData
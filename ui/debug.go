@@ -275,7 +275,7 @@ func track2frag(t fdb.Track, id,callsign string, start,length int) fdb.TrackFrag
 		DataSystem: fdb.DSADSB,
 	}
 	
-	if t[0].DataSource != "ADSB" {
+	if t[0].GetDataSystem() != fdb.DSADSB {
 		frag.DataSystem = fdb.DSMLAT
 	}
 
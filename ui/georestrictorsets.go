@@ -2,6 +2,7 @@ package ui
 
 import(
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"strings"
@@ -25,11 +26,14 @@ func RListHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 
 	if rsets,err := db.LookupRestrictorSets(opt.UserEmail); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if shared,err := db.LookupSharedRestrictorSets(opt.UserEmail); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	} else {
 		params := map[string]interface{}{
 			"UIOptions": opt,
 			"URIStem": uriStem,
 			"RestrictorSets": rsets,
+			"SharedRestrictorSets": shared,
 		}
 		if err := templates.ExecuteTemplate(w, "restrictors-list", params); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -70,6 +74,10 @@ func RGrsEditHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 
 	grs := fdb.GeoRestrictorSet{User:opt.UserEmail}
 	maybeLoadGRSDSKey(db, r, &grs)	// If we have a key, load it up to populate the grs
+	if err := grsAccessError(db, grs, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
 	// If no form data, display the grs in an edit form
 	if r.FormValue("name") == "" {
@@ -94,6 +102,8 @@ func RGrsEditHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	grs.Tags = widget.FormValueCommaSpaceSepStrings(r,"tags")
 	sort.Strings(grs.Tags)
 	for i,tag := range grs.Tags { grs.Tags[i] = strings.ToUpper(tag) }
+	grs.Public = widget.FormValueCheckbox(r, "public")
+	grs.SharedWith = widget.FormValueCommaSpaceSepStrings(r, "sharedwith")
 	
 	if err := db.PersistRestrictorSet(grs); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -116,6 +126,14 @@ func RGrsDeleteHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if grs,err := db.LoadRestrictorSet(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err := grsAccessError(db, grs, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	if err := db.DeleteRestrictorSet(key); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -136,6 +154,9 @@ func RGrsViewHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		http.Error(w, fmt.Sprintf("RGrViewHandler, err: %v", err), http.StatusBadRequest)
 		return
+	} else if err := grsAccessError(db, grs, false); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
 	
 	editUrl := fmt.Sprintf("%s/grs/edit?grs_dskey=%s", uriStem, grs.DSKey)
@@ -212,6 +233,9 @@ func RGrNewHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		http.Error(w, fmt.Sprintf("RGrNewHandler, err: %v", err), http.StatusBadRequest)
 		return
+	} else if err := grsAccessError(db, grs, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
 	
 	params := map[string]interface{}{
@@ -242,6 +266,9 @@ func RGrEditHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		http.Error(w, fmt.Sprintf("RGrNewHandler, err: %v", err), http.StatusBadRequest)
 		return
+	} else if err := grsAccessError(db, grs, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
 
 	grIndex := int(widget.FormValueInt64(r, "gr_index"))
@@ -304,6 +331,9 @@ func RGrDeleteHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("RGrNewHandler, err: %v", err), http.StatusBadRequest)
 		return
+	} else if err := grsAccessError(db, grs, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
 
 	grIndex := int(widget.FormValueInt64(r, "gr_index"))
@@ -325,6 +355,146 @@ func RGrDeleteHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request)
 
 // }}}
 
+// {{{ RGrsExportHandler
+
+// RGrsExportHandler - (key [,format=geojson|kml]) download the grs
+func RGrsExportHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+	grs,err := formValueDSKey(db, r)
+	if err != nil || grs.DSKey == "" {
+		http.Error(w, fmt.Sprintf("RGrsExportHandler, no grs (err: %v)", err), http.StatusBadRequest)
+		return
+	} else if err := grsAccessError(db, grs, false); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var b []byte
+	contentType,suffix := "application/geo+json", "geojson"
+	if r.FormValue("format") == "kml" {
+		contentType,suffix = "application/vnd.google-earth.kml+xml", "kml"
+		b,err = grs.ToKML()
+	} else {
+		b,err = grs.ToGeoJSON()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", grs.Name, suffix))
+	w.Write(b)
+}
+
+// }}}
+// {{{ RGrsImportHandler
+
+// RGrsImportHandler - (data|file) parse a GeoJSON or KML grs, save it as a new set, chain to ./list
+func RGrsImportHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+	opt,_ := GetUIOptions(db.Ctx())
+
+	data := []byte(r.FormValue("data"))
+	if len(data) == 0 {
+		if f,_,err := r.FormFile("file"); err != nil {
+			http.Error(w, fmt.Sprintf("RGrsImportHandler, nothing to import: %v", err), http.StatusBadRequest)
+			return
+		} else {
+			defer f.Close()
+			if data,err = ioutil.ReadAll(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var grs fdb.GeoRestrictorSet
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "<") {
+		grs,err = fdb.GeoRestrictorSetFromKML(data)
+	} else {
+		grs,err = fdb.GeoRestrictorSetFromGeoJSON(data)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RGrsImportHandler: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// It's a new set, belonging to whoever imported it; same conventions as RGrsEditHandler
+	grs.User = opt.UserEmail
+	grs.Name = strings.ToLower(grs.Name)
+	if grs.Name == "" { grs.Name = "imported" }
+	for i,tag := range grs.Tags { grs.Tags[i] = strings.ToUpper(tag) }
+	sort.Strings(grs.Tags)
+
+	if err := db.PersistRestrictorSet(grs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w,r, uriStem+"/list", http.StatusFound)
+}
+
+// }}}
+// {{{ RGrsVersionsHandler
+
+// RGrsVersionsHandler - (key) render [grs-versions]
+func RGrsVersionsHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+	ctx := db.Ctx()
+	opt,_ := GetUIOptions(ctx)
+	templates := hw.GetTemplates(ctx)
+
+	grs,err := formValueDSKey(db, r)
+	if err != nil || grs.DSKey == "" {
+		http.Error(w, fmt.Sprintf("RGrsVersionsHandler, no grs (err: %v)", err), http.StatusBadRequest)
+		return
+	} else if err := grsAccessError(db, grs, false); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	versions,err := db.LookupRestrictorSetVersions(grs.DSKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	params := map[string]interface{}{
+		"URIStem": uriStem,
+		"UIOptions": opt,
+		"GRS": grs,
+		"CanEdit": grs.CanEdit(opt.UserEmail),
+		"Versions": versions,
+	}
+	if err := templates.ExecuteTemplate(w, "restrictors-grs-versions", params); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// }}}
+// {{{ RGrsRestoreHandler
+
+// RGrsRestoreHandler - (key,version) make that version current again, chain to ./grs/edit
+func RGrsRestoreHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+	grs,err := formValueDSKey(db, r)
+	if err != nil || grs.DSKey == "" {
+		http.Error(w, fmt.Sprintf("RGrsRestoreHandler, no grs (err: %v)", err), http.StatusBadRequest)
+		return
+	} else if err := grsAccessError(db, grs, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	version := int(widget.FormValueInt64(r, "version"))
+	if err := db.RestoreRestrictorSetVersion(grs.DSKey, version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w,r, uriStem+"/grs/edit?grs_dskey="+grs.DSKey, http.StatusFound)
+}
+
+// }}}
+
 // {{{ RDebHandler
 
 func RDebHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
@@ -367,6 +537,24 @@ func formValueDSKey(db fgae.FlightDB, r *http.Request) (fdb.GeoRestrictorSet, er
 	return grs,err
 }
 
+// }}}
+// {{{ grsAccessError
+
+// grsAccessError says why the current user can't edit (or view) the grs, if they can't. Sets
+// that haven't been saved yet belong to whoever is making them.
+func grsAccessError(db fgae.FlightDB, grs fdb.GeoRestrictorSet, edit bool) error {
+	opt,_ := GetUIOptions(db.Ctx())
+
+	if grs.DSKey == "" {
+		return nil
+	} else if edit && !grs.CanEdit(opt.UserEmail) {
+		return fmt.Errorf("restrictor set '%s' belongs to %s; you can't change it", grs.Name, grs.User)
+	} else if !edit && !grs.CanView(opt.UserEmail) {
+		return fmt.Errorf("restrictor set '%s' has not been shared with you", grs.Name)
+	}
+	return nil
+}
+
 // }}}
 // {{{ formValueFlightsViaIdspecs
 
@@ -385,8 +573,6 @@ func formValueFlightsViaIdspecs(db fgae.FlightDB, r *http.Request) ([]*fdb.Fligh
 		flights = append(flights, f)
 	}
 
-	db.MergeCachedAirframes(flights)
-
 	return flights,nil
 }
 
@@ -395,7 +581,7 @@ func formValueFlightsViaIdspecs(db fgae.FlightDB, r *http.Request) ([]*fdb.Fligh
 // {{{ flightToRestrictedMapPoints
 
 func flightToRestrictedMapPoints(f *fdb.Flight, grs fdb.GeoRestrictorSet) []MapPoint {
-	if tName, t := f.PreferredTrack([]string{"FOIA", "ADSB", "MLAT"}); tName == "" {
+	if tName, t := f.TrackBySpec(fdb.IntersectableTrackSpec); tName == "" {
 		return nil
 	} else {
 		t.PostProcess()  // Move upstream ?
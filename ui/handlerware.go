@@ -29,14 +29,40 @@ func GetUIOptions(ctx context.Context) (UIOptions,bool) {
 	return opt, ok
 }
 
+// If set (by the app's init), the FlightDBs that handlers get publish their changes to it.
+var Changes fgae.ChangeSink
+
+// If set (by the app's init), the FlightDBs that handlers get look in it for flights that have
+// been archived and deleted from datastore.
+var Archive fgae.ArchiveStore
+
+// newFdb builds the FlightDB that handlers get. Flights they look up don't have the airframe
+// cache overlaid, unless the handler is wrapped in WithAirframes.
+func newFdb(ctx context.Context) fgae.FlightDB {
+	p := ds.GetProviderOrPanic(ctx) // PANICs if not found
+	db := fgae.New(ctx, p)
+	db.Changes = Changes
+	db.Archive = Archive
+	return db
+}
+
+// WithAirframes turns on the airframe cache overlay (see fgae/airframes.go), for handlers that
+// only look up and render flights. Handlers that might persist the flights they look up mustn't
+// use it, or the cache data gets baked into the stored flights.
+func WithAirframes(fh FdbHandler) FdbHandler {
+	return func(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+		db.OverlayAirframes = true
+		fh(db, w, r)
+	}
+}
+
 // WithFdb does a few things: creates a context, requires user to be
 // logged in (redirecting if they're not), looks for report options
 // and injects them into context if found, and then finally calls the
 // FdbHandler.
 func WithFdb(fh FdbHandler) hw.BaseHandler {
 	runFdbHandler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		p := ds.GetProviderOrPanic(ctx) // PANICs if not found
-		fdb := fgae.New(ctx, p)
+		fdb := newFdb(ctx)
 		fh(fdb, w, r)
 	}
 	return hw.WithCtx(MaybeWithUiOptions(runFdbHandler))
@@ -51,8 +77,7 @@ func WithoutFdb(ch hw.ContextHandler) FdbHandler {
 
 func WithFdbSession(fh FdbHandler) hw.BaseHandler {
 	runFdbHandler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		p := ds.GetProviderOrPanic(ctx) // PANICs if not found
-		fdb := fgae.New(ctx, p)
+		fdb := newFdb(ctx)
 		fh(fdb, w, r)
 	}
 	return hw.WithSession(MaybeWithUiOptions(runFdbHandler))
@@ -61,8 +86,7 @@ func WithFdbSession(fh FdbHandler) hw.BaseHandler {
 // WithFdbAdmin either requires an admin user, or that the request comes from within appengine
 func WithFdbAdmin(fh FdbHandler) hw.BaseHandler {
 	runFdbHandler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		p := ds.GetProviderOrPanic(ctx) // PANICs if not found
-		fdb := fgae.New(ctx, p)
+		fdb := newFdb(ctx)
 		fh(fdb, w, r)
 	}
 	return hw.WithAdmin(MaybeWithUiOptions(runFdbHandler))
@@ -165,8 +189,7 @@ func WithFdbCtxOptTmplUser(f widget.CtxMaker, t *template.Template, fh FdbHandle
 
 func WithFdb(fh FdbHandler) widget.ContextHandler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		p := ds.GetProviderOrPanic(ctx) // PANICs if not found
-		fdb := fgae.New(ctx, p)
+		fdb := newFdb(ctx)
 		fh(fdb, w, r)
 	}
 }
@@ -69,8 +69,6 @@ func JsonHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	db.MergeCachedAirframes(flights)
-	
 	jsonBytes,err := json.MarshalIndent(flights, "", " ")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
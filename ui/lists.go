@@ -2,6 +2,9 @@ package ui
 
 import(
 	"net/http"
+	"net/url"
+
+	"github.com/skypies/util/date"
 
 	hw "github.com/skypies/util/handlerware"
 	"github.com/skypies/util/widget"
@@ -11,6 +14,9 @@ import(
 )
 
 // icaoid=A12345 - lookup recent flights on that airframe
+// day=2017/04/01 - page through all the flights that started that day (PDT), in time order
+//   &n=200           - page size
+//   &cursor=...      - the page to start at (see the 'next page' link)
 func ListHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	ctx := db.Ctx()
 	templates := hw.GetTemplates(ctx)
@@ -18,6 +24,11 @@ func ListHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	tags := widget.FormValueCommaSepStrings(r, "tags")
 	flights := []*fdb.Flight{}
 
+	if r.FormValue("day") != "" {
+		listDayHandler(db, w, r, tags)
+		return
+	}
+
 	query := fgae.QueryForRecent(tags, 200)
 	if r.FormValue("icaoid") != "" {
 		query = fgae.QueryForRecentIcaoId(r.FormValue("icaoid"), 200)
@@ -47,6 +58,44 @@ func ListHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// {{{ listDayHandler
+
+func listDayHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request, tags []string) {
+	templates := hw.GetTemplates(db.Ctx())
+
+	day := date.ArbitraryDatestring2MidnightPdt(r.FormValue("day"), "2006/01/02")
+	s,e := date.WindowForTime(day)
+	q := fgae.QueryForStartTimeRange(tags, s, e)
+
+	flights,next,err := db.LookupPage(q, r.FormValue("cursor"), widget.FormValueIntWithDefault(r, "n", 200))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _,f := range flights {
+		f.PruneTrackContents() // Save on RAM
+	}
+
+	nextUrl := ""
+	if next != "" {
+		vals := url.Values{}
+		for k,v := range r.Form { vals[k] = v }
+		vals.Set("cursor", next)
+		nextUrl = "?" + vals.Encode()
+	}
+
+	var params = map[string]interface{}{
+		"Tags": tags,
+		"Flights": flights,
+		"NextPageUrl": nextUrl,
+	}
+	if err := templates.ExecuteTemplate(w, "fdb-recentlist", params); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// }}}
+
 // {{{ -------------------------={ E N D }=----------------------------------
 
 // Local variables:
@@ -108,7 +108,7 @@ func (mp MapPoint)ToJSStr(text string) string {
 	if mp.ITP != nil {
 		// Transform this into a tp with extra text
 		tp = mp.ITP.Trackpoint
-		tp.DataSource += "/interp"
+		tp.DataSource = mp.ITP.Pre.SourceLabel() + "/interp"
 		mp.Text = fmt.Sprintf("** Interpolated trackpoint\n"+
 			" * Pre :%s\n * This:%s\n * Post:%s\n * Ratio: %.2f\n%s",
 			mp.ITP.Pre, mp.ITP, mp.ITP.Post, mp.ITP.Ratio, mp.Text)
@@ -263,7 +263,7 @@ func TrackToMapPoints(t *fdb.Track, icon, banner string, coloring ColoringStrate
 				//	tp.Long += 0.0006
 				//}
 			} else if coloring == ByData {
-				if c,exists := sourceColors[tp.DataSource]; exists { color = c }
+				if c,exists := sourceColors[tp.TrackKey()]; exists { color = c }
 			}
 		}
 
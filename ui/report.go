@@ -60,8 +60,11 @@ func ReportHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 			if rsets,err := db.LookupRestrictorSets(opt.UserEmail); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
+			} else if shared,err := db.LookupSharedRestrictorSets(opt.UserEmail); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			} else {
-				params["RestrictorSets"] = rsets
+				params["RestrictorSets"] = append(rsets, shared...)
 			}
 		} 
 
@@ -88,14 +91,20 @@ func ReportHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	idspecsRejectByReport := []string{}
 
 	query := fgae.QueryForTimeRangeWaypoint(rep.Tags, rep.Options.Waypoints, rep.Start,rep.End)
-	it := db.NewIterator(query)
+	var pi *fgae.ParallelFlightIterator
+	if !rep.Options.GRS.IsNil() && fgae.CellsIndexedFor(rep.Start) {
+		// Skip flights that can't possibly match
+		pi = db.NewRestrictedParallelIterator(query, rep.Options.GRS, rep.Start,rep.End, 0)
+	} else {
+		pi = db.NewParallelIterator(query, 0)
+	}
+	defer pi.Cancel()
 	n := 0
 	tStart := time.Now()
 	tBottomOfLoop := tStart
-	for it.Iterate(ctx) {
+	for f := range pi.Flights() {
 		rep.Stats.RecordValue("flightfetch", (time.Since(tBottomOfLoop).Nanoseconds()/1000))
 
-		f := it.Flight()
 		n++
 		
 		outcome,err := rep.Process(f)
@@ -116,8 +125,8 @@ func ReportHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 			idspecsAccepted = append(idspecsAccepted, f.IdSpecString())
 		}
 	}
-	if it.Err() != nil {
-		errStr := fmt.Sprintf("Iter err after %d (%s): %v", n, time.Since(tStart), it.Err())
+	if pi.Err() != nil {
+		errStr := fmt.Sprintf("Iter err after %d (%s): %v", n, time.Since(tStart), pi.Err())
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
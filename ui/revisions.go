@@ -0,0 +1,98 @@
+package ui
+
+import(
+	"fmt"
+	"net/http"
+
+	"github.com/skypies/util/widget"
+
+	fdb "github.com/skypies/flightdb"
+	"github.com/skypies/flightdb/fgae"
+)
+
+// {{{ FlightRevisionsHandler
+
+// /api/flight/revisions?flightkey=...      (lists the revisions of the flight)
+//   &diff=REVKEY1,REVKEY2                  (diffs two of them; use 'current' for the live version)
+
+func FlightRevisionsHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+	keyer,err := db.Backend.DecodeKey(r.FormValue("flightkey"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	str := "OK\n"
+
+	if diffs := widget.FormValueCommaSepStrings(r, "diff"); len(diffs) > 0 {
+		if len(diffs) != 2 {
+			http.Error(w, "need &diff=REVKEY1,REVKEY2", http.StatusBadRequest)
+			return
+		}
+
+		flights := []*fdb.Flight{}
+		for _,revkey := range diffs {
+			var f *fdb.Flight
+			if revkey == "current" {
+				f,err = db.LookupKey(keyer)
+			} else if revKeyer,err2 := db.Backend.DecodeKey(revkey); err2 != nil {
+				err = err2
+			} else {
+				f,err = db.LookupRevision(revKeyer)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			flights = append(flights, f)
+		}
+
+		str += fmt.Sprintf("--- %s\n+++ %s\n", diffs[0], diffs[1])
+		str += fdb.DiffFlights(flights[0], flights[1]).String()
+
+	} else {
+		revs,err := db.ListRevisions(keyer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		str += fmt.Sprintf("%d revisions of %s\n", len(revs), keyer.Encode())
+		for _,rev := range revs {
+			str += fmt.Sprintf("* %s\n", rev)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(str))
+}
+
+// }}}
+// {{{ FlightRollbackHandler
+
+// /api/flight/rollback?revkey=...          (makes the revision the live version of its flight)
+
+func FlightRollbackHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
+	revKeyer,err := db.Backend.DecodeKey(r.FormValue("revkey"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.RollbackToRevision(revKeyer); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("OK\nrolled back to %s\n", revKeyer.Encode())))
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
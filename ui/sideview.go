@@ -68,10 +68,6 @@ func SideviewHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// This might be a little problematic, in that it will call datastore each for
-		// each flight in the PDF. May need something cleverer.
-		db.MergeCachedAirframes([]*fdb.Flight{f})
-		
 		if err := SideviewPDFAddFlight(opt, r, svp, metars, f, (n==0)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -203,17 +199,23 @@ func SideviewPDFFinalize(opt UIOptions, w http.ResponseWriter, r *http.Request,
 // Extracts a bunch of args from the request (sample, DateRange widget)
 
 func flightToAltitudeTrack(opt UIOptions, r *http.Request, metars *metar.Archive, f *fdb.Flight) (fdb.Track, error) {
-	trackKeyName,track := f.PreferredTrack([]string{"ADSB", "MLAT", "FOIA", "FA", "fr24"})
-	if track == nil {
+	trackspec,err := fdb.ParseTrackSpec(r.FormValue("trackspec"))
+	if err != nil { return nil, err }
+	_,track := f.TrackBySpec(trackspec.WithDefaultNames("ADSB", "MLAT", "FOIA", "FA", "fr24"))
+	if len(track) == 0 {
 		return nil, fmt.Errorf("no track found (saw %q)", f.ListTracks())
 	}
 
 	sampleRate := widget.FormValueDuration(r, "sample")
 	if sampleRate == 0 { sampleRate = 15 * time.Second }
 	track = track.SampleEvery(sampleRate, false)
-	track.PostProcess()
+	if widget.FormValueCheckbox(r, "smooth") {
+		track.PostProcessSmoothed()
+	} else {
+		track.PostProcess()
+	}
 	
-	if trackKeyName == "FOIA" {
+	if track[0].GetDataSystem() == fdb.DSCorrectedRadar {
 		track.AdjustAltitudes(nil) // FOIA track altitudes are already pressure-corrected
 
 	} else {
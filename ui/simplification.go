@@ -0,0 +1,38 @@
+package ui
+
+import(
+	"html/template"
+	"fmt"
+	"net/http"
+
+	"github.com/skypies/util/widget"
+	fdb "github.com/skypies/flightdb"
+)
+
+// Simplification says how much to simplify tracks before rendering them (see
+// fdb.Track.Simplify). The zero value means no simplification.
+//  &tolerance=0.05     lateral tolerance, in KM
+//  &vtolerance=200     vertical tolerance, in feet (defaults to fdb.KSimplifyVerticalFeet)
+type Simplification struct {
+	LateralKM     float64
+	VerticalFeet  float64
+}
+
+func FormValueSimplification(r *http.Request) Simplification {
+	s := Simplification{
+		LateralKM: widget.FormValueFloat64EatErrs(r, "tolerance"),
+		VerticalFeet: widget.FormValueFloat64EatErrs(r, "vtolerance"),
+	}
+	if s.LateralKM > 0 && s.VerticalFeet <= 0 {
+		s.VerticalFeet = fdb.KSimplifyVerticalFeet
+	}
+	return s
+}
+
+func (s Simplification)IsSet() bool { return s.LateralKM > 0 }
+
+// Call inside the template, e.g. var url = "/foo?" + {{.Simplification.QuotedCGIArgs}}
+func (s Simplification)QuotedCGIArgs() template.JS {
+	if !s.IsSet() { return template.JS(`""`) }
+	return template.JS(fmt.Sprintf(`"tolerance=%g&vtolerance=%g"`, s.LateralKM, s.VerticalFeet))
+}
@@ -5,7 +5,6 @@ import(
 	"html/template"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 	
 	"context"
@@ -58,6 +57,8 @@ func maybeAddFr24Track(db fgae.FlightDB, f *fdb.Flight) string {
 // {{{ TrackHandler
 
 //  &all=1 [&colorby=candy]  - show all instances of the IdSpec [prob want coloring]
+//  &track=fused [&colorby=src] - show the fused best-estimate track, and where its points came from
+//  &smooth=1                - derive speeds, rates etc. from the smoothed track
 
 func TrackHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	// This whole Airframe cache thing should be automatic, and upstream from here.
@@ -95,14 +96,14 @@ func TrackHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 		}			
 	}
 
-	db.MergeCachedAirframes(flights)
-
 	OutputTrackpointsOnAMap(db, w, r, flights)
 }
 
 // }}}
 // {{{ TracksetHandler
 
+//  &tolerance=0.05 [&vtolerance=200]  - simplify the tracks before sending them (KM, feet)
+
 func TracksetHandler(db fgae.FlightDB, w http.ResponseWriter, r *http.Request) {
 	ctx := db.Ctx()
 	opt,_ := GetUIOptions(ctx)
@@ -235,8 +236,14 @@ func OutputTrackpointsOnAMap(db fgae.FlightDB, w http.ResponseWriter, r *http.Re
 
 	} else if len(flights) == 1 {
 		f := flights[0]
+		// &track=fused shows the best-estimate track; &colorby=src colors points by their origin
+		if r.FormValue("track") == "fused" {
+			fused := f.FusedTrack()
+			f.Tracks["fused"] = &fused
+		}
+
 		// Pick most recent instance, and colorize all visible tracks.
-		for _,trackType := range []string{"ADSB", "MLAT", "fr24", "FA:TA", "FA:TZ", "FOIA"} {
+		for _,trackType := range []string{"ADSB", "MLAT", "fr24", "FA:TA", "FA:TZ", "FOIA", "fused"} {
 			if len(r.FormValue("track")) > 1 && r.FormValue("track") != trackType { continue }
 			if _,exists := f.Tracks[trackType]; !exists { continue }
 
@@ -248,7 +255,12 @@ func OutputTrackpointsOnAMap(db fgae.FlightDB, w http.ResponseWriter, r *http.Re
 			}
 			
 			track := f.Tracks[trackType]
-			track.PostProcess()  // Move upstream ?
+			if widget.FormValueCheckbox(r, "smooth") {
+				track.PostProcessSmoothed()
+			} else {
+				track.PostProcess()  // Move upstream ?
+			}
+			f.HighlightGoArounds(*track)
 
 			// &clip1=EPICK&clip2=EDDYY
 			if r.FormValue("clip1") != "" {
@@ -305,6 +317,7 @@ func OutputTrackpointsOnAMap(db fgae.FlightDB, w http.ResponseWriter, r *http.Re
 // ?idspec==XX,YY,...
 //  &colorby=procedure   (what we tagged them as - not implemented ?)
 //  &nofurniture=1       (to suppress furniture)
+//  &tolerance=0.05      (simplify the tracks; see Simplification)
 
 func OutputMapLinesOnAStreamingMap(ctx context.Context, w http.ResponseWriter, r *http.Request, vectorURLPath string) {
 	opt,_ := GetUIOptions(ctx)
@@ -322,7 +335,7 @@ func OutputMapLinesOnAStreamingMap(ctx context.Context, w http.ResponseWriter, r
 		if r.FormValue("nofurniture") == "" {
 			ms.Add(renderReportFurniture(opt.Report))
 		}
-		trackspec = strings.Join(opt.Report.ListPreferredDataSources(), ",")
+		trackspec = opt.Report.PreferredTrackSpec().String()
 		legend += ", "+opt.Report.DescriptionText()
 	}
 	
@@ -333,6 +346,7 @@ func OutputMapLinesOnAStreamingMap(ctx context.Context, w http.ResponseWriter, r
 		"VectorURLPath": vectorURLPath,  // retire this when DBv1/v2ui.go and friends are gone
 		"TrackSpec": trackspec,
 		"ColorScheme": opt.ColorScheme,
+		"Simplification": FormValueSimplification(r),
 		"Report": opt.Report,  // So that any rendering hints can be determined
 		
 		"Waypoints": WaypointMapVar(sfo.KFixes),